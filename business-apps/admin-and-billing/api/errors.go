@@ -0,0 +1,116 @@
+// Package api holds what every /api handler shares: decoding and validating
+// requests, and writing errors as a consistent JSON envelope:
+//
+//	{"error": {"code": "validation_failed", "message": "...", "fields": [{"field": "user_id", "message": "..."}]}}
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Error codes
+const (
+	CodeBadRequest          = "bad_request"
+	CodeInvalidJSON         = "invalid_json"
+	CodeValidationFailed    = "validation_failed"
+	CodeRuleViolation       = "rule_violation"
+	CodeUnauthorized        = "unauthorized"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeConflict            = "conflict"
+	CodeDuplicateEntry      = "duplicate_entry"
+	CodeInsufficientBalance = "insufficient_balance"
+	CodeRateLimited         = "rate_limited"
+	CodeInternal            = "internal_error"
+)
+
+// FieldError describes what is wrong with one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type Error struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+type envelope struct {
+	Error Error `json:"error"`
+}
+
+// WriteError writes the error envelope with the given status.
+func WriteError(w http.ResponseWriter, status int, code, message string, fields ...FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(envelope{Error{Code: code, Message: message, Fields: fields}})
+}
+
+func BadRequest(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusBadRequest, CodeBadRequest, message)
+}
+
+func Unauthorized(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusUnauthorized, CodeUnauthorized, message)
+}
+
+func NotFound(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusNotFound, CodeNotFound, message)
+}
+
+func Conflict(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusConflict, CodeConflict, message)
+}
+
+func InsufficientBalance(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusPaymentRequired, CodeInsufficientBalance, message)
+}
+
+func TooManyRequests(w http.ResponseWriter, message string) {
+	WriteError(w, http.StatusTooManyRequests, CodeRateLimited, message)
+}
+
+// InternalError logs err and responds without exposing database details.
+func InternalError(w http.ResponseWriter, err error) {
+	log.Printf("Internal error: %v\n", err)
+	WriteError(w, http.StatusInternalServerError, CodeInternal, "Something went wrong, please try again")
+}
+
+// DecodeJSON decodes the request body into v. On failure it writes a 400 and
+// returns false.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		WriteError(w, http.StatusBadRequest, CodeInvalidJSON, "Request body is not valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// NotFoundHandler and MethodNotAllowedHandler answer unknown /api routes.
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	NotFound(w, "No such endpoint")
+}
+
+func MethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not allowed here")
+}
+
+// Recoverer turns a panicking handler into a 500 error envelope.
+func Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				log.Printf("Panic serving %s %s: %v\n", r.Method, r.URL.Path, rec)
+				WriteError(w, http.StatusInternalServerError, CodeInternal, "Something went wrong, please try again")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
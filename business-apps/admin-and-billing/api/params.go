@@ -0,0 +1,130 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Validator collects field errors so a request reports every problem at
+// once rather than the first one.
+type Validator struct {
+	Errors []FieldError
+}
+
+// Check records message against field unless ok.
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.Errors = append(v.Errors, FieldError{Field: field, Message: message})
+	}
+}
+
+// Failed writes a validation error and returns true if any check failed.
+func (v *Validator) Failed(w http.ResponseWriter) bool {
+	if len(v.Errors) == 0 {
+		return false
+	}
+	WriteError(w, http.StatusBadRequest, CodeValidationFailed, "Some fields are invalid", v.Errors...)
+	return true
+}
+
+// Rejected writes a 422 listing every violated rule and returns true if any
+// check failed. Use it for well-formed requests the business rules refuse.
+func (v *Validator) Rejected(w http.ResponseWriter) bool {
+	if len(v.Errors) == 0 {
+		return false
+	}
+	WriteError(w, http.StatusUnprocessableEntity, CodeRuleViolation, "Request breaks one or more rules", v.Errors...)
+	return true
+}
+
+// Params reads typed values from the query string or URL path.
+type Params struct {
+	Validator
+	get func(string) string
+}
+
+func Query(r *http.Request) *Params {
+	q := r.URL.Query()
+	return &Params{get: q.Get}
+}
+
+func Path(r *http.Request) *Params {
+	return &Params{get: func(name string) string { return chi.URLParam(r, name) }}
+}
+
+// Has reports whether name was given.
+func (p *Params) Has(name string) bool {
+	return p.get(name) != ""
+}
+
+func (p *Params) String(name string, required bool) string {
+	s := p.get(name)
+	p.Check(s != "" || !required, name, "is required")
+	return s
+}
+
+// Int parses a positive integer such as an id; missing optional values are 0.
+func (p *Params) Int(name string, required bool) int {
+	s := p.get(name)
+	if s == "" {
+		p.Check(!required, name, "is required")
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	p.Check(err == nil && n > 0, name, "must be a positive whole number")
+	return n
+}
+
+// Float parses a number such as an amount; missing optional values are 0.
+func (p *Params) Float(name string, required bool) float64 {
+	s := p.get(name)
+	if s == "" {
+		p.Check(!required, name, "is required")
+		return 0
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	p.Check(err == nil, name, "must be a number")
+	return f
+}
+
+// Date parses YYYY-MM-DD; missing optional values are the zero time.
+func (p *Params) Date(name string, required bool) time.Time {
+	return p.parseTime(name, required, "2006-01-02", "must be a date in YYYY-MM-DD format")
+}
+
+// Month parses YYYY-MM as the first day of the month.
+func (p *Params) Month(name string, required bool) time.Time {
+	return p.parseTime(name, required, "2006-01", "must be a month in YYYY-MM format")
+}
+
+func (p *Params) parseTime(name string, required bool, layout, message string) time.Time {
+	s := p.get(name)
+	if s == "" {
+		p.Check(!required, name, "is required")
+		return time.Time{}
+	}
+	t, err := time.Parse(layout, s)
+	p.Check(err == nil, name, message)
+	return t
+}
+
+// DateRange checks that end is not before start when both were given.
+func (v *Validator) DateRange(startField string, start time.Time, endField string, end time.Time) {
+	if !start.IsZero() && !end.IsZero() {
+		v.Check(!end.Before(start), endField, "must not be before "+startField)
+	}
+}
+
+// ParseDate parses a YYYY-MM-DD value from a request body.
+func (v *Validator) ParseDate(field, s string) time.Time {
+	if s == "" {
+		v.Check(false, field, "is required")
+		return time.Time{}
+	}
+	t, err := time.Parse("2006-01-02", s)
+	v.Check(err == nil, field, "must be a date in YYYY-MM-DD format")
+	return t
+}
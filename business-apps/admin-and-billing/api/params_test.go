@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValidatorWrites(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     []bool
+		reject     bool
+		wantStatus int
+		wantCode   string
+		wantFields []string
+	}{
+		{"all pass", []bool{true, true}, false, 0, "", nil},
+		{"all pass rejected", []bool{true}, true, 0, "", nil},
+		{"one fails", []bool{true, false}, false, http.StatusBadRequest, CodeValidationFailed, []string{"f1"}},
+		{"every failure reported", []bool{false, false}, false, http.StatusBadRequest, CodeValidationFailed, []string{"f0", "f1"}},
+		{"rule broken", []bool{false}, true, http.StatusUnprocessableEntity, CodeRuleViolation, []string{"f0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			for i, ok := range tt.checks {
+				v.Check(ok, fmt.Sprintf("f%d", i), "is wrong")
+			}
+			w := httptest.NewRecorder()
+			var wrote bool
+			if tt.reject {
+				wrote = v.Rejected(w)
+			} else {
+				wrote = v.Failed(w)
+			}
+
+			if wrote != (tt.wantStatus != 0) {
+				t.Fatalf("wrote = %v, want %v", wrote, tt.wantStatus != 0)
+			}
+			if !wrote {
+				if w.Body.Len() != 0 {
+					t.Errorf("body = %q, want nothing written", w.Body.String())
+				}
+				return
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			var body envelope
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Error.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.wantCode)
+			}
+			var fields []string
+			for _, f := range body.Error.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidatorParseDate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr string
+	}{
+		{"2026-03-31", time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), ""},
+		{"", time.Time{}, "is required"},
+		{"31/03/2026", time.Time{}, "must be a date in YYYY-MM-DD format"},
+		{"2026-02-30", time.Time{}, "must be a date in YYYY-MM-DD format"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			var v Validator
+			got := v.ParseDate("date", tt.in)
+			if !got.Equal(tt.want) {
+				t.Errorf("ParseDate(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+			if msg := message(v); msg != tt.wantErr {
+				t.Errorf("ParseDate(%q) error = %q, want %q", tt.in, msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatorDateRange(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2026, 4, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name       string
+		start, end time.Time
+		wantErr    string
+	}{
+		{"in order", day(1), day(30), ""},
+		{"same day", day(5), day(5), ""},
+		{"reversed", day(30), day(1), "must not be before start"},
+		{"no start", time.Time{}, day(1), ""},
+		{"no end", day(1), time.Time{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v Validator
+			v.DateRange("start", tt.start, "end", tt.end)
+			if msg := message(v); msg != tt.wantErr {
+				t.Errorf("error = %q, want %q", msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+// message returns the only error recorded by v, or "" if there is none.
+func message(v Validator) string {
+	if len(v.Errors) == 0 {
+		return ""
+	}
+	return v.Errors[0].Message
+}
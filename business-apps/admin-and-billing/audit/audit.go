@@ -0,0 +1,100 @@
+// Package audit keeps an append-only record of every change made through the
+// API. Each row carries a hash of its content and of the row before it, so
+// editing or removing history can be detected with Verify.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+)
+
+// ActorHeader names who is making the request. The admin UI sends the
+// operator's name.
+const ActorHeader = "X-Actor"
+
+// Change describes one mutation. Before is nil for creations and After is
+// nil for deletions.
+type Change struct {
+	Action     string
+	EntityType string
+	EntityID   string
+	Before     json.RawMessage
+	After      json.RawMessage
+}
+
+// Snapshot returns the row of table whose keyColumn equals key as JSON, or
+// nil if there is no such row. table and keyColumn must not come from the
+// request.
+func Snapshot(ctx context.Context, tx pgx.Tx, table, keyColumn string, key interface{}) (json.RawMessage, error) {
+	var row json.RawMessage
+	err := tx.QueryRow(ctx, `SELECT TO_JSONB(t) FROM `+table+` t WHERE `+keyColumn+` = $1`, key).Scan(&row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return row, err
+}
+
+// Source is who made a change and from where.
+type Source struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+// System is the source of changes made by scheduled jobs.
+var System = Source{Actor: "system"}
+
+// FromRequest takes the source of a change from the request making it. The
+// actor is whoever the ActorHeader names, or "unknown".
+func FromRequest(r *http.Request) Source {
+	actor := strings.TrimSpace(r.Header.Get(ActorHeader))
+	if actor == "" {
+		actor = "unknown"
+	}
+	return Source{Actor: actor, RequestID: middleware.GetReqID(r.Context()), IP: clientIP(r)}
+}
+
+// Record appends c to the audit log in tx, so the entry is kept only if the
+// change itself commits.
+//
+// Appending takes a lock on the whole log that is held until tx ends, so
+// every transaction that records a change runs one at a time from then on.
+// Take any row locks the transaction needs, wallets in particular, before
+// calling Record; locking them afterwards can deadlock with a transaction
+// that locked them first and is waiting to record its own change.
+func Record(ctx context.Context, tx pgx.Tx, src Source, c Change) error {
+	_, err := tx.Exec(ctx, `
+		INSERT INTO AUDIT_LOG (ACTOR, ACTION, ENTITY_TYPE, ENTITY_ID, BEFORE_DATA, AFTER_DATA, REQUEST_ID, IP)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''))
+	`, src.Actor, c.Action, c.EntityType, c.EntityID, c.Before, c.After, src.RequestID, src.IP)
+	return err
+}
+
+// RecordRow records a change to the row of table whose keyColumn equals key,
+// reading the row's state after the change from tx. before is nil for
+// creations. table and keyColumn must not come from the request.
+func RecordRow(ctx context.Context, tx pgx.Tx, src Source, action, entityType, table, keyColumn string, key interface{}, before json.RawMessage) error {
+	after, err := Snapshot(ctx, tx, table, keyColumn, key)
+	if err != nil {
+		return err
+	}
+	return Record(ctx, tx, src, Change{
+		Action: action, EntityType: entityType, EntityID: fmt.Sprint(key), Before: before, After: after,
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,258 @@
+package billing
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/xlsx"
+)
+
+// Finished exports are kept in memory for this long
+const bulkJobTTL = time.Hour
+
+type bulkJob struct {
+	status      model.BulkBillJob
+	fileName    string
+	contentType string
+	output      []byte
+}
+
+var (
+	bulkJobsMu sync.Mutex
+	bulkJobs   = make(map[string]*bulkJob)
+)
+
+var bulkFormats = map[string]string{
+	"zip":  "application/zip",
+	"csv":  "text/csv",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// StartBulkBills starts building every user's bill for a period in the
+// background. Poll GetBulkBillJob for progress and fetch the result from
+// DownloadBulkBills.
+func StartBulkBills(w http.ResponseWriter, r *http.Request) {
+	var req model.BulkBillRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	startDate := v.ParseDate("start_date", req.StartDate)
+	endDate := v.ParseDate("end_date", req.EndDate)
+	v.DateRange("start_date", startDate, "end_date", endDate)
+	if req.Format == "" {
+		req.Format = "zip"
+	}
+	contentType, ok := bulkFormats[req.Format]
+	v.Check(ok, "format", "must be zip, csv or xlsx")
+	if v.Failed(w) {
+		return
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	job := &bulkJob{
+		status: model.BulkBillJob{
+			JobID:     hex.EncodeToString(id),
+			Format:    req.Format,
+			StartDate: startDate,
+			EndDate:   endDate,
+			Status:    "running",
+			CreatedAt: time.Now(),
+		},
+		fileName:    fmt.Sprintf("bills-%s-%s.%s", startDate.Format("20060102"), endDate.Format("20060102"), req.Format),
+		contentType: contentType,
+	}
+
+	bulkJobsMu.Lock()
+	for key, j := range bulkJobs {
+		if j.status.FinishedAt != nil && time.Since(*j.status.FinishedAt) > bulkJobTTL {
+			delete(bulkJobs, key)
+		}
+	}
+	bulkJobs[job.status.JobID] = job
+	bulkJobsMu.Unlock()
+
+	// The export outlives the request
+	go runBulkBills(context.Background(), job)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job.status)
+}
+
+func GetBulkBillJob(w http.ResponseWriter, r *http.Request) {
+	bulkJobsMu.Lock()
+	job, ok := bulkJobs[chi.URLParam(r, "job_id")]
+	var status model.BulkBillJob
+	if ok {
+		status = job.status
+	}
+	bulkJobsMu.Unlock()
+	if !ok {
+		api.NotFound(w, "Job not found")
+		return
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+func DownloadBulkBills(w http.ResponseWriter, r *http.Request) {
+	bulkJobsMu.Lock()
+	job, ok := bulkJobs[chi.URLParam(r, "job_id")]
+	var status string
+	if ok {
+		status = job.status.Status
+	}
+	bulkJobsMu.Unlock()
+	if !ok {
+		api.NotFound(w, "Job not found")
+		return
+	}
+	if status != "done" {
+		api.Conflict(w, "Export is not ready")
+		return
+	}
+
+	w.Header().Set("Content-Type", job.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.fileName))
+	w.Write(job.output)
+}
+
+func runBulkBills(ctx context.Context, job *bulkJob) {
+	output, err := buildBulkBills(ctx, job)
+
+	bulkJobsMu.Lock()
+	defer bulkJobsMu.Unlock()
+	now := time.Now()
+	job.status.FinishedAt = &now
+	if err != nil {
+		log.Printf("Bulk bill job %s failed: %v\n", job.status.JobID, err)
+		job.status.Status = "failed"
+		job.status.Error = "Something went wrong, please try again"
+		return
+	}
+	job.output = output
+	job.status.Status = "done"
+	job.status.DownloadURL = "/api/reports/bills/bulk/" + job.status.JobID + "/download"
+}
+
+func buildBulkBills(ctx context.Context, job *bulkJob) ([]byte, error) {
+	dbPool := database.GetDbConn()
+	// Users with nothing to bill in the period are left out
+	rows, err := dbPool.Query(ctx, `
+		SELECT u.USER_ID FROM USERS u
+		LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
+		WHERE COALESCE(w.BALANCE, 0) <> 0
+			OR EXISTS (SELECT 1 FROM DAILY_LOGS l WHERE l.USER_ID = u.USER_ID AND l.LOG_DATE BETWEEN $1 AND $2 AND l.DELETED_AT IS NULL)
+			OR EXISTS (SELECT 1 FROM WALLET_TRANSACTIONS t WHERE t.USER_ID = u.USER_ID AND t.VALUE_DATE BETWEEN $1 AND $2)
+		ORDER BY u.USER_ID
+	`, job.status.StartDate, job.status.EndDate)
+	if err != nil {
+		return nil, err
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, err
+	}
+
+	bulkJobsMu.Lock()
+	job.status.Total = len(userIDs)
+	bulkJobsMu.Unlock()
+
+	var buf bytes.Buffer
+	var archive *zip.Writer
+	if job.status.Format == "zip" {
+		archive = zip.NewWriter(&buf)
+	}
+	summary := [][]interface{}{{"User ID", "Name", "Building", "Room", "Meals", "Spent", "Recharged", "Closing Balance"}}
+	business := BusinessFromEnv()
+
+	for _, userID := range userIDs {
+		report, err := BuildBill(ctx, dbPool, userID, job.status.StartDate, job.status.EndDate)
+		var body []byte
+		if err == nil && archive != nil {
+			body, err = RenderPDF(business, report)
+		}
+		if err != nil {
+			// One bad bill shouldn't lose everyone else's
+			log.Printf("Bulk bill job %s: user %d: %v\n", job.status.JobID, userID, err)
+			bulkJobsMu.Lock()
+			job.status.FailedUserIDs = append(job.status.FailedUserIDs, userID)
+			job.status.Done++
+			bulkJobsMu.Unlock()
+			continue
+		}
+
+		if archive != nil {
+			f, err := archive.Create(billFileName(report) + ".pdf")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.Write(body); err != nil {
+				return nil, err
+			}
+		} else {
+			meals := 0
+			for _, l := range report.Logs {
+				if l.HasMainMeal {
+					meals++
+				}
+			}
+			summary = append(summary, []interface{}{
+				report.User.UserID, report.User.Name, report.User.BuildingNo, report.User.RoomNo,
+				meals, report.TotalSpent, report.TotalRecharges, report.ClosingBalance,
+			})
+		}
+
+		bulkJobsMu.Lock()
+		job.status.Done++
+		bulkJobsMu.Unlock()
+	}
+
+	switch job.status.Format {
+	case "zip":
+		if err := archive.Close(); err != nil {
+			return nil, err
+		}
+	case "csv":
+		cw := csv.NewWriter(&buf)
+		for _, row := range summary {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				if v, ok := cell.(float64); ok {
+					record[i] = money(v)
+				} else {
+					record[i] = fmt.Sprint(cell)
+				}
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return nil, err
+		}
+	case "xlsx":
+		if err := xlsx.Write(&buf, "Bills", summary); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package billing
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// GetDues lists users whose wallet is in arrears, most owed first.
+func GetDues(w http.ResponseWriter, r *http.Request) {
+	dbPool := database.GetDbConn()
+	// Arrears start at the first transaction that took the balance below
+	// zero after it was last non-negative
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO, u.ROLE, USER_PLAN_ON(u.USER_ID, $1),
+		       w.BALANCE, w.CREDIT_LIMIT, w.BALANCE_POLICY,
+		       (
+		           SELECT MIN(t.CREATED_AT)
+		           FROM WALLET_TRANSACTIONS t
+		           WHERE t.USER_ID = u.USER_ID
+		             AND t.STATUS = 'confirmed'
+		             AND t.BALANCE_AFTER < 0
+		             AND t.CREATED_AT > COALESCE((
+		                 SELECT MAX(p.CREATED_AT)
+		                 FROM WALLET_TRANSACTIONS p
+		                 WHERE p.USER_ID = u.USER_ID
+		                   AND p.STATUS = 'confirmed'
+		                   AND p.BALANCE_AFTER >= 0
+		             ), '-infinity')
+		       ) AS ARREARS_SINCE
+		FROM USERS u
+		JOIN WALLET w ON w.USER_ID = u.USER_ID
+		WHERE w.BALANCE < 0
+		ORDER BY w.BALANCE ASC, u.NAME ASC
+	`, database.BusinessDate(time.Now()))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	dues := []model.DuesEntry{}
+	for rows.Next() {
+		var d model.DuesEntry
+		u := &d.User
+		err := rows.Scan(&u.UserID, &u.Name, &u.MobileNo, &u.BuildingNo, &u.RoomNo, &u.Role, &u.Plan, &u.Balance, &u.CreditLimit, &u.BalancePolicy, &d.ArrearsSince)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if d.ArrearsSince != nil {
+			d.DaysOutstanding = int(now.Sub(*d.ArrearsSince).Hours() / 24)
+		}
+		d.OverLimit = u.Balance < -u.CreditLimit
+		dues = append(dues, d)
+	}
+
+	json.NewEncoder(w).Encode(dues)
+}
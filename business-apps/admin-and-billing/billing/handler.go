@@ -1,106 +1,300 @@
 package billing
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
-	"strconv"
+	"sort"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
 	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/meals"
 	"github.com/soumalya/food-delivery-admin/model"
 )
 
 func GetBill(w http.ResponseWriter, r *http.Request) {
-	userIDStr := r.URL.Query().Get("user_id")
-	startDateStr := r.URL.Query().Get("start_date")
-	endDateStr := r.URL.Query().Get("end_date")
+	params := api.Query(r)
+	userID := params.Int("user_id", true)
+	startDate := params.Date("start_date", true)
+	endDate := params.Date("end_date", true)
+	params.DateRange("start_date", startDate, "end_date", endDate)
+	format := params.String("format", false)
+	params.Check(format == "" || format == "json" || format == "pdf" || format == "html", "format", "must be json, pdf or html")
+	if params.Failed(w) {
+		return
+	}
+
+	ServeBill(w, r, userID, startDate, endDate, format)
+}
+
+// ServeBill writes one user's bill as JSON, or as a PDF or HTML document
+// when format says so.
+func ServeBill(w http.ResponseWriter, r *http.Request, userID int, startDate, endDate time.Time, format string) {
+	report, err := BuildBill(r.Context(), database.GetDbConn(), userID, startDate, endDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
 
-	userID, _ := strconv.Atoi(userIDStr)
-	startDate, _ := time.Parse("2006-01-02", startDateStr)
-	endDate, _ := time.Parse("2006-01-02", endDateStr)
+	switch format {
+	case "pdf":
+		body, err := RenderPDF(BusinessFromEnv(), report)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", billFileName(report)+".pdf"))
+		w.Write(body)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := RenderHTML(w, BusinessFromEnv(), report); err != nil {
+			api.InternalError(w, err)
+		}
+	default:
+		json.NewEncoder(w).Encode(report)
+	}
+}
 
+func billFileName(report model.BillReport) string {
+	return fmt.Sprintf("bill-%d-%s-%s", report.User.UserID, report.StartDate.Format("20060102"), report.EndDate.Format("20060102"))
+}
+
+// BuildBill computes the bill of one user for the days from startDate to
+// endDate inclusive.
+func BuildBill(ctx context.Context, q database.Querier, userID int, startDate, endDate time.Time) (model.BillReport, error) {
 	var report model.BillReport
 	report.StartDate = startDate
 	report.EndDate = endDate
 
 	// Get User Info
-	dbPool := database.GetDbConn()
-	err := dbPool.QueryRow(r.Context(), `
-		SELECT u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO, u.ROLE, u.PLAN 
+	err := q.QueryRow(ctx, `
+		SELECT u.USER_ID, COALESCE(u.NAME, ''), COALESCE(u.MOBILE_NO, ''), COALESCE(u.BUILDING_NO, ''), COALESCE(u.ROOM_NO, ''),
+			u.ROLE, USER_PLAN_ON(u.USER_ID, $2)
 		FROM USERS u
 		WHERE u.USER_ID = $1
-	`, userID).Scan(&report.User.UserID, &report.User.Name, &report.User.MobileNo, &report.User.BuildingNo, &report.User.RoomNo, &report.User.Role, &report.User.Plan)
+	`, userID, endDate).Scan(&report.User.UserID, &report.User.Name, &report.User.MobileNo, &report.User.BuildingNo, &report.User.RoomNo, &report.User.Role, &report.User.Plan)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return report, err
 	}
 
 	// Get Logs
-	rows, err := dbPool.Query(r.Context(), `
-		SELECT LOG_ID, LOG_DATE, MEAL_TYPE, HAS_MAIN_MEAL, IS_SPECIAL, SPECIAL_DISH_NAME, EXTRA_RICE_QTY, EXTRA_ROTI_QTY, TOTAL_COST 
+	rows, err := q.Query(ctx, `
+		SELECT LOG_ID, LOG_DATE, MEAL_TYPE, HAS_MAIN_MEAL, IS_SPECIAL, COALESCE(SPECIAL_DISH_NAME, ''), EXTRA_RICE_QTY, EXTRA_ROTI_QTY,
+			EXTRA_CHICKEN_QTY, EXTRA_FISH_QTY, EXTRA_EGG_QTY, EXTRA_VEGETABLE_QTY, TOTAL_COST 
 		FROM DAILY_LOGS 
-		WHERE USER_ID = $1 AND LOG_DATE BETWEEN $2 AND $3 
+		WHERE USER_ID = $1 AND LOG_DATE BETWEEN $2 AND $3 AND DELETED_AT IS NULL
 		ORDER BY LOG_DATE ASC, MEAL_TYPE DESC
 	`, userID, startDate, endDate)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return report, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var l model.DailyLog
-		err := rows.Scan(&l.LogID, &l.LogDate, &l.MealType, &l.HasMainMeal, &l.IsSpecial, &l.SpecialDishName, &l.ExtraRiceQty, &l.ExtraRotiQty, &l.TotalCost)
+		err := rows.Scan(&l.LogID, &l.LogDate, &l.MealType, &l.HasMainMeal, &l.IsSpecial, &l.SpecialDishName, &l.ExtraRiceQty, &l.ExtraRotiQty,
+			&l.ExtraChickenQty, &l.ExtraFishQty, &l.ExtraEggQty, &l.ExtraVegetableQty, &l.TotalCost)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return report, err
 		}
 		report.Logs = append(report.Logs, l)
 		report.TotalSpent += l.TotalCost
 	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
 
-	err = dbPool.QueryRow(r.Context(), `SELECT BALANCE_AFTER
-	FROM WALLET_TRANSACTIONS
-	WHERE USER_ID = $1
-	AND STATUS = 'confirmed'
-	AND CREATED_AT <= $2
-	ORDER BY CREATED_AT DESC
-	LIMIT 1`, userID, endDate).Scan(&report.ClosingBalance)
+	if err := attachItems(ctx, q, userID, startDate, endDate, report.Logs); err != nil {
+		return report, err
+	}
+	report.ItemTotals = itemTotals(report.Logs)
+	for _, l := range report.Logs {
+		for _, item := range l.Items {
+			report.TaxableValue += item.TaxableValue
+			report.TotalTax += item.TaxAmount
+		}
+	}
+	report.TaxableValue = math.Round(report.TaxableValue*100) / 100
+	report.TotalTax = math.Round(report.TotalTax*100) / 100
 
-	// Closing balance is current balance
+	// Balances are replayed from the ledger rather than read from
+	// BALANCE_AFTER, which recharges only get once acknowledged. Every
+	// transaction counts towards its business day so recharges and meals
+	// share the same day boundaries.
+	report.Timezone = database.BusinessTimezone()
+	err = q.QueryRow(ctx, `
+		WITH LEDGER AS (
+			SELECT TXN_TYPE::TEXT AS TXN_TYPE, AMOUNT,
+				COALESCE(VALUE_DATE, (CREATED_AT AT TIME ZONE $4)::DATE) AS VALUE_DATE
+			FROM WALLET_TRANSACTIONS
+			WHERE USER_ID = $1 AND STATUS = 'confirmed'
+		)
+		SELECT
+			COALESCE(SUM(WALLET_TXN_DELTA(TXN_TYPE, AMOUNT)) FILTER (WHERE VALUE_DATE < $2), 0),
+			COALESCE(SUM(WALLET_TXN_DELTA(TXN_TYPE, AMOUNT)) FILTER (WHERE VALUE_DATE <= $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'recharge' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'adjustment' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'write_off' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'transfer_in' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'transfer_out' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'settlement' AND VALUE_DATE BETWEEN $2 AND $3), 0),
+			COALESCE(SUM(AMOUNT) FILTER (WHERE TXN_TYPE = 'opening_balance' AND VALUE_DATE BETWEEN $2 AND $3), 0)
+		FROM LEDGER
+	`, userID, startDate, endDate, report.Timezone).Scan(
+		&report.OpeningBalance, &report.ClosingBalance, &report.TotalRecharges,
+		&report.TotalAdjustments, &report.TotalWriteOffs, &report.TotalTransfersIn, &report.TotalTransfersOut, &report.TotalSettlements,
+		&report.TotalBroughtForward)
+	if err != nil {
+		return report, err
+	}
 	report.User.Balance = report.ClosingBalance
 
-	// Calculate total recharges during billing period
-	dbPool.QueryRow(r.Context(), `
-		SELECT COALESCE(SUM(AMOUNT), 0)
-		FROM WALLET_TRANSACTIONS
-		WHERE USER_ID = $1
-		  AND TXN_TYPE = 'recharge'
-		  AND STATUS = 'confirmed'
-		  AND CREATED_AT >= $2
-		  AND CREATED_AT <= $3
-	`, userID, startDate, endDate.AddDate(0, 0, 1)).Scan(&report.TotalRecharges)
-
-	// Opening balance = Balance before the billing period started
-	// Get the BALANCE_AFTER from the last confirmed transaction before the start date
-	// If no transactions exist before start date, opening balance is 0
-	var openingBalance *float64
-	err = dbPool.QueryRow(r.Context(), `
-		SELECT BALANCE_AFTER
-		FROM WALLET_TRANSACTIONS
-		WHERE USER_ID = $1
-		  AND STATUS = 'confirmed'
-		  AND CREATED_AT < $2
-		ORDER BY CREATED_AT DESC, TXN_ID DESC
-		LIMIT 1
-	`, userID, startDate).Scan(&openingBalance)
-
-	if err != nil || openingBalance == nil {
-		// No transactions before start date, opening balance is 0
-		report.OpeningBalance = 0
-	} else {
-		report.OpeningBalance = *openingBalance
-	}
-
-	json.NewEncoder(w).Encode(report)
+	// Meals are billed from the logs; deliveries and refunds in the ledger
+	// should net to the same amount, otherwise the difference shows up here
+	expected := report.OpeningBalance + report.TotalRecharges + report.TotalAdjustments + report.TotalWriteOffs +
+		report.TotalTransfersIn - report.TotalTransfersOut + report.TotalSettlements + report.TotalBroughtForward - report.TotalSpent
+	expected = math.Round(expected*100) / 100
+	report.Reconciliation = model.Reconciliation{
+		Expected:   expected,
+		Actual:     report.ClosingBalance,
+		Difference: math.Round((report.ClosingBalance-expected)*100) / 100,
+	}
+	report.Reconciliation.Balanced = report.Reconciliation.Difference == 0
+
+	return report, nil
+}
+
+// attachItems fills in the priced items of each log. Entries made before
+// items were stored are split into items at the current meal prices, scaled
+// to what the customer was actually charged.
+func attachItems(ctx context.Context, q database.Querier, userID int, startDate, endDate time.Time, logs []model.DailyLog) error {
+	rows, err := q.Query(ctx, `
+		SELECT i.LOG_ID, i.ITEM_ID, i.ITEM_NAME, i.QUANTITY, i.UNIT_PRICE, i.AMOUNT,
+			i.HSN_SAC, i.TAX_RATE, COALESCE(i.TAXABLE_VALUE, i.AMOUNT), i.TAX_AMOUNT
+		FROM DAILY_LOG_ITEMS i
+		JOIN DAILY_LOGS l ON l.LOG_ID = i.LOG_ID
+		WHERE l.USER_ID = $1 AND l.LOG_DATE BETWEEN $2 AND $3 AND l.DELETED_AT IS NULL
+	`, userID, startDate, endDate)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	items := make(map[int][]model.BillLine)
+	for rows.Next() {
+		var logID int
+		var item model.BillLine
+		if err := rows.Scan(&logID, &item.ItemID, &item.ItemName, &item.Quantity, &item.UnitPrice, &item.Amount,
+			&item.HSNSAC, &item.TaxRate, &item.TaxableValue, &item.TaxAmount); err != nil {
+			return err
+		}
+		items[logID] = append(items[logID], item)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var catalog map[string]model.MealPrice
+	for i := range logs {
+		l := &logs[i]
+		if stored, ok := items[l.LogID]; ok {
+			l.Items = sortItems(stored)
+			continue
+		}
+		if catalog == nil {
+			catalog = meals.GetMealCatalogInternal(ctx)
+		}
+		l.Items = estimateItems(meals.ItemLines(model.EntryRequest{
+			HasMainMeal:       l.HasMainMeal,
+			IsSpecial:         l.IsSpecial,
+			ExtraRiceQty:      l.ExtraRiceQty,
+			ExtraRotiQty:      l.ExtraRotiQty,
+			ExtraChickenQty:   l.ExtraChickenQty,
+			ExtraFishQty:      l.ExtraFishQty,
+			ExtraEggQty:       l.ExtraEggQty,
+			ExtraVegetableQty: l.ExtraVegetableQty,
+		}, catalog), l.TotalCost)
+	}
+	return nil
+}
+
+// estimateItems scales lines priced at today's prices so they add up to
+// total, the amount charged for the entry, and marks them as estimated. GST
+// is split out of each scaled amount at the line's rate; rounding is
+// absorbed by the last line.
+func estimateItems(lines []model.BillLine, total float64) []model.BillLine {
+	if len(lines) == 0 {
+		return lines
+	}
+	priced := meals.LinesTotal(lines)
+	remaining := total
+	for i := range lines {
+		line := &lines[i]
+		amount := remaining
+		if i < len(lines)-1 {
+			if priced > 0 {
+				amount = math.Round(line.Amount*total/priced*100) / 100
+			} else {
+				amount = 0
+			}
+		}
+		remaining = math.Round((remaining-amount)*100) / 100
+
+		line.Amount = amount
+		line.TaxableValue, line.TaxAmount = meals.SplitTax(amount, model.MealTax{TaxRate: line.TaxRate, TaxInclusive: true})
+		if line.Quantity > 0 {
+			line.UnitPrice = math.Round(amount/float64(line.Quantity)*100) / 100
+		}
+		line.Estimated = true
+	}
+	return lines
+}
+
+// itemOrder lists the main meals first, then the extras as on the entry form
+var itemOrder = map[string]int{"standard": 0, "special": 1, "rice": 2, "roti": 3, "chicken": 4, "fish": 5, "egg": 6, "vegetable": 7}
+
+func itemRank(itemID string) int {
+	if rank, ok := itemOrder[itemID]; ok {
+		return rank
+	}
+	return len(itemOrder)
+}
+
+func sortItems(items []model.BillLine) []model.BillLine {
+	sort.SliceStable(items, func(i, j int) bool {
+		return itemRank(items[i].ItemID) < itemRank(items[j].ItemID)
+	})
+	return items
+}
+
+// itemTotals sums quantity and amount per item over the period.
+func itemTotals(logs []model.DailyLog) []model.ItemTotal {
+	var totals []model.ItemTotal
+	index := make(map[string]int)
+	for _, l := range logs {
+		for _, item := range l.Items {
+			i, ok := index[item.ItemID]
+			if !ok {
+				i = len(totals)
+				index[item.ItemID] = i
+				totals = append(totals, model.ItemTotal{ItemID: item.ItemID, ItemName: item.ItemName})
+			}
+			totals[i].Quantity += item.Quantity
+			totals[i].Amount = math.Round((totals[i].Amount+item.Amount)*100) / 100
+		}
+	}
+	sort.SliceStable(totals, func(i, j int) bool {
+		return itemRank(totals[i].ItemID) < itemRank(totals[j].ItemID)
+	})
+	return totals
 }
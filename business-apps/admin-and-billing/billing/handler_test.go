@@ -0,0 +1,80 @@
+package billing
+
+import (
+	"testing"
+
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+func TestEstimateItems(t *testing.T) {
+	type want struct {
+		amount, unitPrice, taxable, tax float64
+	}
+	tests := []struct {
+		name  string
+		lines []model.BillLine
+		total float64
+		want  []want
+	}{
+		{
+			name:  "scaled up to the amount charged",
+			lines: []model.BillLine{{Quantity: 1, Amount: 60}, {Quantity: 2, Amount: 20}},
+			total: 100,
+			want:  []want{{75, 75, 75, 0}, {25, 12.5, 25, 0}},
+		},
+		{
+			name:  "scaled down",
+			lines: []model.BillLine{{Quantity: 1, Amount: 80}, {Quantity: 1, Amount: 20}},
+			total: 50,
+			want:  []want{{40, 40, 40, 0}, {10, 10, 10, 0}},
+		},
+		{
+			name:  "rounding absorbed by the last line",
+			lines: []model.BillLine{{Quantity: 1, Amount: 10}, {Quantity: 1, Amount: 10}, {Quantity: 1, Amount: 10}},
+			total: 10,
+			want:  []want{{3.33, 3.33, 3.33, 0}, {3.33, 3.33, 3.33, 0}, {3.34, 3.34, 3.34, 0}},
+		},
+		{
+			name:  "nothing priced",
+			lines: []model.BillLine{{Quantity: 1}, {Quantity: 1}},
+			total: 40,
+			want:  []want{{0, 0, 0, 0}, {40, 40, 40, 0}},
+		},
+		{
+			name:  "GST split out of the scaled amount",
+			lines: []model.BillLine{{Quantity: 1, Amount: 50, TaxRate: 5}},
+			total: 105,
+			want:  []want{{105, 105, 100, 5}},
+		},
+		{
+			name:  "no quantity keeps the unit price",
+			lines: []model.BillLine{{Quantity: 0, UnitPrice: 7, Amount: 10}},
+			total: 20,
+			want:  []want{{20, 7, 20, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := estimateItems(tt.lines, tt.total)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d lines, want %d", len(got), len(tt.want))
+			}
+			for i, w := range tt.want {
+				l := got[i]
+				if l.Amount != w.amount || l.UnitPrice != w.unitPrice || l.TaxableValue != w.taxable || l.TaxAmount != w.tax {
+					t.Errorf("line %d = amount %v, unit price %v, taxable %v, tax %v, want %v, %v, %v, %v",
+						i, l.Amount, l.UnitPrice, l.TaxableValue, l.TaxAmount, w.amount, w.unitPrice, w.taxable, w.tax)
+				}
+				if !l.Estimated {
+					t.Errorf("line %d is not marked estimated", i)
+				}
+			}
+		})
+	}
+}
+
+func TestEstimateItemsEmpty(t *testing.T) {
+	if got := estimateItems(nil, 100); len(got) != 0 {
+		t.Errorf("estimateItems(nil, 100) = %v, want no lines", got)
+	}
+}
@@ -0,0 +1,355 @@
+package billing
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/pdf"
+	"github.com/soumalya/food-delivery-admin/qrcode"
+)
+
+// Business identifies the seller on printed bills.
+type Business struct {
+	Name    string
+	Address string
+	Phone   string
+	UPIID   string
+	GSTIN   string
+}
+
+// BusinessFromEnv reads BUSINESS_NAME, BUSINESS_ADDRESS, BUSINESS_PHONE,
+// BUSINESS_UPI_ID and BUSINESS_GSTIN.
+func BusinessFromEnv() Business {
+	b := Business{
+		Name:    os.Getenv("BUSINESS_NAME"),
+		Address: os.Getenv("BUSINESS_ADDRESS"),
+		Phone:   os.Getenv("BUSINESS_PHONE"),
+		UPIID:   os.Getenv("BUSINESS_UPI_ID"),
+		GSTIN:   strings.ToUpper(os.Getenv("BUSINESS_GSTIN")),
+	}
+	if b.Name == "" {
+		b.Name = "Ranjitar Rannaghor"
+	}
+	return b
+}
+
+// AmountDue is what the customer owes at the end of the bill.
+func AmountDue(report model.BillReport) float64 {
+	if report.ClosingBalance < 0 {
+		return -report.ClosingBalance
+	}
+	return 0
+}
+
+// UPILink builds a UPI payment link for the amount due, empty when nothing is
+// due or no UPI ID is configured.
+func UPILink(b Business, report model.BillReport) string {
+	due := AmountDue(report)
+	if due == 0 || b.UPIID == "" {
+		return ""
+	}
+	note := fmt.Sprintf("Bill %s to %s", report.StartDate.Format("02 Jan"), report.EndDate.Format("02 Jan 2006"))
+	// UPI apps expect the @ of the VPA as is, not as %40
+	pa := strings.ReplaceAll(url.QueryEscape(b.UPIID), "%40", "@")
+	return fmt.Sprintf("upi://pay?pa=%s&pn=%s&am=%.2f&cu=INR&tn=%s",
+		pa, url.PathEscape(b.Name), due, url.PathEscape(note))
+}
+
+// billRow is one printed line of the bill; date and shift are only set on
+// the first line of each entry.
+type billRow struct {
+	Date, Shift, Item, Qty, Rate, Amount string
+}
+
+func itemLabel(l model.DailyLog, item model.BillLine) string {
+	label := item.ItemName
+	if item.ItemID == "special" && l.SpecialDishName != "" {
+		label += ": " + l.SpecialDishName
+	}
+	if item.Estimated {
+		label += " (est.)"
+	}
+	return label
+}
+
+func billRows(report model.BillReport) []billRow {
+	var rows []billRow
+	for _, l := range report.Logs {
+		date, shift := l.LogDate.Format("02 Jan"), titleCase(l.MealType)
+		if len(l.Items) == 0 {
+			rows = append(rows, billRow{Date: date, Shift: shift, Item: "-", Amount: money(l.TotalCost)})
+			continue
+		}
+		for i, item := range l.Items {
+			row := billRow{
+				Item:   itemLabel(l, item),
+				Qty:    fmt.Sprintf("%d", item.Quantity),
+				Rate:   money(item.UnitPrice),
+				Amount: money(item.Amount),
+			}
+			if i == 0 {
+				row.Date, row.Shift = date, shift
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// titleCase turns a shift such as "lunch" into "Lunch".
+func titleCase(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+var billTemplate = template.Must(template.New("bill").Funcs(template.FuncMap{
+	"money": money,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Bill - {{.Report.User.Name}}</title>
+<style>
+	body { font-family: Helvetica, Arial, sans-serif; color: #222; max-width: 800px; margin: 24px auto; padding: 0 16px; }
+	h1 { margin: 0; font-size: 24px; }
+	.muted { color: #666; font-size: 13px; }
+	.row { display: flex; justify-content: space-between; align-items: flex-start; margin: 20px 0; }
+	table { width: 100%; border-collapse: collapse; font-size: 13px; }
+	th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+	th { background: #f3f3f3; }
+	.num { text-align: right; }
+	.summary td { border: none; padding: 3px 8px; }
+	.due { font-weight: bold; font-size: 16px; }
+	@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+	<h1>{{.Business.Name}}</h1>
+	{{if .Business.Address}}<div class="muted">{{.Business.Address}}</div>{{end}}
+	{{if .Business.Phone}}<div class="muted">Phone: {{.Business.Phone}}</div>{{end}}
+
+	<div class="row">
+		<div>
+			<strong>Bill to</strong><br>
+			{{.Report.User.Name}}<br>
+			<span class="muted">Building {{.Report.User.BuildingNo}}, Room {{.Report.User.RoomNo}}<br>{{.Report.User.MobileNo}}</span>
+		</div>
+		<div class="num">
+			<strong>Period</strong><br>
+			{{.Report.StartDate.Format "02 Jan 2006"}} - {{.Report.EndDate.Format "02 Jan 2006"}}
+		</div>
+	</div>
+
+	<table>
+		<thead><tr><th>Date</th><th>Shift</th><th>Item</th><th class="num">Qty</th><th class="num">Rate</th><th class="num">Amount</th></tr></thead>
+		<tbody>
+		{{range .Rows}}
+			<tr><td>{{.Date}}</td><td>{{.Shift}}</td><td>{{.Item}}</td><td class="num">{{.Qty}}</td><td class="num">{{.Rate}}</td><td class="num">{{.Amount}}</td></tr>
+		{{else}}
+			<tr><td colspan="6" class="muted">No meals in this period</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+
+	{{if .Report.ItemTotals}}
+	<h3>Item totals</h3>
+	<table style="width: auto">
+		<thead><tr><th>Item</th><th class="num">Qty</th><th class="num">Amount</th></tr></thead>
+		<tbody>
+		{{range .Report.ItemTotals}}
+			<tr><td>{{.ItemName}}</td><td class="num">{{.Quantity}}</td><td class="num">{{money .Amount}}</td></tr>
+		{{end}}
+		</tbody>
+	</table>
+	{{end}}
+
+	<div class="row">
+		<div>
+			{{if .QRCode}}
+				{{.QRCode}}
+				<div class="muted">Scan to pay {{money .Due}} via UPI</div>
+			{{end}}
+		</div>
+		<table class="summary" style="width: auto">
+			<tr><td>Opening balance</td><td class="num">{{money .Report.OpeningBalance}}</td></tr>
+			<tr><td>Recharges</td><td class="num">{{money .Report.TotalRecharges}}</td></tr>
+			{{if .OtherCredits}}<tr><td>Adjustments &amp; transfers</td><td class="num">{{money .OtherCredits}}</td></tr>{{end}}
+			<tr><td>Meals</td><td class="num">-{{money .Report.TotalSpent}}</td></tr>
+			{{if .Report.TotalTax}}<tr class="muted"><td>Includes GST</td><td class="num">{{money .Report.TotalTax}}</td></tr>{{end}}
+			<tr><td><strong>Closing balance</strong></td><td class="num"><strong>{{money .Report.ClosingBalance}}</strong></td></tr>
+			{{if .Due}}<tr class="due"><td>Amount due</td><td class="num">{{money .Due}}</td></tr>{{end}}
+		</table>
+	</div>
+</body>
+</html>
+`))
+
+// otherCredits nets adjustments, write-offs, transfers, settlement and any
+// balance brought forward on import.
+func otherCredits(report model.BillReport) float64 {
+	return report.TotalAdjustments + report.TotalWriteOffs + report.TotalTransfersIn - report.TotalTransfersOut + report.TotalSettlements +
+		report.TotalBroughtForward
+}
+
+// RenderHTML writes a printable HTML version of the bill.
+func RenderHTML(w io.Writer, b Business, report model.BillReport) error {
+	var qr template.HTML
+	if link := UPILink(b, report); link != "" {
+		code, err := qrcode.Encode(link)
+		if err != nil {
+			return err
+		}
+		qr = template.HTML(code.SVG(4))
+	}
+	return billTemplate.Execute(w, map[string]interface{}{
+		"Business":     b,
+		"Report":       report,
+		"Rows":         billRows(report),
+		"Due":          AmountDue(report),
+		"OtherCredits": otherCredits(report),
+		"QRCode":       qr,
+	})
+}
+
+// RenderPDF lays out the bill on A4 pages.
+func RenderPDF(b Business, report model.BillReport) ([]byte, error) {
+	const left, right, bottom = 40.0, pdf.PageWidth - 40, 60.0
+	doc := pdf.New()
+	y := pdf.PageHeight - 50
+
+	doc.Text(left, y, 18, true, b.Name)
+	y -= 16
+	for _, line := range []string{b.Address, b.Phone} {
+		if line != "" {
+			doc.Text(left, y, 9, false, line)
+			y -= 12
+		}
+	}
+
+	y -= 10
+	doc.Text(left, y, 11, true, "Bill to")
+	doc.TextRight(right, y, 11, true, "Period")
+	y -= 14
+	doc.Text(left, y, 10, false, report.User.Name)
+	doc.TextRight(right, y, 10, false, report.StartDate.Format("02 Jan 2006")+" - "+report.EndDate.Format("02 Jan 2006"))
+	y -= 12
+	doc.Text(left, y, 9, false, fmt.Sprintf("Building %s, Room %s", report.User.BuildingNo, report.User.RoomNo))
+	y -= 12
+	doc.Text(left, y, 9, false, report.User.MobileNo)
+	y -= 24
+
+	columns := []float64{left + 4, left + 60, left + 115}
+	header := func(labels ...string) {
+		doc.Rect(left, y-5, right-left, 18, 0.92)
+		for i, h := range labels[:len(labels)-3] {
+			doc.Text(columns[i], y, 9, true, h)
+		}
+		doc.TextRight(right-124, y, 9, true, labels[len(labels)-3])
+		doc.TextRight(right-64, y, 9, true, labels[len(labels)-2])
+		doc.TextRight(right-4, y, 9, true, labels[len(labels)-1])
+		y -= 20
+	}
+	newPage := func() {
+		doc.AddPage()
+		y = pdf.PageHeight - 50
+	}
+	row := func(cells ...string) {
+		for i, c := range cells[:len(cells)-3] {
+			doc.Text(columns[i], y, 9, false, c)
+		}
+		doc.TextRight(right-124, y, 9, false, cells[len(cells)-3])
+		doc.TextRight(right-64, y, 9, false, cells[len(cells)-2])
+		doc.TextRight(right-4, y, 9, false, cells[len(cells)-1])
+		doc.Line(left, y-5, right, y-5, 0.3)
+		y -= 16
+	}
+
+	header("Date", "Shift", "Item", "Qty", "Rate", "Amount")
+	for _, r := range billRows(report) {
+		if y < bottom {
+			newPage()
+			header("Date", "Shift", "Item", "Qty", "Rate", "Amount")
+		}
+		row(r.Date, r.Shift, r.Item, r.Qty, r.Rate, r.Amount)
+	}
+	if len(report.Logs) == 0 {
+		doc.Text(columns[0], y, 9, false, "No meals in this period")
+		y -= 16
+	}
+
+	if len(report.ItemTotals) > 0 {
+		if y-60 < bottom {
+			newPage()
+		}
+		y -= 10
+		doc.Text(left, y, 11, true, "Item totals")
+		y -= 18
+		header("Item", "Qty", "", "Amount")
+		for _, t := range report.ItemTotals {
+			if y < bottom {
+				newPage()
+				header("Item", "Qty", "", "Amount")
+			}
+			row(t.ItemName, fmt.Sprintf("%d", t.Quantity), "", money(t.Amount))
+		}
+	}
+
+	// Summary needs about 150pt including the QR code
+	if y-150 < bottom {
+		newPage()
+	}
+	y -= 10
+	summaryTop := y
+	due := AmountDue(report)
+	rows := [][2]string{
+		{"Opening balance", money(report.OpeningBalance)},
+		{"Recharges", money(report.TotalRecharges)},
+	}
+	if other := otherCredits(report); other != 0 {
+		rows = append(rows, [2]string{"Adjustments & transfers", money(other)})
+	}
+	rows = append(rows, [2]string{"Meals", "-" + money(report.TotalSpent)})
+	if report.TotalTax > 0 {
+		rows = append(rows, [2]string{"Includes GST", money(report.TotalTax)})
+	}
+	rows = append(rows, [2]string{"Closing balance", money(report.ClosingBalance)})
+	if due > 0 {
+		rows = append(rows, [2]string{"Amount due", money(due)})
+	}
+	for i, row := range rows {
+		bold := i >= len(rows)-1 || row[0] == "Closing balance"
+		doc.Text(right-200, y, 10, bold, row[0])
+		doc.TextRight(right-4, y, 10, bold, row[1])
+		y -= 15
+	}
+
+	if link := UPILink(b, report); link != "" {
+		code, err := qrcode.Encode(link)
+		if err != nil {
+			return nil, err
+		}
+		const moduleSize = 3.0
+		top := summaryTop + 8
+		for qy := 0; qy < code.Size; qy++ {
+			for qx := 0; qx < code.Size; qx++ {
+				if code.Dark(qx, qy) {
+					doc.Rect(left+float64(qx)*moduleSize, top-float64(qy+1)*moduleSize, moduleSize, moduleSize, 0)
+				}
+			}
+		}
+		doc.Text(left, top-float64(code.Size)*moduleSize-14, 9, false, "Scan to pay "+money(due)+" via UPI")
+	}
+
+	return doc.Bytes(), nil
+}
@@ -2,19 +2,34 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 var dbPool *pgxpool.Pool
 
+// Querier runs queries on the pool or inside a transaction.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
 func GetDbConn() *pgxpool.Pool {
 	return dbPool
 }
 
+// IsUniqueViolation reports whether err is a unique constraint violation.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 func InitDB() *pgxpool.Pool {
 	user := os.Getenv("POSTGRES_USER")
 	if user == "" {
@@ -59,14 +74,167 @@ func InitDB() *pgxpool.Pool {
             EXPENSE_DATE DATE NOT NULL,
             REASON TEXT NOT NULL,
             AMOUNT DECIMAL(10,2) NOT NULL,
-            CREATED_AT TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            CREATED_AT TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            CATEGORY VARCHAR(20) NOT NULL DEFAULT 'other'
         );
 		CREATE TABLE IF NOT EXISTS MEAL_PRICES (
             ITEM_ID VARCHAR(50) PRIMARY KEY,
             ITEM_NAME VARCHAR(100)  UNIQUE NOT NULL,
             PRICE DECIMAL(10,2) NOT NULL,
-            UPDATED_AT TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+            UPDATED_AT TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+            HSN_SAC VARCHAR(8) NOT NULL DEFAULT '996331',
+            TAX_RATE DECIMAL(5,2) NOT NULL DEFAULT 0,
+            TAX_INCLUSIVE BOOLEAN NOT NULL DEFAULT TRUE,
+            UNIT_COST DECIMAL(10,2) NOT NULL DEFAULT 0
+        );
+		CREATE TABLE IF NOT EXISTS NOTIFICATION_LOG (
+            NOTIFICATION_ID SERIAL PRIMARY KEY,
+            USER_ID INT NOT NULL,
+            KIND VARCHAR(50) NOT NULL,
+            CHANNEL VARCHAR(20) NOT NULL,
+            RECIPIENT TEXT NOT NULL,
+            MESSAGE TEXT NOT NULL,
+            STATUS VARCHAR(20) NOT NULL,
+            ERROR TEXT,
+            SENT_AT TIMESTAMPTZ DEFAULT NOW()
+        );
+		CREATE INDEX IF NOT EXISTS IDX_NOTIFICATION_LOG_USER ON NOTIFICATION_LOG (USER_ID, KIND, SENT_AT);
+		CREATE TABLE IF NOT EXISTS BILLING_PERIODS (
+            PERIOD_START DATE PRIMARY KEY,
+            PERIOD_END DATE NOT NULL,
+            LOCKED_AT TIMESTAMPTZ DEFAULT NOW()
+        );
+		CREATE TABLE IF NOT EXISTS STATEMENTS (
+            STATEMENT_ID SERIAL PRIMARY KEY,
+            STATEMENT_NO VARCHAR(30) UNIQUE NOT NULL,
+            USER_ID INT NOT NULL,
+            PERIOD_START DATE NOT NULL REFERENCES BILLING_PERIODS (PERIOD_START),
+            PERIOD_END DATE NOT NULL,
+            OPENING_BALANCE DECIMAL(10,2) NOT NULL,
+            TOTAL_SPENT DECIMAL(10,2) NOT NULL,
+            TOTAL_RECHARGES DECIMAL(10,2) NOT NULL,
+            OTHER_CREDITS DECIMAL(10,2) NOT NULL,
+            CLOSING_BALANCE DECIMAL(10,2) NOT NULL,
+            MEAL_COUNT INT NOT NULL,
+            GENERATED_AT TIMESTAMPTZ DEFAULT NOW(),
+            UNIQUE (USER_ID, PERIOD_START)
+        );
+		CREATE TABLE IF NOT EXISTS FORECASTS (
+            FORECAST_DATE DATE NOT NULL,
+            SHIFT VARCHAR(10) NOT NULL,
+            ITEM_ID VARCHAR(50) NOT NULL,
+            HORIZON INT NOT NULL,
+            PREDICTED INT NOT NULL,
+            LOW INT NOT NULL,
+            HIGH INT NOT NULL,
+            MADE_AT TIMESTAMPTZ DEFAULT NOW(),
+            PRIMARY KEY (FORECAST_DATE, SHIFT, ITEM_ID, HORIZON)
         );
+		CREATE TABLE IF NOT EXISTS INVOICE_SERIES (
+            FINANCIAL_YEAR VARCHAR(7) PRIMARY KEY,
+            LAST_NO INT NOT NULL
+        );
+		CREATE TABLE IF NOT EXISTS INVOICES (
+            INVOICE_ID SERIAL PRIMARY KEY,
+            INVOICE_NO VARCHAR(16) UNIQUE NOT NULL,
+            FINANCIAL_YEAR VARCHAR(7) NOT NULL REFERENCES INVOICE_SERIES (FINANCIAL_YEAR),
+            INVOICE_DATE DATE NOT NULL,
+            USER_ID INT NOT NULL,
+            PERIOD_START DATE NOT NULL,
+            PERIOD_END DATE NOT NULL,
+            GSTIN VARCHAR(15) NOT NULL,
+            PLACE_OF_SUPPLY VARCHAR(2) NOT NULL,
+            TAXABLE_VALUE DECIMAL(10,2) NOT NULL,
+            CGST DECIMAL(10,2) NOT NULL,
+            SGST DECIMAL(10,2) NOT NULL,
+            TOTAL DECIMAL(10,2) NOT NULL,
+            CREATED_AT TIMESTAMPTZ DEFAULT NOW(),
+            UNIQUE (USER_ID, PERIOD_START, PERIOD_END)
+        );
+		CREATE TABLE IF NOT EXISTS INVOICE_LINES (
+            INVOICE_ID INT NOT NULL REFERENCES INVOICES (INVOICE_ID),
+            LINE_NO INT NOT NULL,
+            ITEM_NAME VARCHAR(100) NOT NULL,
+            HSN_SAC VARCHAR(8) NOT NULL,
+            QUANTITY INT NOT NULL,
+            TAX_RATE DECIMAL(5,2) NOT NULL,
+            TAXABLE_VALUE DECIMAL(10,2) NOT NULL,
+            CGST DECIMAL(10,2) NOT NULL,
+            SGST DECIMAL(10,2) NOT NULL,
+            TOTAL DECIMAL(10,2) NOT NULL,
+            PRIMARY KEY (INVOICE_ID, LINE_NO)
+        );
+		CREATE TABLE IF NOT EXISTS IDEMPOTENCY_KEYS (
+            IDEMPOTENCY_KEY VARCHAR(255) NOT NULL,
+            ENDPOINT VARCHAR(100) NOT NULL,
+            REQUEST_HASH CHAR(64) NOT NULL,
+            STATUS_CODE INT, -- NULL while the first request is still running
+            RESPONSE_BODY BYTEA,
+            CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+            PRIMARY KEY (IDEMPOTENCY_KEY, ENDPOINT)
+        );
+		CREATE TABLE IF NOT EXISTS AUDIT_LOG (
+            AUDIT_ID BIGSERIAL PRIMARY KEY,
+            CHAIN_SEQ BIGINT UNIQUE NOT NULL,
+            OCCURRED_AT TIMESTAMPTZ NOT NULL,
+            ACTOR TEXT NOT NULL,
+            ACTION VARCHAR(50) NOT NULL,
+            ENTITY_TYPE VARCHAR(50) NOT NULL,
+            ENTITY_ID TEXT NOT NULL,
+            BEFORE_DATA JSONB,
+            AFTER_DATA JSONB,
+            REQUEST_ID TEXT,
+            IP TEXT,
+            PREV_HASH CHAR(64),
+            HASH CHAR(64) NOT NULL
+        );
+		CREATE INDEX IF NOT EXISTS IDX_AUDIT_LOG_ENTITY ON AUDIT_LOG (ENTITY_TYPE, ENTITY_ID);
+		CREATE INDEX IF NOT EXISTS IDX_AUDIT_LOG_OCCURRED_AT ON AUDIT_LOG (OCCURRED_AT);
+
+		-- Hash of an audit row over its content and the previous row's hash, so
+		-- editing or removing any row breaks every hash after it
+		CREATE OR REPLACE FUNCTION AUDIT_ROW_HASH (A AUDIT_LOG) RETURNS CHAR(64) AS $fn$
+			SELECT ENCODE(SHA256(CONVERT_TO(CONCAT_WS('|',
+				COALESCE(A.PREV_HASH, ''), A.CHAIN_SEQ,
+				TO_CHAR(A.OCCURRED_AT AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US'),
+				A.ACTOR, A.ACTION, A.ENTITY_TYPE, A.ENTITY_ID,
+				COALESCE(A.BEFORE_DATA::TEXT, ''), COALESCE(A.AFTER_DATA::TEXT, ''),
+				COALESCE(A.REQUEST_ID, ''), COALESCE(A.IP, '')
+			), 'UTF8')), 'hex');
+		$fn$ LANGUAGE SQL IMMUTABLE;
+
+		-- Links each new row to the last one. Writers take turns so the chain
+		-- cannot fork; the lock is held until the writer commits, so it
+		-- serialises every transaction that records a change (see audit.Record).
+		CREATE OR REPLACE FUNCTION AUDIT_LOG_CHAIN () RETURNS TRIGGER AS $fn$
+		DECLARE
+			LAST_ROW AUDIT_LOG%ROWTYPE;
+		BEGIN
+			PERFORM PG_ADVISORY_XACT_LOCK(HASHTEXT('AUDIT_LOG'));
+			SELECT * INTO LAST_ROW FROM AUDIT_LOG ORDER BY CHAIN_SEQ DESC LIMIT 1;
+			NEW.CHAIN_SEQ := COALESCE(LAST_ROW.CHAIN_SEQ, 0) + 1;
+			NEW.PREV_HASH := LAST_ROW.HASH;
+			NEW.OCCURRED_AT := CLOCK_TIMESTAMP();
+			NEW.HASH := AUDIT_ROW_HASH(NEW);
+			RETURN NEW;
+		END;
+		$fn$ LANGUAGE PLPGSQL;
+
+		CREATE OR REPLACE FUNCTION AUDIT_LOG_APPEND_ONLY () RETURNS TRIGGER AS $fn$
+		BEGIN
+			RAISE EXCEPTION 'AUDIT_LOG is append-only';
+		END;
+		$fn$ LANGUAGE PLPGSQL;
+
+		DROP TRIGGER IF EXISTS AUDIT_LOG_CHAIN ON AUDIT_LOG;
+		CREATE TRIGGER AUDIT_LOG_CHAIN BEFORE INSERT ON AUDIT_LOG
+			FOR EACH ROW EXECUTE FUNCTION AUDIT_LOG_CHAIN();
+		DROP TRIGGER IF EXISTS AUDIT_LOG_APPEND_ONLY ON AUDIT_LOG;
+		CREATE TRIGGER AUDIT_LOG_APPEND_ONLY BEFORE UPDATE OR DELETE ON AUDIT_LOG
+			FOR EACH ROW EXECUTE FUNCTION AUDIT_LOG_APPEND_ONLY();
+		DROP TRIGGER IF EXISTS AUDIT_LOG_NO_TRUNCATE ON AUDIT_LOG;
+		CREATE TRIGGER AUDIT_LOG_NO_TRUNCATE BEFORE TRUNCATE ON AUDIT_LOG
+			FOR EACH STATEMENT EXECUTE FUNCTION AUDIT_LOG_APPEND_ONLY();
 	`)
 	if err != nil {
 		log.Fatalf("Unable to create tables: %v\n", err)
@@ -95,6 +263,149 @@ func InitDB() *pgxpool.Pool {
 		log.Fatalf("Unable to create tables: %v\n", err)
 	}
 
+	// Bring tables created from database_scripts up to date and define the
+	// functions the app relies on
+	_, err = dbPool.Exec(context.Background(), `
+		DO $$ BEGIN
+			CREATE TYPE BALANCE_POLICY AS ENUM('block', 'warn', 'allow');
+		EXCEPTION WHEN duplicate_object THEN NULL;
+		END $$;
+		ALTER TABLE WALLET ADD COLUMN IF NOT EXISTS CREDIT_LIMIT NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (CREDIT_LIMIT >= 0);
+		ALTER TABLE WALLET ADD COLUMN IF NOT EXISTS BALANCE_POLICY BALANCE_POLICY NOT NULL DEFAULT 'warn';
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'adjustment';
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'write_off';
+		ALTER TABLE WALLET_TRANSACTIONS ADD COLUMN IF NOT EXISTS REASON TEXT;
+		ALTER TABLE WALLET_TRANSACTIONS ADD COLUMN IF NOT EXISTS APPROVED_BY TEXT;
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'transfer_in';
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'transfer_out';
+		ALTER TABLE WALLET_TRANSACTIONS ADD COLUMN IF NOT EXISTS COUNTERPARTY_USER_ID INT REFERENCES USERS (USER_ID);
+		ALTER TABLE WALLET_TRANSACTIONS ADD COLUMN IF NOT EXISTS RELATED_TXN_ID INT REFERENCES WALLET_TRANSACTIONS (TXN_ID);
+		ALTER TABLE WALLET_TRANSACTIONS ADD COLUMN IF NOT EXISTS VALUE_DATE DATE;
+		ALTER TABLE USERS ADD COLUMN IF NOT EXISTS EMAIL TEXT;
+		ALTER TABLE USERS ADD COLUMN IF NOT EXISTS LOW_BALANCE_ALERTS BOOLEAN NOT NULL DEFAULT TRUE;
+		ALTER TABLE USERS ADD COLUMN IF NOT EXISTS IS_ACTIVE BOOLEAN NOT NULL DEFAULT TRUE;
+		ALTER TABLE USERS ADD COLUMN IF NOT EXISTS DEACTIVATED_AT TIMESTAMPTZ;
+		ALTER TABLE USERS ADD COLUMN IF NOT EXISTS MERGED_INTO INT REFERENCES USERS (USER_ID);
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'settlement';
+		ALTER TYPE TXN_TYPE ADD VALUE IF NOT EXISTS 'opening_balance';
+		CREATE TABLE IF NOT EXISTS PLAN_HISTORY (
+			USER_ID INT NOT NULL REFERENCES USERS (USER_ID),
+			PLAN SUBSCRIPTION_TYPE NOT NULL,
+			EFFECTIVE_FROM DATE NOT NULL,
+			CHANGED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (USER_ID, EFFECTIVE_FROM)
+		);
+		-- One-time codes customers log in to the self-service API with
+		CREATE TABLE IF NOT EXISTS CUSTOMER_OTPS (
+			OTP_ID SERIAL PRIMARY KEY,
+			USER_ID INT NOT NULL REFERENCES USERS (USER_ID),
+			CODE_HASH CHAR(64) NOT NULL,
+			ATTEMPTS INT NOT NULL DEFAULT 0,
+			CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			EXPIRES_AT TIMESTAMPTZ NOT NULL,
+			USED_AT TIMESTAMPTZ
+		);
+		CREATE INDEX IF NOT EXISTS IDX_CUSTOMER_OTPS_USER ON CUSTOMER_OTPS (USER_ID, CREATED_AT);
+		CREATE TABLE IF NOT EXISTS CUSTOMER_SESSIONS (
+			TOKEN_HASH CHAR(64) PRIMARY KEY,
+			USER_ID INT NOT NULL REFERENCES USERS (USER_ID),
+			CREATED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			EXPIRES_AT TIMESTAMPTZ NOT NULL,
+			REVOKED_AT TIMESTAMPTZ
+		);
+		-- Login codes asked for per mobile number, customer or not, so the
+		-- limit on them gives nothing away
+		CREATE TABLE IF NOT EXISTS OTP_REQUESTS (
+			MOBILE_NO VARCHAR(20) NOT NULL,
+			REQUESTED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS IDX_OTP_REQUESTS_MOBILE ON OTP_REQUESTS (MOBILE_NO, REQUESTED_AT);
+		-- Customers from before plan history started on their current plan
+		INSERT INTO PLAN_HISTORY (USER_ID, PLAN, EFFECTIVE_FROM)
+		SELECT u.USER_ID, u.PLAN, COALESCE((SELECT MIN(l.LOG_DATE) FROM DAILY_LOGS l WHERE l.USER_ID = u.USER_ID), CURRENT_DATE)
+		FROM USERS u
+		WHERE NOT EXISTS (SELECT 1 FROM PLAN_HISTORY h WHERE h.USER_ID = u.USER_ID);
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS HAS_MAIN_MEAL BOOLEAN NOT NULL DEFAULT TRUE;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS EXTRA_CHICKEN_QTY INT NOT NULL DEFAULT 0;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS EXTRA_FISH_QTY INT NOT NULL DEFAULT 0;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS EXTRA_EGG_QTY INT NOT NULL DEFAULT 0;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS EXTRA_VEGETABLE_QTY INT NOT NULL DEFAULT 0;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS DELETED_AT TIMESTAMPTZ;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS DELETED_BY TEXT;
+		ALTER TABLE DAILY_LOGS ADD COLUMN IF NOT EXISTS DELETE_REASON TEXT;
+		CREATE TABLE IF NOT EXISTS DAILY_LOG_ITEMS (
+			LOG_ID INT NOT NULL REFERENCES DAILY_LOGS (LOG_ID) ON DELETE CASCADE,
+			ITEM_ID VARCHAR(50) NOT NULL,
+			ITEM_NAME VARCHAR(100) NOT NULL,
+			QUANTITY INT NOT NULL,
+			UNIT_PRICE NUMERIC(10, 2) NOT NULL,
+			AMOUNT NUMERIC(10, 2) NOT NULL,
+			PRIMARY KEY (LOG_ID, ITEM_ID)
+		);
+		ALTER TABLE MEAL_PRICES ADD COLUMN IF NOT EXISTS HSN_SAC VARCHAR(8) NOT NULL DEFAULT '996331';
+		ALTER TABLE MEAL_PRICES ADD COLUMN IF NOT EXISTS TAX_RATE DECIMAL(5,2) NOT NULL DEFAULT 0;
+		ALTER TABLE MEAL_PRICES ADD COLUMN IF NOT EXISTS TAX_INCLUSIVE BOOLEAN NOT NULL DEFAULT TRUE;
+		ALTER TABLE MEAL_PRICES ADD COLUMN IF NOT EXISTS UNIT_COST DECIMAL(10,2) NOT NULL DEFAULT 0;
+		ALTER TABLE EXPENSES ADD COLUMN IF NOT EXISTS CATEGORY VARCHAR(20) NOT NULL DEFAULT 'other';
+		ALTER TABLE DAILY_LOG_ITEMS ADD COLUMN IF NOT EXISTS HSN_SAC VARCHAR(8) NOT NULL DEFAULT '';
+		ALTER TABLE DAILY_LOG_ITEMS ADD COLUMN IF NOT EXISTS TAX_RATE NUMERIC(5, 2) NOT NULL DEFAULT 0;
+		ALTER TABLE DAILY_LOG_ITEMS ADD COLUMN IF NOT EXISTS TAXABLE_VALUE NUMERIC(10, 2);
+		ALTER TABLE DAILY_LOG_ITEMS ADD COLUMN IF NOT EXISTS TAX_AMOUNT NUMERIC(10, 2) NOT NULL DEFAULT 0;
+
+		-- Single rule for whether a wallet may be debited, shared by the journal
+		-- and the delivery planning views. Debits that stay within the credit
+		-- limit are 'ok', otherwise the wallet's policy decides.
+		CREATE OR REPLACE FUNCTION WALLET_DEBIT_STANDING (
+			P_BALANCE NUMERIC,
+			P_CREDIT_LIMIT NUMERIC,
+			P_POLICY BALANCE_POLICY,
+			P_AMOUNT NUMERIC
+		) RETURNS TEXT AS $fn$
+		BEGIN
+			IF P_BALANCE - COALESCE(P_AMOUNT, 0) >= -P_CREDIT_LIMIT OR P_POLICY = 'allow' THEN
+				RETURN 'ok';
+			END IF;
+			RETURN P_POLICY::TEXT;
+		END;
+		$fn$ LANGUAGE PLPGSQL IMMUTABLE;
+
+		-- Signed effect of a transaction on the wallet balance; adjustments,
+		-- settlements and opening balances are stored signed, every other type
+		-- is a positive amount
+		CREATE OR REPLACE FUNCTION WALLET_TXN_DELTA (P_TYPE TEXT, P_AMOUNT NUMERIC) RETURNS NUMERIC AS $fn$
+			SELECT CASE WHEN P_TYPE IN ('delivery', 'transfer_out') THEN -P_AMOUNT ELSE P_AMOUNT END;
+		$fn$ LANGUAGE SQL IMMUTABLE;
+
+		-- Plan a customer was on for a given day
+		CREATE OR REPLACE FUNCTION USER_PLAN_ON (P_USER_ID INT, P_DATE DATE) RETURNS SUBSCRIPTION_TYPE AS $fn$
+			SELECT COALESCE(
+				(SELECT PLAN FROM PLAN_HISTORY WHERE USER_ID = P_USER_ID AND EFFECTIVE_FROM <= P_DATE ORDER BY EFFECTIVE_FROM DESC LIMIT 1),
+				(SELECT PLAN FROM USERS WHERE USER_ID = P_USER_ID)
+			);
+		$fn$ LANGUAGE SQL STABLE;
+	`)
+	if err != nil {
+		log.Fatalf("Unable to migrate tables: %v\n", err)
+	}
+
+	// Date transactions recorded before VALUE_DATE existed. Deliveries were
+	// stamped with the log date in UTC, everything else at the time it happened.
+	_, err = dbPool.Exec(context.Background(), `
+		UPDATE WALLET_TRANSACTIONS
+		SET VALUE_DATE = CASE
+			WHEN TXN_TYPE = 'delivery' THEN (CREATED_AT AT TIME ZONE 'UTC')::DATE
+			ELSE (CREATED_AT AT TIME ZONE $1)::DATE
+		END
+		WHERE VALUE_DATE IS NULL
+	`, BusinessTimezone())
+	if err != nil {
+		log.Fatalf("Unable to migrate tables: %v\n", err)
+	}
+
+	if err := normalizeMobiles(dbPool); err != nil {
+		log.Fatalf("Unable to migrate tables: %v\n", err)
+	}
+
 	log.Println("Connected to database successfully")
 	return dbPool
 }
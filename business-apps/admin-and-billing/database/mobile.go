@@ -0,0 +1,126 @@
+package database
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CountryCode is the calling code assumed for mobile numbers given without
+// one, from BUSINESS_COUNTRY_CODE (default 91).
+func CountryCode() string {
+	cc := strings.TrimPrefix(os.Getenv("BUSINESS_COUNTRY_CODE"), "+")
+	if cc == "" {
+		cc = "91"
+	}
+	return cc
+}
+
+// NormalizeMobile returns a mobile number in E.164 form, such as
+// +919876543210, ignoring spaces, dashes, dots and brackets. Numbers without
+// a country code must have ten digits, optionally after a trunk 0. It reports
+// false if the number cannot be read.
+func NormalizeMobile(s string) (string, bool) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(s))
+
+	international := false
+	switch {
+	case strings.HasPrefix(digits, "+"):
+		digits, international = digits[1:], true
+	case strings.HasPrefix(digits, "00"):
+		digits, international = digits[2:], true
+	}
+	if digits == "" || strings.Trim(digits, "0123456789") != "" {
+		return "", false
+	}
+
+	if international {
+		if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+			return "", false
+		}
+		return "+" + digits, true
+	}
+	cc := CountryCode()
+	switch {
+	case len(digits) == 11 && digits[0] == '0':
+		return "+" + cc + digits[1:], true
+	case len(digits) == 10:
+		return "+" + cc + digits, true
+	case len(digits) == len(cc)+10 && strings.HasPrefix(digits, cc):
+		return "+" + digits, true
+	}
+	return "", false
+}
+
+// normalizeMobiles rewrites stored mobile numbers in E.164 form and then makes
+// them unique. Numbers that cannot be read are left as they are. If
+// customers still share a number the constraint is skipped, and retried at
+// the next start or merge.
+func normalizeMobiles(dbPool *pgxpool.Pool) error {
+	ctx := context.Background()
+	rows, err := dbPool.Query(ctx, `SELECT USER_ID, MOBILE_NO FROM USERS WHERE MOBILE_NO IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	type mobile struct {
+		userID int
+		number string
+	}
+	var changed []mobile
+	for rows.Next() {
+		var m mobile
+		if err := rows.Scan(&m.userID, &m.number); err != nil {
+			rows.Close()
+			return err
+		}
+		if normalized, ok := NormalizeMobile(m.number); ok && normalized != m.number {
+			changed = append(changed, mobile{m.userID, normalized})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, m := range changed {
+		_, err := dbPool.Exec(ctx, `UPDATE USERS SET MOBILE_NO = $1 WHERE USER_ID = $2`, m.number, m.userID)
+		if IsUniqueViolation(err) {
+			log.Printf("Mobile number of user %d is already taken as %s; merge them\n", m.userID, m.number)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		updated++
+	}
+	if updated > 0 {
+		log.Printf("Normalized %d mobile numbers\n", updated)
+	}
+
+	_, err = EnsureMobileUnique(ctx)
+	return err
+}
+
+// EnsureMobileUnique creates the unique index on mobile numbers if it does
+// not exist yet. It reports false, without an error, while customers still
+// share a number.
+func EnsureMobileUnique(ctx context.Context) (bool, error) {
+	_, err := GetDbConn().Exec(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS USERS_MOBILE_NO_KEY ON USERS (MOBILE_NO) WHERE MERGED_INTO IS NULL
+	`)
+	if IsUniqueViolation(err) {
+		log.Println("Mobile numbers are not unique yet; merge the customers listed by /api/users/duplicates")
+		return false, nil
+	}
+	return err == nil, err
+}
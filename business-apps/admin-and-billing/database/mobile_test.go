@@ -0,0 +1,57 @@
+package database
+
+import "testing"
+
+func TestNormalizeMobile(t *testing.T) {
+	t.Setenv("BUSINESS_COUNTRY_CODE", "")
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"9876543210", "+919876543210", true},
+		{"98765 43210", "+919876543210", true},
+		{"(987) 654-3210", "+919876543210", true},
+		{"098765.43210", "+919876543210", true},
+		{"919876543210", "+919876543210", true},
+		{"+91 98765 43210", "+919876543210", true},
+		{"0091 98765 43210", "+919876543210", true},
+		{" +44 20 7946 0958 ", "+442079460958", true},
+		{"+1234567", "", false},
+		{"+1234567890123456", "", false},
+		{"+0987654321", "", false},
+		{"987654321", "", false},
+		{"98765432101", "", false},
+		{"98765-4321x", "", false},
+		{"+", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, ok := NormalizeMobile(tt.in)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("NormalizeMobile(%q) = %q, %v, want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeMobileCountryCode(t *testing.T) {
+	t.Setenv("BUSINESS_COUNTRY_CODE", "+44")
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"7946095800", "+447946095800"},
+		{"07946095800", "+447946095800"},
+		{"447946095800", "+447946095800"},
+		{"+919876543210", "+919876543210"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got, ok := NormalizeMobile(tt.in); got != tt.want || !ok {
+				t.Errorf("NormalizeMobile(%q) = %q, %v, want %q, true", tt.in, got, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// BusinessTimezone is the IANA name of the zone that defines the business
+// day, from BUSINESS_TZ (default Asia/Kolkata).
+func BusinessTimezone() string {
+	tz := os.Getenv("BUSINESS_TZ")
+	if tz == "" {
+		tz = "Asia/Kolkata"
+	}
+	return tz
+}
+
+// BusinessLocation is the time zone that defines the business day. It is
+// loaded once, on first use.
+var BusinessLocation = sync.OnceValue(func() *time.Location {
+	loc, err := time.LoadLocation(BusinessTimezone())
+	if err != nil {
+		log.Printf("Unable to load timezone %s, falling back to IST: %v\n", BusinessTimezone(), err)
+		loc = time.FixedZone("IST", 5*60*60+30*60)
+	}
+	return loc
+})
+
+// BusinessDate returns the business day t falls on, as midnight UTC so that
+// it is stored unchanged in DATE columns.
+func BusinessDate(t time.Time) time.Time {
+	y, m, d := t.In(BusinessLocation()).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -3,26 +3,51 @@ package expenses
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 	"strconv"
-	"time"
+	"strings"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/model"
 )
 
+// Categories are the kinds of expense. Food and packaging are the cost of
+// the meals served; the rest are overheads.
+var Categories = []string{"food", "packaging", "staff", "rent", "utilities", "other"}
+
+// CostOfGoods are the categories that make up food cost.
+var CostOfGoods = []string{"food", "packaging"}
+
+func checkCategory(v *api.Validator, category string) {
+	v.Check(category == "" || slices.Contains(Categories, category), "category", "must be one of "+strings.Join(Categories, ", "))
+}
+
+// GetExpenses lists expenses, optionally from ?start_date to ?end_date and
+// of one ?category.
 func GetExpenses(w http.ResponseWriter, r *http.Request) {
-	startDateStr := r.URL.Query().Get("start_date")
-	endDateStr := r.URL.Query().Get("end_date")
+	params := api.Query(r)
+	startDate := params.Date("start_date", params.Has("end_date"))
+	endDate := params.Date("end_date", params.Has("start_date"))
+	params.DateRange("start_date", startDate, "end_date", endDate)
+	category := params.String("category", false)
+	checkCategory(&params.Validator, category)
+	if params.Failed(w) {
+		return
+	}
 
-	query := `SELECT EXPENSE_ID, EXPENSE_DATE, REASON, AMOUNT, CREATED_AT FROM EXPENSES`
+	query := `SELECT EXPENSE_ID, EXPENSE_DATE, REASON, AMOUNT, CREATED_AT, CATEGORY FROM EXPENSES WHERE TRUE`
 	var args []interface{}
 
-	if startDateStr != "" && endDateStr != "" {
-		startDate, _ := time.Parse("2006-01-02", startDateStr)
-		endDate, _ := time.Parse("2006-01-02", endDateStr)
-		query += ` WHERE EXPENSE_DATE BETWEEN $1 AND $2`
+	if !startDate.IsZero() && !endDate.IsZero() {
 		args = append(args, startDate, endDate)
+		query += ` AND EXPENSE_DATE BETWEEN $1 AND $2`
+	}
+	if category != "" {
+		args = append(args, category)
+		query += ` AND CATEGORY = $` + strconv.Itoa(len(args))
 	}
 
 	query += ` ORDER BY EXPENSE_DATE DESC, CREATED_AT DESC`
@@ -30,7 +55,7 @@ func GetExpenses(w http.ResponseWriter, r *http.Request) {
 	dbPool := database.GetDbConn()
 	rows, err := dbPool.Query(r.Context(), query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer rows.Close()
@@ -38,9 +63,9 @@ func GetExpenses(w http.ResponseWriter, r *http.Request) {
 	var expenses []model.Expense
 	for rows.Next() {
 		var e model.Expense
-		err := rows.Scan(&e.ExpenseID, &e.ExpenseDate, &e.Reason, &e.Amount, &e.CreatedAt)
+		err := rows.Scan(&e.ExpenseID, &e.ExpenseDate, &e.Reason, &e.Amount, &e.CreatedAt, &e.Category)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 		expenses = append(expenses, e)
@@ -51,58 +76,142 @@ func GetExpenses(w http.ResponseWriter, r *http.Request) {
 
 func CreateExpense(w http.ResponseWriter, r *http.Request) {
 	var e model.Expense
-	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &e) {
+		return
+	}
+	var v api.Validator
+	checkCategory(&v, e.Category)
+	if v.Failed(w) {
 		return
 	}
 
 	dbPool := database.GetDbConn()
-	err := dbPool.QueryRow(r.Context(), `
-		INSERT INTO EXPENSES (EXPENSE_DATE, REASON, AMOUNT) 
-		VALUES ($1, $2, $3) 
-		RETURNING EXPENSE_ID, CREATED_AT
-	`, e.ExpenseDate, e.Reason, e.Amount).Scan(&e.ExpenseID, &e.CreatedAt)
+	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
+	defer tx.Rollback(r.Context())
 
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO EXPENSES (EXPENSE_DATE, REASON, AMOUNT, CATEGORY) 
+		VALUES ($1, $2, $3, COALESCE(NULLIF($4, ''), 'other')) 
+		RETURNING EXPENSE_ID, CREATED_AT, CATEGORY
+	`, e.ExpenseDate, e.Reason, e.Amount, e.Category).Scan(&e.ExpenseID, &e.CreatedAt, &e.Category)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "expense.create", e.ExpenseID, nil); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(e)
 }
 
 func UpdateExpense(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, _ := strconv.Atoi(idStr)
+	params := api.Path(r)
+	id := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
 
 	var e model.Expense
-	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &e) {
+		return
+	}
+	var v api.Validator
+	checkCategory(&v, e.Category)
+	if v.Failed(w) {
 		return
 	}
 
 	dbPool := database.GetDbConn()
-	_, err := dbPool.Exec(r.Context(), `
-		UPDATE EXPENSES SET EXPENSE_DATE = $1, REASON = $2, AMOUNT = $3 
-		WHERE EXPENSE_ID = $4
-	`, e.ExpenseDate, e.Reason, e.Amount, id)
+	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "EXPENSES", "EXPENSE_ID", id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Expense not found")
 		return
 	}
 
+	_, err = tx.Exec(r.Context(), `
+		UPDATE EXPENSES SET EXPENSE_DATE = $1, REASON = $2, AMOUNT = $3, CATEGORY = COALESCE(NULLIF($4, ''), CATEGORY) 
+		WHERE EXPENSE_ID = $5
+	`, e.ExpenseDate, e.Reason, e.Amount, e.Category, id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "expense.update", id, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 func DeleteExpense(w http.ResponseWriter, r *http.Request) {
-	idStr := chi.URLParam(r, "id")
-	id, _ := strconv.Atoi(idStr)
+	params := api.Path(r)
+	id := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
 
 	dbPool := database.GetDbConn()
-	_, err := dbPool.Exec(r.Context(), `DELETE FROM EXPENSES WHERE EXPENSE_ID = $1`, id)
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "EXPENSES", "EXPENSE_ID", id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Expense not found")
 		return
 	}
 
+	_, err = tx.Exec(r.Context(), `DELETE FROM EXPENSES WHERE EXPENSE_ID = $1`, id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "expense.delete", id, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// recordChange audits a change to an expense.
+func recordChange(r *http.Request, tx pgx.Tx, action string, id int, before json.RawMessage) error {
+	return audit.RecordRow(r.Context(), tx, audit.FromRequest(r), action, "expense", "EXPENSES", "EXPENSE_ID", id, before)
+}
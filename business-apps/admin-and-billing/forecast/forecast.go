@@ -0,0 +1,328 @@
+// Package forecast predicts the portions and extras each shift will need
+// from what was served on the same weekday in recent weeks, corrected for
+// the skips and one-off orders already known for the day.
+package forecast
+
+import (
+	"context"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/meals"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+const (
+	// Days ahead the job records forecasts for
+	horizonDays = 7
+	// Past same weekdays a forecast is based on, looked for this many weeks
+	// back so days the kitchen was closed are skipped
+	maxSamples    = 8
+	lookbackWeeks = 12
+	// z-score of an 80% band
+	bandZ = 1.2816
+)
+
+var shifts = []string{"lunch", "dinner"}
+
+// items are forecast in this order, each counted from DAILY_LOGS by expr.
+// The first two are the main meals.
+var items = []struct {
+	id, expr string
+}{
+	{"standard", "COUNT(*) FILTER (WHERE HAS_MAIN_MEAL AND NOT IS_SPECIAL)"},
+	{"special", "COUNT(*) FILTER (WHERE HAS_MAIN_MEAL AND IS_SPECIAL)"},
+	{"rice", "SUM(EXTRA_RICE_QTY)"},
+	{"roti", "SUM(EXTRA_ROTI_QTY)"},
+	{"chicken", "SUM(EXTRA_CHICKEN_QTY)"},
+	{"fish", "SUM(EXTRA_FISH_QTY)"},
+	{"egg", "SUM(EXTRA_EGG_QTY)"},
+	{"vegetable", "SUM(EXTRA_VEGETABLE_QTY)"},
+}
+
+type shiftKey struct {
+	date  string
+	shift string
+}
+
+func key(date time.Time, shift string) shiftKey {
+	return shiftKey{date.Format("2006-01-02"), shift}
+}
+
+// served counts each item per shift from one date to another, and reports
+// the dates the kitchen served anything at all.
+func served(ctx context.Context, from, to time.Time) (map[shiftKey][]int, map[string]bool, error) {
+	exprs := make([]string, len(items))
+	for i, item := range items {
+		exprs[i] = "COALESCE(" + item.expr + ", 0)"
+	}
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT LOG_DATE, MEAL_TYPE, `+strings.Join(exprs, ", ")+`
+		FROM DAILY_LOGS
+		WHERE LOG_DATE BETWEEN $1 AND $2 AND DELETED_AT IS NULL
+		GROUP BY LOG_DATE, MEAL_TYPE
+	`, from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	quantities := make(map[shiftKey][]int)
+	open := make(map[string]bool)
+	for rows.Next() {
+		var date time.Time
+		var shift string
+		q := make([]int, len(items))
+		dest := []interface{}{&date, &shift}
+		for i := range q {
+			dest = append(dest, &q[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, nil, err
+		}
+		quantities[key(date, shift)] = q
+		open[date.Format("2006-01-02")] = true
+	}
+	return quantities, open, rows.Err()
+}
+
+// perShift runs a query returning a date, shift and count per row.
+func perShift(ctx context.Context, query string, args ...interface{}) (map[shiftKey]int, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[shiftKey]int)
+	for rows.Next() {
+		var date time.Time
+		var shift string
+		var n int
+		if err := rows.Scan(&date, &shift, &n); err != nil {
+			return nil, err
+		}
+		counts[key(date, shift)] = n
+	}
+	return counts, rows.Err()
+}
+
+// skips counts skipped meals per shift.
+func skips(ctx context.Context, from, to time.Time) (map[shiftKey]int, error) {
+	return perShift(ctx, `
+		SELECT SKIP_DATE, SHIFT::TEXT, COUNT(*) FROM USER_SKIP
+		WHERE SKIP_DATE BETWEEN $1 AND $2
+		GROUP BY 1, 2
+	`, from, to)
+}
+
+// oneOffOrders counts one-off orders per shift. An order is for the first
+// of its weekday on or after the day it was placed.
+func oneOffOrders(ctx context.Context, from, to time.Time) (map[shiftKey]int, error) {
+	return perShift(ctx, `
+		SELECT FOR_DATE, SHIFT, COUNT(*) FROM (
+			SELECT ORDER_DATE + (ARRAY_POSITION(ENUM_RANGE(NULL::DAY), WEEKDAY) - EXTRACT(ISODOW FROM ORDER_DATE)::INT + 7) % 7 AS FOR_DATE,
+				SHIFT::TEXT AS SHIFT
+			FROM ONE_OFF_ORDERS
+		) o
+		WHERE FOR_DATE BETWEEN $1 AND $2
+		GROUP BY 1, 2
+	`, from, to)
+}
+
+// scheduled counts the monthly customers with a meal preference for each
+// weekday.
+func scheduled(ctx context.Context, today time.Time) (map[string]int, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT p.WEEKDAY::TEXT, COUNT(*)
+		FROM USER_PREFERENCES p
+		JOIN USERS u ON u.USER_ID = p.USER_ID
+		WHERE u.IS_ACTIVE AND u.MERGED_INTO IS NULL AND USER_PLAN_ON(u.USER_ID, $1) = 'monthly'
+		GROUP BY 1
+	`, today)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var weekday string
+		var n int
+		if err := rows.Scan(&weekday, &n); err != nil {
+			return nil, err
+		}
+		counts[weekday] = n
+	}
+	return counts, rows.Err()
+}
+
+// Build forecasts both shifts of each of the days after today.
+func Build(ctx context.Context, today time.Time, days int) ([]model.ShiftForecast, error) {
+	first, last := today.AddDate(0, 0, 1), today.AddDate(0, 0, days)
+	histStart := first.AddDate(0, 0, -7*lookbackWeeks)
+
+	history, open, err := served(ctx, histStart, today.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	skipped, err := skips(ctx, histStart, last)
+	if err != nil {
+		return nil, err
+	}
+	oneOffs, err := oneOffOrders(ctx, histStart, last)
+	if err != nil {
+		return nil, err
+	}
+	weekdays, err := scheduled(ctx, today)
+	if err != nil {
+		return nil, err
+	}
+	catalog := meals.GetMealCatalogInternal(ctx)
+
+	var forecasts []model.ShiftForecast
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		weekday := strings.ToLower(d.Weekday().String())
+		for _, shift := range shifts {
+			f := model.ShiftForecast{
+				Date:         d,
+				Weekday:      weekday,
+				Shift:        shift,
+				Scheduled:    weekdays[weekday],
+				Skips:        skipped[key(d, shift)],
+				OneOffOrders: oneOffs[key(d, shift)],
+			}
+
+			var samples [][]int
+			var pastSkips, pastOneOffs float64
+			for past := d.AddDate(0, 0, -7); !past.Before(histStart) && len(samples) < maxSamples; past = past.AddDate(0, 0, -7) {
+				if !past.Before(today) || !open[past.Format("2006-01-02")] {
+					continue
+				}
+				q := history[key(past, shift)]
+				if q == nil {
+					q = make([]int, len(items))
+				}
+				samples = append(samples, q)
+				pastSkips += float64(skipped[key(past, shift)])
+				pastOneOffs += float64(oneOffs[key(past, shift)])
+			}
+			f.Samples = len(samples)
+			f.Items = predict(samples, f, pastSkips, pastOneOffs, catalog)
+			forecasts = append(forecasts, f)
+		}
+	}
+	return forecasts, nil
+}
+
+// predict turns past quantities into item forecasts. Main meals follow the
+// past average, moved by how many more skips and one-off orders the day has
+// than the past days had; extras and the bands scale with the main meals.
+func predict(samples [][]int, f model.ShiftForecast, pastSkips, pastOneOffs float64, catalog map[string]model.MealPrice) []model.ItemForecast {
+	n := float64(len(samples))
+	mean := make([]float64, len(items))
+	sd := make([]float64, len(items))
+	for i := range items {
+		for _, q := range samples {
+			mean[i] += float64(q[i]) / n
+		}
+		if len(samples) > 1 {
+			for _, q := range samples {
+				sd[i] += math.Pow(float64(q[i])-mean[i], 2) / (n - 1)
+			}
+			sd[i] = math.Sqrt(sd[i])
+		}
+	}
+
+	meanMain := mean[0] + mean[1]
+	main := float64(f.Scheduled - f.Skips + f.OneOffOrders)
+	if len(samples) > 0 {
+		main = meanMain - (float64(f.Skips) - pastSkips/n) + (float64(f.OneOffOrders) - pastOneOffs/n)
+	}
+	main = math.Max(0, main)
+	ratio := 1.0
+	if meanMain > 0 {
+		ratio = main / meanMain
+	}
+
+	forecasts := make([]model.ItemForecast, len(items))
+	for i, item := range items {
+		predicted := mean[i] * ratio
+		if i == 0 && meanMain == 0 {
+			predicted = main
+		}
+		band := bandZ * sd[i] * ratio
+
+		name := item.id
+		if p, ok := catalog[item.id]; ok {
+			name = p.ItemName
+		}
+		forecasts[i] = model.ItemForecast{
+			ItemID:    item.id,
+			ItemName:  name,
+			Predicted: int(math.Round(predicted)),
+			Low:       int(math.Max(0, math.Floor(predicted-band))),
+			High:      int(math.Ceil(predicted + band)),
+		}
+	}
+	return forecasts
+}
+
+// Record stores forecasts made today, replacing any made earlier for the
+// same day and number of days ahead.
+func Record(ctx context.Context, today time.Time, forecasts []model.ShiftForecast) error {
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, f := range forecasts {
+		horizon := int(f.Date.Sub(today).Hours() / 24)
+		for _, item := range f.Items {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO FORECASTS (FORECAST_DATE, SHIFT, ITEM_ID, HORIZON, PREDICTED, LOW, HIGH)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (FORECAST_DATE, SHIFT, ITEM_ID, HORIZON) DO UPDATE
+				SET PREDICTED = EXCLUDED.PREDICTED, LOW = EXCLUDED.LOW, HIGH = EXCLUDED.HIGH, MADE_AT = NOW()
+			`, f.Date, f.Shift, item.ItemID, horizon, item.Predicted, item.Low, item.High)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// StartForecastJob records the coming week's forecasts every hour until ctx
+// is cancelled, so the last one made before each day can be checked against
+// what was served.
+func StartForecastJob(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			today := database.BusinessDate(time.Now())
+			forecasts, err := Build(ctx, today, horizonDays)
+			if err == nil {
+				err = Record(ctx, today, forecasts)
+			}
+			if err != nil {
+				log.Printf("Recording forecasts failed: %v\n", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
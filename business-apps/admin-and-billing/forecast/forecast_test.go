@@ -0,0 +1,99 @@
+package forecast
+
+import (
+	"testing"
+
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// sample returns a past shift's quantities in the order of items.
+func sample(q ...int) []int {
+	s := make([]int, len(items))
+	copy(s, q)
+	return s
+}
+
+func TestPredict(t *testing.T) {
+	type want struct {
+		predicted, low, high int
+	}
+	tests := []struct {
+		name        string
+		samples     [][]int
+		f           model.ShiftForecast
+		pastSkips   float64
+		pastOneOffs float64
+		// standard, special and rice; the other extras are never served here
+		want []want
+	}{
+		{
+			name: "no history falls back to the schedule",
+			f:    model.ShiftForecast{Scheduled: 10, Skips: 2, OneOffOrders: 1},
+			want: []want{{9, 9, 9}, {0, 0, 0}, {0, 0, 0}},
+		},
+		{
+			name: "no history never goes below zero",
+			f:    model.ShiftForecast{Scheduled: 1, Skips: 3},
+			want: []want{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}},
+		},
+		{
+			name:      "steady history with the usual skips",
+			samples:   [][]int{sample(10, 2, 4), sample(10, 2, 4)},
+			f:         model.ShiftForecast{Skips: 1},
+			pastSkips: 2,
+			want:      []want{{10, 10, 10}, {2, 2, 2}, {4, 4, 4}},
+		},
+		{
+			name:      "extra skips scale every item down",
+			samples:   [][]int{sample(10, 2, 4), sample(10, 2, 4)},
+			f:         model.ShiftForecast{Skips: 4},
+			pastSkips: 2,
+			want:      []want{{8, 7, 8}, {2, 1, 2}, {3, 3, 3}},
+		},
+		{
+			name:    "extra one-off orders scale every item up",
+			samples: [][]int{sample(8, 2, 5), sample(8, 2, 5)},
+			f:       model.ShiftForecast{OneOffOrders: 5},
+			want:    []want{{12, 12, 12}, {3, 3, 3}, {8, 7, 8}},
+		},
+		{
+			name:    "varying history gives an 80% band",
+			samples: [][]int{sample(8), sample(12)},
+			want:    []want{{10, 6, 14}, {0, 0, 0}, {0, 0, 0}},
+		},
+		{
+			name:    "one sample has no band",
+			samples: [][]int{sample(7, 1)},
+			want:    []want{{7, 7, 7}, {1, 1, 1}, {0, 0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := predict(tt.samples, tt.f, tt.pastSkips, tt.pastOneOffs, nil)
+			if len(got) != len(items) {
+				t.Fatalf("got %d items, want %d", len(got), len(items))
+			}
+			for i, g := range got {
+				w := want{}
+				if i < len(tt.want) {
+					w = tt.want[i]
+				}
+				if g.ItemID != items[i].id || g.Predicted != w.predicted || g.Low != w.low || g.High != w.high {
+					t.Errorf("item %d = %s %d [%d, %d], want %s %d [%d, %d]",
+						i, g.ItemID, g.Predicted, g.Low, g.High, items[i].id, w.predicted, w.low, w.high)
+				}
+			}
+		})
+	}
+}
+
+func TestPredictNames(t *testing.T) {
+	catalog := map[string]model.MealPrice{"standard": {ItemName: "Veg Thali"}}
+	got := predict(nil, model.ShiftForecast{}, 0, 0, catalog)
+	if got[0].ItemName != "Veg Thali" {
+		t.Errorf("standard is named %q, want the catalog name", got[0].ItemName)
+	}
+	if got[1].ItemName != "special" {
+		t.Errorf("special is named %q, want its id", got[1].ItemName)
+	}
+}
@@ -0,0 +1,149 @@
+package forecast
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// Longest forecast that can be asked for
+const maxDays = 14
+
+// GetForecast predicts each shift's portions and extras for the ?days days
+// after today (default 7).
+func GetForecast(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	days := params.Int("days", false)
+	if days == 0 {
+		days = horizonDays
+	}
+	params.Check(days <= maxDays, "days", fmt.Sprintf("must be at most %d", maxDays))
+	if params.Failed(w) {
+		return
+	}
+
+	forecasts, err := Build(r.Context(), database.BusinessDate(time.Now()), days)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(forecasts)
+}
+
+// GetAccuracy compares the forecasts recorded ?horizon days ahead (default
+// 1, the day before) with what was served from ?from to ?to (default the 30
+// days up to yesterday).
+func GetAccuracy(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	from := params.Date("from", false)
+	to := params.Date("to", false)
+	params.DateRange("from", from, "to", to)
+	horizon := params.Int("horizon", false)
+	if horizon == 0 {
+		horizon = 1
+	}
+	params.Check(horizon <= horizonDays, "horizon", fmt.Sprintf("must be at most %d", horizonDays))
+	if params.Failed(w) {
+		return
+	}
+	if to.IsZero() {
+		to = database.BusinessDate(time.Now()).AddDate(0, 0, -1)
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -29)
+	}
+
+	ctx := r.Context()
+	actual, open, err := served(ctx, from, to)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT FORECAST_DATE, SHIFT, ITEM_ID, PREDICTED, LOW, HIGH FROM FORECASTS
+		WHERE FORECAST_DATE BETWEEN $1 AND $2 AND HORIZON = $3
+	`, from, to, horizon)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	index := make(map[string]int)
+	for i, item := range items {
+		index[item.id] = i
+	}
+	scores := make([]itemScore, len(items))
+	for i, item := range items {
+		scores[i].ItemID = item.id
+	}
+	for rows.Next() {
+		var date time.Time
+		var shift, itemID string
+		var predicted, low, high int
+		if err := rows.Scan(&date, &shift, &itemID, &predicted, &low, &high); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		i, ok := index[itemID]
+		if !ok || !open[date.Format("2006-01-02")] {
+			continue
+		}
+		var got int
+		if q := actual[key(date, shift)]; q != nil {
+			got = q[i]
+		}
+
+		scores[i].add(predicted, low, high, got)
+	}
+	if err := rows.Err(); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	report := model.ForecastAccuracy{From: from, To: to, Horizon: horizon, Items: make([]model.ItemAccuracy, len(items))}
+	for i := range scores {
+		report.Items[i] = scores[i].result()
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// itemScore tallies how one item's forecasts compared with what was served.
+type itemScore struct {
+	model.ItemAccuracy
+	absError   float64
+	withinBand int
+}
+
+func (s *itemScore) add(predicted, low, high, got int) {
+	s.Shifts++
+	s.Predicted += predicted
+	s.Actual += got
+	s.absError += math.Abs(float64(predicted - got))
+	if got >= low && got <= high {
+		s.withinBand++
+	}
+}
+
+func (s *itemScore) result() model.ItemAccuracy {
+	a := s.ItemAccuracy
+	if a.Shifts == 0 {
+		return a
+	}
+	a.MeanAbsError = math.Round(s.absError/float64(a.Shifts)*100) / 100
+	a.Bias = math.Round(float64(a.Predicted-a.Actual)/float64(a.Shifts)*100) / 100
+	a.WithinBandPct = math.Round(float64(s.withinBand)/float64(a.Shifts)*1000) / 10
+	if a.Actual > 0 {
+		pct := math.Round(s.absError/float64(a.Actual)*1000) / 10
+		a.ErrorPct = &pct
+	}
+	return a
+}
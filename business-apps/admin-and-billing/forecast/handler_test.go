@@ -0,0 +1,73 @@
+package forecast
+
+import (
+	"testing"
+
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+func TestItemScore(t *testing.T) {
+	pct := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name string
+		// predicted, low, high and served for each shift
+		shifts [][4]int
+		want   model.ItemAccuracy
+	}{
+		{
+			name: "no shifts",
+			want: model.ItemAccuracy{},
+		},
+		{
+			name:   "under-prepared",
+			shifts: [][4]int{{10, 8, 12, 10}, {10, 8, 12, 14}},
+			want: model.ItemAccuracy{
+				Shifts: 2, Predicted: 20, Actual: 24, MeanAbsError: 2, ErrorPct: pct(16.7), Bias: -2, WithinBandPct: 50,
+			},
+		},
+		{
+			name:   "exact",
+			shifts: [][4]int{{5, 4, 6, 5}, {6, 5, 7, 6}, {7, 6, 8, 7}},
+			want: model.ItemAccuracy{
+				Shifts: 3, Predicted: 18, Actual: 18, ErrorPct: pct(0), WithinBandPct: 100,
+			},
+		},
+		{
+			name:   "nothing served",
+			shifts: [][4]int{{2, 0, 3, 0}},
+			want: model.ItemAccuracy{
+				Shifts: 1, Predicted: 2, MeanAbsError: 2, Bias: 2, WithinBandPct: 100,
+			},
+		},
+		{
+			name:   "rounded",
+			shifts: [][4]int{{3, 3, 3, 2}, {3, 3, 3, 2}, {3, 3, 3, 3}},
+			want: model.ItemAccuracy{
+				Shifts: 3, Predicted: 9, Actual: 7, MeanAbsError: 0.67, ErrorPct: pct(28.6), Bias: 0.67, WithinBandPct: 33.3,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s itemScore
+			for _, sh := range tt.shifts {
+				s.add(sh[0], sh[1], sh[2], sh[3])
+			}
+			got := s.result()
+			if (got.ErrorPct == nil) != (tt.want.ErrorPct == nil) || (got.ErrorPct != nil && *got.ErrorPct != *tt.want.ErrorPct) {
+				t.Errorf("error_pct = %v, want %v", deref(got.ErrorPct), deref(tt.want.ErrorPct))
+			}
+			got.ErrorPct, tt.want.ErrorPct = nil, nil
+			if got != tt.want {
+				t.Errorf("result() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func deref(p *float64) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -94,6 +94,13 @@ export const dict = {
         editExpense: "Edit Expense",
         reasonNote: "Reason / Note",
         explainExpense: "Explain what the expense was for...",
+        category: "Category",
+        category_food: "Food",
+        category_packaging: "Packaging",
+        category_staff: "Staff",
+        category_rent: "Rent",
+        category_utilities: "Utilities",
+        category_other: "Other",
         saveChanges: "Save Changes",
 
         // Daily Entry
@@ -120,6 +127,8 @@ export const dict = {
         outlook30Day: "30-Day Outlook",
         revenue30d: "30d Revenue",
         vsLastMonthRev: "+12% vs last month",
+        vsPreviousPeriod: "vs previous period",
+        noPreviousData: "No data for the previous period",
         expenses30d: "30d Expenses",
         vsLastMonthExp: "+5% vs last month",
         profitMargin: "Profit Margin",
@@ -229,6 +238,13 @@ export const dict = {
         editExpense: "খরচ সম্পাদনা করুন",
         reasonNote: "কারণ / নোট",
         explainExpense: "খরচ কিসের জন্য ছিল তা ব্যাখ্যা করুন...",
+        category: "বিভাগ",
+        category_food: "খাবার",
+        category_packaging: "প্যাকেজিং",
+        category_staff: "কর্মী",
+        category_rent: "ভাড়া",
+        category_utilities: "বিদ্যুৎ ও জল",
+        category_other: "অন্যান্য",
         saveChanges: "পরিবর্তন সংরক্ষণ করুন",
 
         // Daily Entry
@@ -255,6 +271,8 @@ export const dict = {
         outlook30Day: "৩০-দিনের পূর্বাভাস",
         revenue30d: "৩০ দিনের আয়",
         vsLastMonthRev: "+১২% গত মাসের তুলনায়",
+        vsPreviousPeriod: "আগের সময়ের তুলনায়",
+        noPreviousData: "আগের সময়ের কোনো তথ্য নেই",
         expenses30d: "৩০ দিনের খরচ",
         vsLastMonthExp: "+৫% গত মাসের তুলনায়",
         profitMargin: "মুনাফার মার্জিন",
@@ -10,6 +10,17 @@ import Billing from './pages/Billing';
 import Expenses from './pages/Expenses';
 import Analytics from './pages/Analytics';
 import './index.css';
+import axios from 'axios';
+import { getOperatorName } from './lib/operator';
+
+// Name the operator on every change so it shows in the audit log
+axios.interceptors.request.use(config => {
+    if (config.method && config.method.toLowerCase() !== 'get') {
+        const name = getOperatorName();
+        if (name) config.headers.set('X-Actor', name);
+    }
+    return config;
+});
 
 const root = document.getElementById('root');
 
@@ -0,0 +1,19 @@
+// Keeps one Idempotency-Key per payload so retrying a failed save reuses the
+// same key, while changing the form first gets a fresh one.
+export const createIdempotencyKey = () => {
+    let pending: { key: string; body: string } | null = null;
+
+    return {
+        keyFor(payload: object) {
+            const body = JSON.stringify(payload);
+            if (!pending || pending.body !== body) {
+                pending = { key: crypto.randomUUID(), body };
+            }
+            return pending.key;
+        },
+        // Call once the request has succeeded
+        reset() {
+            pending = null;
+        },
+    };
+};
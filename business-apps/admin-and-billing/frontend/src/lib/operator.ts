@@ -0,0 +1,12 @@
+const STORAGE_KEY = 'operatorName';
+
+// Name recorded against changes made from this browser. Asked for once and
+// remembered; returns null if the operator cancels.
+export const getOperatorName = (): string | null => {
+    let name = localStorage.getItem(STORAGE_KEY);
+    if (!name) {
+        name = prompt('Your name (recorded against this change):')?.trim() || null;
+        if (name) localStorage.setItem(STORAGE_KEY, name);
+    }
+    return name;
+};
@@ -21,6 +21,10 @@ const Analytics = () => {
         }
     });
 
+    // Change against the previous period, e.g. "+12.5% vs previous period"
+    const vsPrevious = (pct: number | null | undefined) =>
+        pct == null ? t('noPreviousData') : `${pct >= 0 ? '+' : ''}${pct}% ${t('vsPreviousPeriod')}`;
+
     const trendChartOptions = () => ({
         chart: {
             id: 'revenue-trend-chart',
@@ -122,14 +126,14 @@ const Analytics = () => {
                         value={`₹${stats()?.total_revenue.toLocaleString('en-IN')}`}
                         icon={TrendingUp}
                         color="text-emerald-400"
-                        trend={t('vsLastMonthRev')}
+                        trend={vsPrevious(stats()?.change.revenue_pct)}
                     />
                     <SummaryCard
                         label={t('expenses30d')}
                         value={`₹${stats()?.total_expenses.toLocaleString('en-IN')}`}
                         icon={DollarSign}
                         color="text-rose-400"
-                        trend={t('vsLastMonthExp')}
+                        trend={vsPrevious(stats()?.change.expenses_pct)}
                     />
                     <SummaryCard
                         label={t('profitMargin')}
@@ -1,6 +1,7 @@
 import { createSignal, onMount, For } from 'solid-js';
 import axios from 'axios';
 import { User } from '../types';
+import { createIdempotencyKey } from '../lib/idempotency';
 import { Plus, Search, Wallet as WalletIcon } from 'lucide-solid';
 import { useI18n } from '../i18n';
 
@@ -204,6 +205,7 @@ const RechargeForm = (props: { user: User; onSuccess: (newBalance: number) => vo
     const { t } = useI18n();
     const [amount, setAmount] = createSignal('');
     const [refId, setRefId] = createSignal('');
+    const rechargeKey = createIdempotencyKey();
 
     // Get current datetime in local format for datetime-local input
     const getCurrentDateTime = () => {
@@ -224,12 +226,16 @@ const RechargeForm = (props: { user: User; onSuccess: (newBalance: number) => vo
             // Convert datetime-local value to ISO timestamp
             const timestamp = new Date(txnDateTime()).toISOString();
 
-            const res = await axios.post('/api/wallet/recharge', {
+            const payload = {
                 user_id: props.user.user_id,
                 amount: parseFloat(amount()),
                 ref_id: refId(),
                 txn_date: timestamp
+            };
+            const res = await axios.post('/api/wallet/recharge', payload, {
+                headers: { 'Idempotency-Key': rechargeKey.keyFor(payload) }
             });
+            rechargeKey.reset();
             props.onSuccess(res.data.new_balance);
         } catch (err) {
             alert('Failed to recharge');
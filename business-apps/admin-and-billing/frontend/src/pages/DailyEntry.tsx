@@ -1,7 +1,9 @@
 import axios from 'axios';
 import { Check, ChefHat, Moon, Salad, SquarePen, Sun, Trash2, Utensils, X } from 'lucide-solid';
 import { For, createEffect, createSignal, onMount } from 'solid-js';
-import { DailyLog, User } from '../types';
+import { ApiError, DailyLog, User } from '../types';
+import { createIdempotencyKey } from '../lib/idempotency';
+import { getOperatorName } from '../lib/operator';
 import { useI18n } from '../i18n';
 
 import { globalUsers, globalUserTrie, loadUsers, updateUserBalance } from '../store/userStore';
@@ -27,6 +29,7 @@ const DailyEntry = () => {
     const [isSubmitting, setIsSubmitting] = createSignal(false);
     const [successMsg, setSuccessMsg] = createSignal(false);
     const [editingLog, setEditingLog] = createSignal<DailyLog | null>(null);
+    const entryKey = createIdempotencyKey();
 
 
 
@@ -39,8 +42,9 @@ const DailyEntry = () => {
         }
         
         if (val.trim().length >= 3) {
-            const results = globalUserTrie().search(val.trim(), 5);
-            setSuggestions(results);
+            // Customers who have left get no new entries
+            const results = globalUserTrie().search(val.trim(), 20).filter(u => u.is_active);
+            setSuggestions(results.slice(0, 5));
             setShowSuggestions(true);
         } else {
             setSuggestions([]);
@@ -97,10 +101,13 @@ const DailyEntry = () => {
     const handleDelete = async (logId: number) => {
         const logToDelete = logs().find(l => l.log_id === logId);
         if (!logToDelete) return;
-        if (!confirm('Are you sure you want to delete this entry? This will refund the cost to the user\'s wallet.')) return;
+        const reason = prompt('Why is this entry being deleted? The cost will be refunded to the user\'s wallet.')?.trim();
+        if (!reason) return;
+        const deletedBy = getOperatorName();
+        if (!deletedBy) return;
 
         try {
-            const res = await axios.delete(`/api/daily-entry/${logId}`);
+            const res = await axios.delete(`/api/daily-entry/${logId}`, { data: { deleted_by: deletedBy, reason } });
             setSuccessMsg(true); // Reuse success msg or create a new one
             setTimeout(() => setSuccessMsg(false), 3000);
             await fetchLogs();
@@ -117,7 +124,7 @@ const DailyEntry = () => {
 
         setIsSubmitting(true);
         try {
-            const res = await axios.post('/api/daily-entry', {
+            const payload = {
                 user_id: parseInt(selectedUser()),
                 log_date: new Date(date()).toISOString(),
                 meal_type: mealType(),
@@ -129,8 +136,21 @@ const DailyEntry = () => {
                 extra_chicken_qty: extraChicken(),
                 extra_fish_qty: extraFish(),
                 extra_egg_qty: extraEgg(),
-                extra_vegetable_qty: extraVegetable()
-            });
+                extra_vegetable_qty: extraVegetable(),
+                second_portion: false
+            };
+            const post = (body: typeof payload) =>
+                axios.post('/api/daily-entry', body, { headers: { 'Idempotency-Key': entryKey.keyFor(body) } });
+            let res;
+            try {
+                res = await post(payload);
+            } catch (err) {
+                // One entry per shift: ask before recording another portion
+                if (!axios.isAxiosError<ApiError>(err) || err.response?.data?.error?.code !== 'duplicate_entry') throw err;
+                if (!confirm('This customer already has an entry for this shift. Record a second portion?')) return;
+                res = await post({ ...payload, second_portion: true });
+            }
+            entryKey.reset();
             setSuccessMsg(true);
             setTimeout(() => setSuccessMsg(false), 3000);
             updateUserBalance(parseInt(selectedUser()), res.data.new_balance);
@@ -1,6 +1,6 @@
 import { createSignal, onMount, For, createEffect } from 'solid-js';
 import axios from 'axios';
-import { Expense } from '../types';
+import { Expense, ExpenseCategory } from '../types';
 import { useI18n } from '../i18n';
 import {
     Plus,
@@ -27,6 +27,9 @@ const Expenses = () => {
     const [formDate, setFormDate] = createSignal(new Date().toISOString().split('T')[0]);
     const [formReason, setFormReason] = createSignal('');
     const [formAmount, setFormAmount] = createSignal('');
+    const [formCategory, setFormCategory] = createSignal<ExpenseCategory>('other');
+
+    const categories: ExpenseCategory[] = ['food', 'packaging', 'staff', 'rent', 'utilities', 'other'];
 
     const fetchExpenses = async () => {
         try {
@@ -52,7 +55,8 @@ const Expenses = () => {
             const payload = {
                 expense_date: new Date(formDate()).toISOString(),
                 reason: formReason(),
-                amount: parseFloat(formAmount())
+                amount: parseFloat(formAmount()),
+                category: formCategory()
             };
 
             if (editingExpense()) {
@@ -91,6 +95,7 @@ const Expenses = () => {
         setFormDate(exp.expense_date.split('T')[0]);
         setFormReason(exp.reason);
         setFormAmount(exp.amount.toString());
+        setFormCategory(exp.category);
         setShowAddModal(true);
     };
 
@@ -99,6 +104,7 @@ const Expenses = () => {
         setFormDate(new Date().toISOString().split('T')[0]);
         setFormReason('');
         setFormAmount('');
+        setFormCategory('other');
         setShowAddModal(true);
     };
 
@@ -262,6 +268,21 @@ const Expenses = () => {
                                 />
                             </div>
 
+                            <div class="space-y-1">
+                                <label class="text-xs font-bold text-[var(--md-sys-color-primary)] ml-4 mb-1 block tracking-wider uppercase flex items-center gap-2">
+                                    <FileText size={12} /> {t('category')}
+                                </label>
+                                <select
+                                    value={formCategory()}
+                                    onChange={(e) => setFormCategory(e.currentTarget.value as ExpenseCategory)}
+                                    class="input-filled"
+                                >
+                                    <For each={categories}>
+                                        {(c) => <option value={c}>{t(`category_${c}`)}</option>}
+                                    </For>
+                                </select>
+                            </div>
+
                             <div class="space-y-1">
                                 <label class="text-xs font-bold text-[var(--md-sys-color-primary)] ml-4 mb-1 block tracking-wider uppercase flex items-center gap-2">
                                     <IndianRupee size={12} /> {t('amount')}
@@ -7,6 +7,93 @@ export interface User {
     role: 'normal' | 'admin';
     plan: 'monthly' | 'one_off';
     balance: number;
+    email: string;
+    low_balance_alerts: boolean;
+    credit_limit: number;
+    balance_policy: 'block' | 'warn' | 'allow';
+    is_active: boolean;
+    deactivated_at?: string;
+}
+
+export interface UserSuggestion {
+    user_id: number;
+    name: string;
+    mobile_no: string;
+    building_no: string;
+    room_no: string;
+    balance: number;
+}
+
+export interface WalletTransaction {
+    txn_id: number;
+    user_id: number;
+    name?: string;
+    txn_type: string;
+    status: 'pending_acknowledgement' | 'confirmed' | 'rejected';
+    amount: number;
+    balance_after?: number;
+    ref_id: string;
+    reason: string;
+    value_date: string;
+    created_at: string;
+}
+
+export interface MergeResult {
+    user_id: number;
+    source_user_id: number;
+    logs_moved: number;
+    txns_moved: number;
+    balance_moved: number;
+    new_balance: number;
+    overlapping_entries: number;
+}
+
+export interface DuplicateMobile {
+    mobile_no: string;
+    users: UserSuggestion[];
+}
+
+export interface ImportRow {
+    line: number;
+    user_id?: number;
+    name: string;
+    mobile_no: string;
+    building_no: string;
+    room_no: string;
+    plan: 'monthly' | 'one_off';
+    email: string;
+    opening_balance: number;
+    credit_limit: number;
+    balance_policy: 'block' | 'warn' | 'allow';
+}
+
+export interface ImportResult {
+    dry_run: boolean;
+    rows: ImportRow[];
+    total_opening_balance: number;
+}
+
+export interface UserProfile {
+    user: User;
+    preferences: { weekday: string; pref: 'veg' | 'non_veg' }[];
+    last_recharge?: { txn_id: number; amount: number; date: string; ref_id: string };
+    meal_counts: { shift: 'lunch' | 'dinner'; last_30_days: number; last_90_days: number }[];
+    favourite_extras: { item: string; quantity: number }[];
+    skips: { date: string; shift: 'lunch' | 'dinner' }[];
+    avg_monthly_spend: number;
+}
+
+export interface PlanChange {
+    plan: 'monthly' | 'one_off';
+    effective_from: string;
+    changed_at: string;
+}
+
+export interface Settlement {
+    user_id: number;
+    txn_id?: number;
+    direction: 'refund' | 'collect' | 'none';
+    amount: number;
 }
 
 export interface DailyLog {
@@ -25,6 +112,31 @@ export interface DailyLog {
     extra_egg_qty: number;
     extra_vegetable_qty: number;
     total_cost: number;
+    items?: BillLine[];
+    // Only set on deleted entries
+    deleted_at?: string;
+    deleted_by?: string;
+    delete_reason?: string;
+}
+
+export interface BillLine {
+    item_id: string;
+    item_name: string;
+    quantity: number;
+    unit_price: number;
+    amount: number;
+    hsn_sac?: string;
+    tax_rate: number;
+    taxable_value: number;
+    tax_amount: number;
+    estimated?: boolean;
+}
+
+export interface ItemTotal {
+    item_id: string;
+    item_name: string;
+    quantity: number;
+    amount: number;
 }
 
 export interface Expense {
@@ -33,17 +145,38 @@ export interface Expense {
     reason: string;
     amount: number;
     created_at: string;
+    category: ExpenseCategory;
 }
 
+export type ExpenseCategory = 'food' | 'packaging' | 'staff' | 'rent' | 'utilities' | 'other';
+
 export interface BillReport {
     user: User;
     start_date: string;
     end_date: string;
     logs: DailyLog[];
+    item_totals: ItemTotal[];
     total_spent: number;
     total_recharges: number;
+    total_adjustments: number;
+    total_write_offs: number;
+    total_transfers_in: number;
+    total_transfers_out: number;
+    total_settlements: number;
+    total_brought_forward: number;
     opening_balance: number;
     closing_balance: number;
+    timezone: string;
+    reconciliation: Reconciliation;
+    taxable_value: number;
+    total_tax: number;
+}
+
+export interface Reconciliation {
+    expected: number;
+    actual: number;
+    difference: number;
+    balanced: boolean;
 }
 
 export interface DashboardStats {
@@ -53,20 +186,226 @@ export interface DashboardStats {
     monthly_revenue: number;
     monthly_expenses: number;
     active_customers: number;
+    registered_customers: number;
     wallet_pool: number;
+    total_adjustments: number;
+    total_write_offs: number;
 }
 
 export interface TrendPoint {
     date: string;
+    end_date: string;
     revenue: number;
     expenses: number;
 }
 
-export interface AnalyticsStats {
-    trends: TrendPoint[];
+export interface AnalyticsPeriod {
+    from: string;
+    to: string;
     meal_types: { [key: string]: number };
     shifts: { [key: string]: number };
     total_revenue: number;
     total_expenses: number;
     profit_percentage: number;
 }
+
+export interface AnalyticsStats extends AnalyticsPeriod {
+    granularity: 'day' | 'week' | 'month';
+    trends: TrendPoint[];
+    previous: AnalyticsPeriod;
+    change: {
+        revenue_pct: number | null;
+        expenses_pct: number | null;
+        meals_pct: number | null;
+        profit_points: number;
+    };
+}
+
+export interface Cohort {
+    month: string;
+    customers: number;
+    retained: number[];
+    retention_pct: number[];
+}
+
+export interface CohortReport {
+    from: string;
+    to: string;
+    cohorts: Cohort[];
+    curve: number[];
+}
+
+export interface ChurnedCustomer {
+    user_id: number;
+    name: string;
+    mobile_no: string;
+    building_no: string;
+    room_no: string;
+    last_meal: string;
+    days_since: number;
+    balance: number;
+    monthly_spend: number;
+}
+
+export interface ChurnReport {
+    days: number;
+    active_customers: number;
+    churned_customers: number;
+    never_ordered: number;
+    churn_rate_pct: number;
+    revenue_at_risk: number;
+    balance_held: number;
+    customers: ChurnedCustomer[];
+}
+
+export interface ItemForecast {
+    item_id: string;
+    item_name: string;
+    predicted: number;
+    low: number;
+    high: number;
+}
+
+export interface ShiftForecast {
+    date: string;
+    weekday: string;
+    shift: 'lunch' | 'dinner';
+    samples: number;
+    scheduled: number;
+    skips: number;
+    one_off_orders: number;
+    items: ItemForecast[];
+}
+
+export interface ItemAccuracy {
+    item_id: string;
+    shifts: number;
+    predicted: number;
+    actual: number;
+    mean_abs_error: number;
+    error_pct: number | null;
+    bias: number;
+    within_band_pct: number;
+}
+
+export interface ForecastAccuracy {
+    from: string;
+    to: string;
+    horizon: number;
+    items: ItemAccuracy[];
+}
+
+export interface MarginContribution {
+    revenue: number;
+    cost: number;
+    margin: number;
+    share_pct: number;
+}
+
+export interface ItemMargin {
+    item_id: string;
+    item_name: string;
+    quantity: number;
+    revenue: number;
+    unit_cost: number;
+    cost: number;
+    margin: number;
+    margin_pct: number;
+}
+
+export interface Profitability {
+    from: string;
+    to: string;
+    days: number;
+    meals_served: number;
+    revenue: number;
+    food_cost: number;
+    standard_food_cost: number;
+    cost_basis: 'expenses' | 'standard';
+    cost_per_meal: number;
+    gross_margin: number;
+    gross_margin_pct: number;
+    overheads: number;
+    overheads_per_day: number;
+    contribution_per_meal: number;
+    break_even_meals_per_day: number | null;
+    main_meals: MarginContribution;
+    extras: MarginContribution;
+    items: ItemMargin[];
+}
+
+export interface BulkBillJob {
+    job_id: string;
+    format: 'zip' | 'csv' | 'xlsx';
+    start_date: string;
+    end_date: string;
+    status: 'running' | 'done' | 'failed';
+    total: number;
+    done: number;
+    failed_user_ids?: number[];
+    error?: string;
+    download_url?: string;
+    created_at: string;
+    finished_at?: string;
+}
+
+export interface InvoiceLine {
+    line_no: number;
+    item_name: string;
+    hsn_sac: string;
+    quantity: number;
+    tax_rate: number;
+    taxable_value: number;
+    cgst: number;
+    sgst: number;
+    total: number;
+}
+
+export interface Invoice {
+    invoice_id: number;
+    invoice_no: string;
+    financial_year: string;
+    invoice_date: string;
+    user: User;
+    period_start: string;
+    period_end: string;
+    gstin: string;
+    place_of_supply: string;
+    taxable_value: number;
+    cgst: number;
+    sgst: number;
+    total: number;
+    lines?: InvoiceLine[];
+    created_at: string;
+}
+
+// Body of every failed /api request
+export interface ApiError {
+    error: {
+        code: string;
+        message: string;
+        fields?: { field: string; message: string }[];
+    };
+}
+
+export interface AuditEntry {
+    audit_id: number;
+    seq: number;
+    occurred_at: string;
+    actor: string;
+    action: string;
+    entity_type: string;
+    entity_id: string;
+    before?: Record<string, unknown>;
+    after?: Record<string, unknown>;
+    request_id?: string;
+    ip?: string;
+    hash: string;
+}
+
+export interface AuditVerification {
+    valid: boolean;
+    checked: number;
+    broken_at_seq?: number;
+    problem?: string;
+}
@@ -0,0 +1,155 @@
+// Package idempotency lets clients safely retry money-moving requests. A
+// request carrying an Idempotency-Key header runs once; retries with the same
+// key get the stored response back instead of running again.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+)
+
+const (
+	Header = "Idempotency-Key"
+	// Set on responses replayed from an earlier request
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLen = 255
+	// How long a key is remembered
+	keyTTL = 24 * time.Hour
+)
+
+// recorder passes the response through while keeping a copy to store.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *recorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Handle wraps a handler so that requests with an Idempotency-Key are
+// processed at most once per endpoint. Requests without the header are
+// handled as usual.
+func Handle(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLen {
+			api.WriteError(w, http.StatusBadRequest, api.CodeValidationFailed, "Some fields are invalid",
+				api.FieldError{Field: Header, Message: "must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			api.BadRequest(w, "Could not read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		hash := hex.EncodeToString(sum[:])
+
+		ctx := r.Context()
+		dbPool := database.GetDbConn()
+		if _, err := dbPool.Exec(ctx, `DELETE FROM IDEMPOTENCY_KEYS WHERE CREATED_AT < $1`, time.Now().Add(-keyTTL)); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+
+		// Claim the key; if someone already has, replay or refuse
+		tag, err := dbPool.Exec(ctx, `
+			INSERT INTO IDEMPOTENCY_KEYS (IDEMPOTENCY_KEY, ENDPOINT, REQUEST_HASH)
+			VALUES ($1, $2, $3)
+			ON CONFLICT DO NOTHING
+		`, key, endpoint, hash)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			var storedHash string
+			var status *int
+			var stored []byte
+			err := dbPool.QueryRow(ctx, `
+				SELECT REQUEST_HASH, STATUS_CODE, RESPONSE_BODY FROM IDEMPOTENCY_KEYS
+				WHERE IDEMPOTENCY_KEY = $1 AND ENDPOINT = $2
+			`, key, endpoint).Scan(&storedHash, &status, &stored)
+			if err != nil {
+				api.InternalError(w, err)
+				return
+			}
+			switch {
+			case storedHash != hash:
+				api.WriteError(w, http.StatusUnprocessableEntity, api.CodeRuleViolation, "Idempotency-Key was already used for a different request")
+			case status == nil:
+				api.Conflict(w, "A request with this Idempotency-Key is still being processed")
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set(ReplayedHeader, "true")
+				w.WriteHeader(*status)
+				w.Write(stored)
+			}
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		// Record the outcome even if the client has gone away
+		ctx = context.WithoutCancel(ctx)
+		release := func() error {
+			_, err := dbPool.Exec(ctx, `DELETE FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY = $1 AND ENDPOINT = $2`, key, endpoint)
+			return err
+		}
+		// Free the key if next panics, or every retry would be refused as
+		// still being processed
+		finished := false
+		defer func() {
+			if finished {
+				return
+			}
+			if err := release(); err != nil {
+				log.Printf("Failed to release idempotency key %q: %v\n", key, err)
+			}
+		}()
+		next(rec, r)
+		finished = true
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// Server errors may be transient, so free the key for a retry
+		if rec.status >= 500 {
+			err = release()
+		} else {
+			_, err = dbPool.Exec(ctx, `
+				UPDATE IDEMPOTENCY_KEYS SET STATUS_CODE = $3, RESPONSE_BODY = $4
+				WHERE IDEMPOTENCY_KEY = $1 AND ENDPOINT = $2
+			`, key, endpoint, rec.status, rec.body.Bytes())
+		}
+		if err != nil {
+			log.Printf("Failed to record response for idempotency key %q: %v\n", key, err)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package invoices
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+)
+
+// GST state codes as used for place of supply
+var stateNames = map[string]string{
+	"01": "Jammu and Kashmir", "02": "Himachal Pradesh", "03": "Punjab", "04": "Chandigarh",
+	"05": "Uttarakhand", "06": "Haryana", "07": "Delhi", "08": "Rajasthan", "09": "Uttar Pradesh",
+	"10": "Bihar", "11": "Sikkim", "12": "Arunachal Pradesh", "13": "Nagaland", "14": "Manipur",
+	"15": "Mizoram", "16": "Tripura", "17": "Meghalaya", "18": "Assam", "19": "West Bengal",
+	"20": "Jharkhand", "21": "Odisha", "22": "Chhattisgarh", "23": "Madhya Pradesh", "24": "Gujarat",
+	"26": "Dadra and Nagar Haveli and Daman and Diu", "27": "Maharashtra", "29": "Karnataka",
+	"30": "Goa", "31": "Lakshadweep", "32": "Kerala", "33": "Tamil Nadu", "34": "Puducherry",
+	"35": "Andaman and Nicobar Islands", "36": "Telangana", "37": "Andhra Pradesh", "38": "Ladakh",
+}
+
+func placeOfSupply(code string) string {
+	if name, ok := stateNames[code]; ok {
+		return code + "-" + name
+	}
+	return code
+}
+
+// ExportGSTR1 writes the outward supplies invoiced in ?period=YYYY-MM as a
+// GSTR-1 style CSV. ?section=b2cs (default) summarises taxable B2C supplies
+// by place of supply and rate; ?section=hsn summarises by HSN/SAC code.
+func ExportGSTR1(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	period := params.Month("period", true)
+	section := params.String("section", false)
+	if section == "" {
+		section = "b2cs"
+	}
+	params.Check(section == "b2cs" || section == "hsn", "section", "must be b2cs or hsn")
+	if params.Failed(w) {
+		return
+	}
+
+	var query string
+	var header []string
+	switch section {
+	case "b2cs":
+		header = []string{"Type", "Place Of Supply", "Rate", "Applicable % of Tax Rate", "Taxable Value", "Cess Amount", "E-Commerce GSTIN"}
+		query = `
+			SELECT i.PLACE_OF_SUPPLY, l.TAX_RATE, SUM(l.TAXABLE_VALUE)
+			FROM INVOICES i
+			JOIN INVOICE_LINES l ON l.INVOICE_ID = i.INVOICE_ID
+			WHERE i.INVOICE_DATE >= $1 AND i.INVOICE_DATE < $2 AND l.TAX_RATE > 0
+			GROUP BY i.PLACE_OF_SUPPLY, l.TAX_RATE
+			ORDER BY i.PLACE_OF_SUPPLY, l.TAX_RATE
+		`
+	case "hsn":
+		header = []string{"HSN", "Description", "UQC", "Total Quantity", "Total Value", "Rate", "Taxable Value", "Integrated Tax Amount", "Central Tax Amount", "State/UT Tax Amount", "Cess Amount"}
+		query = `
+			SELECT l.HSN_SAC, STRING_AGG(DISTINCT l.ITEM_NAME, ', '), SUM(l.QUANTITY), SUM(l.TOTAL), l.TAX_RATE,
+				SUM(l.TAXABLE_VALUE), SUM(l.CGST), SUM(l.SGST)
+			FROM INVOICES i
+			JOIN INVOICE_LINES l ON l.INVOICE_ID = i.INVOICE_ID
+			WHERE i.INVOICE_DATE >= $1 AND i.INVOICE_DATE < $2
+			GROUP BY l.HSN_SAC, l.TAX_RATE
+			ORDER BY l.HSN_SAC, l.TAX_RATE
+		`
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), query, period, period.AddDate(0, 1, 0))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	records := [][]string{header}
+	for rows.Next() {
+		switch section {
+		case "b2cs":
+			var pos string
+			var rate, taxable float64
+			if err := rows.Scan(&pos, &rate, &taxable); err != nil {
+				api.InternalError(w, err)
+				return
+			}
+			records = append(records, []string{"OE", placeOfSupply(pos), fmt.Sprintf("%g", rate), "", fmt.Sprintf("%.2f", taxable), "0.00", ""})
+		case "hsn":
+			var hsn, description string
+			var qty int
+			var total, rate, taxable, cgst, sgst float64
+			if err := rows.Scan(&hsn, &description, &qty, &total, &rate, &taxable, &cgst, &sgst); err != nil {
+				api.InternalError(w, err)
+				return
+			}
+			records = append(records, []string{hsn, description, "NOS-NUMBERS", fmt.Sprintf("%d", qty), fmt.Sprintf("%.2f", total),
+				fmt.Sprintf("%g", rate), fmt.Sprintf("%.2f", taxable), "0.00", fmt.Sprintf("%.2f", cgst), fmt.Sprintf("%.2f", sgst), "0.00"})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("gstr1-%s-%s.csv", section, period.Format("2006-01"))))
+	cw := csv.NewWriter(w)
+	cw.WriteAll(records)
+}
@@ -0,0 +1,323 @@
+package invoices
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/billing"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+var (
+	ErrNoGSTIN          = errors.New("BUSINESS_GSTIN is not configured")
+	ErrNothingToInvoice = errors.New("nothing to invoice in this period")
+	ErrAlreadyInvoiced  = errors.New("period overlaps one already invoiced for this user")
+)
+
+var gstinPattern = regexp.MustCompile(`^[0-9]{2}[0-9A-Z]{13}$`)
+
+// FinancialYear names the April to March year d falls in, e.g. 2026-27.
+func FinancialYear(d time.Time) string {
+	y := d.Year()
+	if d.Month() < time.April {
+		y--
+	}
+	return fmt.Sprintf("%d-%02d", y, (y+1)%100)
+}
+
+// invoiceNo formats the n-th invoice of a financial year, e.g.
+// INV/26-27/00042. GST invoice numbers are limited to 16 characters.
+func invoiceNo(financialYear string, n int) (string, error) {
+	prefix := os.Getenv("INVOICE_PREFIX")
+	if prefix == "" {
+		prefix = "INV"
+	}
+	no := fmt.Sprintf("%s/%s/%05d", prefix, financialYear[2:], n)
+	if len(no) > 16 {
+		return "", fmt.Errorf("invoice number %s is longer than 16 characters, shorten INVOICE_PREFIX", no)
+	}
+	return no, nil
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// invoiceLines groups the items of a bill by item and tax rate.
+func invoiceLines(report model.BillReport) []model.InvoiceLine {
+	var lines []model.InvoiceLine
+	index := make(map[string]int)
+	for _, l := range report.Logs {
+		for _, item := range l.Items {
+			key := fmt.Sprintf("%s|%s|%.2f", item.ItemID, item.HSNSAC, item.TaxRate)
+			i, ok := index[key]
+			if !ok {
+				i = len(lines)
+				index[key] = i
+				lines = append(lines, model.InvoiceLine{LineNo: i + 1, ItemName: item.ItemName, HSNSAC: item.HSNSAC, TaxRate: item.TaxRate})
+			}
+			lines[i].Quantity += item.Quantity
+			lines[i].TaxableValue = round(lines[i].TaxableValue + item.TaxableValue)
+			lines[i].Total = round(lines[i].Total + item.Amount)
+		}
+	}
+	for i := range lines {
+		tax := round(lines[i].Total - lines[i].TaxableValue)
+		lines[i].CGST = round(tax / 2)
+		lines[i].SGST = round(tax - lines[i].CGST)
+	}
+	return lines
+}
+
+// CreateInvoice issues a tax invoice for a user's meals between startDate and
+// endDate. Numbers come from a per financial year series that is only
+// advanced when the invoice is committed, so the series has no gaps.
+func CreateInvoice(ctx context.Context, src audit.Source, userID int, startDate, endDate time.Time) (model.Invoice, error) {
+	var inv model.Invoice
+	business := billing.BusinessFromEnv()
+	if !gstinPattern.MatchString(business.GSTIN) {
+		return inv, ErrNoGSTIN
+	}
+
+	report, err := billing.BuildBill(ctx, database.GetDbConn(), userID, startDate, endDate)
+	if err != nil {
+		return inv, err
+	}
+	inv.Lines = invoiceLines(report)
+	if len(inv.Lines) == 0 {
+		return inv, ErrNothingToInvoice
+	}
+
+	inv.User = report.User
+	inv.PeriodStart = startDate
+	inv.PeriodEnd = endDate
+	inv.InvoiceDate = database.BusinessDate(time.Now())
+	inv.FinancialYear = FinancialYear(inv.InvoiceDate)
+	inv.GSTIN = business.GSTIN
+	// Meals are supplied where the business is registered
+	inv.PlaceOfSupply = business.GSTIN[:2]
+	for _, l := range inv.Lines {
+		inv.TaxableValue = round(inv.TaxableValue + l.TaxableValue)
+		inv.CGST = round(inv.CGST + l.CGST)
+		inv.SGST = round(inv.SGST + l.SGST)
+		inv.Total = round(inv.Total + l.Total)
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback(ctx)
+
+	// The series row stays locked until commit, so concurrent invoices
+	// take consecutive numbers and a rollback releases the number
+	var n int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO INVOICE_SERIES (FINANCIAL_YEAR, LAST_NO) VALUES ($1, 1)
+		ON CONFLICT (FINANCIAL_YEAR) DO UPDATE SET LAST_NO = INVOICE_SERIES.LAST_NO + 1
+		RETURNING LAST_NO
+	`, inv.FinancialYear).Scan(&n)
+	if err != nil {
+		return inv, err
+	}
+
+	// Checked under the series lock so two overlapping invoices can't both
+	// pass. Meals are invoiced once, so no earlier invoice may cover any day
+	// of this one.
+	var exists bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM INVOICES WHERE USER_ID = $1 AND PERIOD_START <= $3 AND PERIOD_END >= $2)
+	`, userID, startDate, endDate).Scan(&exists)
+	if err != nil {
+		return inv, err
+	}
+	if exists {
+		return inv, ErrAlreadyInvoiced
+	}
+	inv.InvoiceNo, err = invoiceNo(inv.FinancialYear, n)
+	if err != nil {
+		return inv, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO INVOICES (INVOICE_NO, FINANCIAL_YEAR, INVOICE_DATE, USER_ID, PERIOD_START, PERIOD_END, GSTIN, PLACE_OF_SUPPLY, TAXABLE_VALUE, CGST, SGST, TOTAL)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		RETURNING INVOICE_ID, CREATED_AT
+	`, inv.InvoiceNo, inv.FinancialYear, inv.InvoiceDate, userID, startDate, endDate, inv.GSTIN, inv.PlaceOfSupply,
+		inv.TaxableValue, inv.CGST, inv.SGST, inv.Total).Scan(&inv.InvoiceID, &inv.CreatedAt)
+	if database.IsUniqueViolation(err) {
+		return inv, ErrAlreadyInvoiced
+	}
+	if err != nil {
+		return inv, err
+	}
+	for _, l := range inv.Lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO INVOICE_LINES (INVOICE_ID, LINE_NO, ITEM_NAME, HSN_SAC, QUANTITY, TAX_RATE, TAXABLE_VALUE, CGST, SGST, TOTAL)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, inv.InvoiceID, l.LineNo, l.ItemName, l.HSNSAC, l.Quantity, l.TaxRate, l.TaxableValue, l.CGST, l.SGST, l.Total)
+		if err != nil {
+			return inv, err
+		}
+	}
+
+	after, err := audit.Snapshot(ctx, tx, "INVOICES", "INVOICE_ID", inv.InvoiceID)
+	if err != nil {
+		return inv, err
+	}
+	err = audit.Record(ctx, tx, src, audit.Change{
+		Action: "invoice.issue", EntityType: "invoice", EntityID: strconv.Itoa(inv.InvoiceID), After: after,
+	})
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit(ctx)
+}
+
+func IssueInvoice(w http.ResponseWriter, r *http.Request) {
+	var req model.InvoiceRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	v.Check(req.UserID > 0, "user_id", "is required")
+	startDate := v.ParseDate("start_date", req.StartDate)
+	endDate := v.ParseDate("end_date", req.EndDate)
+	v.DateRange("start_date", startDate, "end_date", endDate)
+	if v.Failed(w) {
+		return
+	}
+
+	inv, err := CreateInvoice(r.Context(), audit.FromRequest(r), req.UserID, startDate, endDate)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		api.NotFound(w, "User not found")
+		return
+	case errors.Is(err, ErrNothingToInvoice), errors.Is(err, ErrAlreadyInvoiced), errors.Is(err, ErrNoGSTIN):
+		api.Conflict(w, err.Error())
+		return
+	case err != nil:
+		api.InternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(inv)
+}
+
+const invoiceColumns = `
+	i.INVOICE_ID, i.INVOICE_NO, i.FINANCIAL_YEAR, i.INVOICE_DATE, i.PERIOD_START, i.PERIOD_END, i.GSTIN, i.PLACE_OF_SUPPLY,
+	i.TAXABLE_VALUE, i.CGST, i.SGST, i.TOTAL, i.CREATED_AT,
+	u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO
+`
+
+func scanInvoice(row pgx.Row, inv *model.Invoice) error {
+	return row.Scan(&inv.InvoiceID, &inv.InvoiceNo, &inv.FinancialYear, &inv.InvoiceDate, &inv.PeriodStart, &inv.PeriodEnd, &inv.GSTIN, &inv.PlaceOfSupply,
+		&inv.TaxableValue, &inv.CGST, &inv.SGST, &inv.Total, &inv.CreatedAt,
+		&inv.User.UserID, &inv.User.Name, &inv.User.MobileNo, &inv.User.BuildingNo, &inv.User.RoomNo)
+}
+
+// GetInvoices lists invoices, optionally for one user and/or the month of
+// ?period=YYYY-MM.
+func GetInvoices(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	userID := params.Int("user_id", false)
+	period := params.Month("period", false)
+	if params.Failed(w) {
+		return
+	}
+
+	query := `SELECT ` + invoiceColumns + ` FROM INVOICES i JOIN USERS u ON u.USER_ID = i.USER_ID WHERE TRUE`
+	var args []interface{}
+	if userID != 0 {
+		args = append(args, userID)
+		query += fmt.Sprintf(" AND i.USER_ID = $%d", len(args))
+	}
+	if !period.IsZero() {
+		args = append(args, period, period.AddDate(0, 1, 0))
+		query += fmt.Sprintf(" AND i.INVOICE_DATE >= $%d AND i.INVOICE_DATE < $%d", len(args)-1, len(args))
+	}
+	query += " ORDER BY i.INVOICE_ID DESC"
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), query, args...)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	invoices := []model.Invoice{}
+	for rows.Next() {
+		var inv model.Invoice
+		if err := scanInvoice(rows, &inv); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		invoices = append(invoices, inv)
+	}
+
+	json.NewEncoder(w).Encode(invoices)
+}
+
+// GetInvoice returns one invoice with its lines, as JSON or ?format=pdf.
+func GetInvoice(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	id := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	var inv model.Invoice
+	err := scanInvoice(dbPool.QueryRow(r.Context(), `SELECT `+invoiceColumns+` FROM INVOICES i JOIN USERS u ON u.USER_ID = i.USER_ID WHERE i.INVOICE_ID = $1`, id), &inv)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Invoice not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT LINE_NO, ITEM_NAME, HSN_SAC, QUANTITY, TAX_RATE, TAXABLE_VALUE, CGST, SGST, TOTAL
+		FROM INVOICE_LINES WHERE INVOICE_ID = $1 ORDER BY LINE_NO
+	`, id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l model.InvoiceLine
+		if err := rows.Scan(&l.LineNo, &l.ItemName, &l.HSNSAC, &l.Quantity, &l.TaxRate, &l.TaxableValue, &l.CGST, &l.SGST, &l.Total); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+
+	if r.URL.Query().Get("format") == "pdf" {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", fileName(inv.InvoiceNo)+".pdf"))
+		w.Write(RenderPDF(billing.BusinessFromEnv(), inv))
+		return
+	}
+	json.NewEncoder(w).Encode(inv)
+}
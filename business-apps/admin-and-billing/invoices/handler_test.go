@@ -0,0 +1,64 @@
+package invoices
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFinancialYear(t *testing.T) {
+	tests := []struct {
+		date string
+		want string
+	}{
+		{"2026-04-01", "2026-27"},
+		{"2026-12-31", "2026-27"},
+		{"2027-01-01", "2026-27"},
+		{"2027-03-31", "2026-27"},
+		{"2099-04-01", "2099-00"},
+		{"2100-03-31", "2099-00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.date, func(t *testing.T) {
+			d, err := time.Parse("2006-01-02", tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := FinancialYear(d); got != tt.want {
+				t.Errorf("FinancialYear(%s) = %q, want %q", tt.date, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvoiceNo(t *testing.T) {
+	tests := []struct {
+		name    string
+		prefix  string
+		n       int
+		want    string
+		wantErr bool
+	}{
+		{"default prefix", "", 42, "INV/26-27/00042", false},
+		{"first of the year", "", 1, "INV/26-27/00001", false},
+		{"past five digits", "", 123456, "INV/26-27/123456", false},
+		{"custom prefix", "TF", 7, "TF/26-27/00007", false},
+		{"longest prefix", "ABCD", 7, "ABCD/26-27/00007", false},
+		{"prefix too long", "ABCDE", 7, "", true},
+		{"number too long", "ABCD", 123456, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("INVOICE_PREFIX", tt.prefix)
+			got, err := invoiceNo("2026-27", tt.n)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("invoiceNo(%d) = %q, want an error", tt.n, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("invoiceNo(%d) = %q, %v, want %q", tt.n, got, err, tt.want)
+			}
+		})
+	}
+}
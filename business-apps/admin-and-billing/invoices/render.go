@@ -0,0 +1,96 @@
+package invoices
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/soumalya/food-delivery-admin/billing"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/pdf"
+)
+
+// fileName makes an invoice number safe to use as a file name.
+func fileName(invoiceNo string) string {
+	return "invoice-" + strings.ReplaceAll(invoiceNo, "/", "-")
+}
+
+func money(v float64) string {
+	return fmt.Sprintf("%.2f", v)
+}
+
+// RenderPDF lays out a tax invoice on A4 pages.
+func RenderPDF(b billing.Business, inv model.Invoice) []byte {
+	const left, right, bottom = 40.0, pdf.PageWidth - 40, 60.0
+	doc := pdf.New()
+	y := pdf.PageHeight - 50
+
+	doc.Text(left, y, 18, true, b.Name)
+	doc.TextRight(right, y, 14, true, "TAX INVOICE")
+	y -= 16
+	for _, line := range []string{b.Address, b.Phone, "GSTIN: " + inv.GSTIN} {
+		if line != "" {
+			doc.Text(left, y, 9, false, line)
+			y -= 12
+		}
+	}
+
+	y -= 10
+	doc.Text(left, y, 11, true, "Bill to")
+	doc.TextRight(right, y, 10, true, "Invoice No: "+inv.InvoiceNo)
+	y -= 14
+	doc.Text(left, y, 10, false, inv.User.Name)
+	doc.TextRight(right, y, 9, false, "Date: "+inv.InvoiceDate.Format("02 Jan 2006"))
+	y -= 12
+	doc.Text(left, y, 9, false, fmt.Sprintf("Building %s, Room %s", inv.User.BuildingNo, inv.User.RoomNo))
+	doc.TextRight(right, y, 9, false, "Period: "+inv.PeriodStart.Format("02 Jan")+" - "+inv.PeriodEnd.Format("02 Jan 2006"))
+	y -= 12
+	doc.Text(left, y, 9, false, inv.User.MobileNo)
+	doc.TextRight(right, y, 9, false, "Place of supply: "+placeOfSupply(inv.PlaceOfSupply))
+	y -= 24
+
+	// Item and HSN/SAC are left aligned, the numeric columns end at these x
+	numeric := []float64{right - 300, right - 230, right - 180, right - 120, right - 60, right - 4}
+	header := func() {
+		doc.Rect(left, y-5, right-left, 18, 0.92)
+		doc.Text(left+4, y, 9, true, "Item")
+		doc.Text(left+170, y, 9, true, "HSN/SAC")
+		for i, h := range []string{"Qty", "Taxable", "Rate", "CGST", "SGST", "Total"} {
+			doc.TextRight(numeric[i], y, 9, true, h)
+		}
+		y -= 20
+	}
+	header()
+	for _, l := range inv.Lines {
+		if y < bottom {
+			doc.AddPage()
+			y = pdf.PageHeight - 50
+			header()
+		}
+		doc.Text(left+4, y, 9, false, l.ItemName)
+		doc.Text(left+170, y, 9, false, l.HSNSAC)
+		for i, v := range []string{fmt.Sprintf("%d", l.Quantity), money(l.TaxableValue), fmt.Sprintf("%g%%", l.TaxRate), money(l.CGST), money(l.SGST), money(l.Total)} {
+			doc.TextRight(numeric[i], y, 9, false, v)
+		}
+		doc.Line(left, y-5, right, y-5, 0.3)
+		y -= 16
+	}
+
+	if y-80 < bottom {
+		doc.AddPage()
+		y = pdf.PageHeight - 50
+	}
+	y -= 10
+	for _, row := range [][2]string{
+		{"Taxable value", money(inv.TaxableValue)},
+		{"CGST", money(inv.CGST)},
+		{"SGST", money(inv.SGST)},
+		{"Invoice total", money(inv.Total)},
+	} {
+		bold := row[0] == "Invoice total"
+		doc.Text(right-200, y, 10, bold, row[0])
+		doc.TextRight(right-4, y, 10, bold, row[1])
+		y -= 15
+	}
+
+	return doc.Bytes()
+}
@@ -0,0 +1,196 @@
+package journal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/users"
+	"github.com/soumalya/food-delivery-admin/wallet"
+)
+
+// GetDeletedEntries lists deleted entries logged between ?start_date and
+// ?end_date, newest deletion first, optionally for one ?user_id.
+func GetDeletedEntries(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	startDate := params.Date("start_date", true)
+	endDate := params.Date("end_date", true)
+	userID := params.Int("user_id", false)
+	params.DateRange("start_date", startDate, "end_date", endDate)
+	if params.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT l.LOG_ID, l.USER_ID, u.NAME, l.LOG_DATE, l.MEAL_TYPE,
+		       l.HAS_MAIN_MEAL, l.IS_SPECIAL, COALESCE(l.SPECIAL_DISH_NAME, ''),
+		       l.EXTRA_RICE_QTY, l.EXTRA_ROTI_QTY, l.EXTRA_CHICKEN_QTY, l.EXTRA_FISH_QTY, l.EXTRA_EGG_QTY, l.EXTRA_VEGETABLE_QTY, l.TOTAL_COST,
+		       l.DELETED_AT, COALESCE(l.DELETED_BY, ''), COALESCE(l.DELETE_REASON, '')
+		FROM DAILY_LOGS l
+		JOIN USERS u ON l.USER_ID = u.USER_ID
+		WHERE l.DELETED_AT IS NOT NULL AND l.LOG_DATE BETWEEN $1 AND $2 AND ($3 = 0 OR l.USER_ID = $3)
+		ORDER BY l.DELETED_AT DESC
+	`, startDate, endDate, userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	logs := []model.DailyLog{}
+	for rows.Next() {
+		var l model.DailyLog
+		err := rows.Scan(&l.LogID, &l.UserID, &l.UserName, &l.LogDate, &l.MealType, &l.HasMainMeal, &l.IsSpecial, &l.SpecialDishName,
+			&l.ExtraRiceQty, &l.ExtraRotiQty, &l.ExtraChickenQty, &l.ExtraFishQty, &l.ExtraEggQty, &l.ExtraVegetableQty, &l.TotalCost,
+			&l.DeletedAt, &l.DeletedBy, &l.DeleteReason)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		logs = append(logs, l)
+	}
+	if err := rows.Err(); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(logs)
+}
+
+// RestoreDailyEntry brings back a deleted entry and debits its original cost
+// from the wallet again. ?second_portion=true restores it even if the
+// customer has since been given another entry for the same shift.
+func RestoreDailyEntry(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	logID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+	secondPortion := r.URL.Query().Get("second_portion") == "true"
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var userID int
+	var totalCost float64
+	var logDate time.Time
+	var mealType string
+	err = tx.QueryRow(r.Context(), `
+		SELECT USER_ID, TOTAL_COST, LOG_DATE, MEAL_TYPE FROM DAILY_LOGS
+		WHERE LOG_ID = $1 AND DELETED_AT IS NOT NULL
+		FOR UPDATE
+	`, logID).Scan(&userID, &totalCost, &logDate, &mealType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Deleted entry not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	var v api.Validator
+	if err := users.CheckActive(r.Context(), &v, "user_id", userID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	locked, err := statements.PeriodLocked(r.Context(), tx, logDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	standing, err := wallet.CheckDebit(r.Context(), tx, userID, totalCost)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if standing == wallet.StandingBlock {
+		api.InsufficientBalance(w, "Insufficient balance: entry exceeds the customer's credit limit")
+		return
+	}
+
+	if onePerShift() && !secondPortion {
+		var exists bool
+		err = tx.QueryRow(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM DAILY_LOGS WHERE USER_ID = $1 AND LOG_DATE = $2 AND MEAL_TYPE = $3 AND DELETED_AT IS NULL)
+		`, userID, logDate, mealType).Scan(&exists)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if exists {
+			api.WriteError(w, http.StatusConflict, api.CodeDuplicateEntry,
+				"Customer already has an entry for this shift; pass second_portion=true to restore anyway")
+			return
+		}
+	}
+
+	before, err := audit.Snapshot(r.Context(), tx, "DAILY_LOGS", "LOG_ID", logID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `
+		UPDATE DAILY_LOGS SET DELETED_AT = NULL, DELETED_BY = NULL, DELETE_REASON = NULL WHERE LOG_ID = $1
+	`, logID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "journal.restore", logID, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	var newBalance float64
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET BALANCE = BALANCE - $1 WHERE USER_ID = $2 RETURNING BALANCE
+	`, totalCost, userID).Scan(&newBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, VALUE_DATE) 
+		VALUES ($1, 'delivery', 'confirmed', $2, $3, $4)
+	`, userID, totalCost, newBalance, logDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	resp := map[string]interface{}{"new_balance": newBalance}
+	if standing == wallet.StandingWarn {
+		resp["warning"] = "Balance is below the customer's credit limit"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,51 +1,106 @@
 package journal
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/meals"
 	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/users"
+	"github.com/soumalya/food-delivery-admin/wallet"
 )
 
 func CreateDailyEntry(w http.ResponseWriter, r *http.Request) {
 	var log model.EntryRequest
-	if err := json.NewDecoder(r.Body).Decode(&log); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &log) {
 		return
 	}
-	// Get current meal prices
-	prices := meals.GetMealPricesInternal(r.Context())
-
-	// Calculate cost
-	mealPrice := 0.0
-	if log.HasMainMeal {
-		mealPrice = prices["standard"]
-		if log.IsSpecial {
-			mealPrice = prices["special"]
-		}
+	var v api.Validator
+	if err := users.CheckActive(r.Context(), &v, "user_id", log.UserID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	validateLogDate(&v, log.LogDate)
+	validateEntry(&v, log)
+	if v.Rejected(w) {
+		return
 	}
-	totalCost := mealPrice + (float64(log.ExtraRiceQty) * prices["rice"]) + (float64(log.ExtraRotiQty) * prices["roti"]) + (float64(log.ExtraChickenQty) * prices["chicken"]) + (float64(log.ExtraFishQty) * prices["fish"]) + (float64(log.ExtraEggQty) * prices["egg"]) + (float64(log.ExtraVegetableQty) * prices["vegetable"])
+	// Price the entry at current meal prices
+	items := meals.ItemLines(log, meals.GetMealCatalogInternal(r.Context()))
+	totalCost := meals.LinesTotal(items)
 
 	dbPool := database.GetDbConn()
 	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer tx.Rollback(r.Context())
 
+	locked, err := statements.PeriodLocked(r.Context(), tx, log.LogDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	// Apply the wallet's negative-balance policy
+	standing, err := wallet.CheckDebit(r.Context(), tx, log.UserID, totalCost)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if standing == wallet.StandingBlock {
+		api.InsufficientBalance(w, "Insufficient balance: entry exceeds the customer's credit limit")
+		return
+	}
+
+	// The wallet row lock above serialises this check per customer
+	if onePerShift() && !log.SecondPortion {
+		var exists bool
+		err = tx.QueryRow(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM DAILY_LOGS WHERE USER_ID = $1 AND LOG_DATE = $2 AND MEAL_TYPE = $3 AND DELETED_AT IS NULL)
+		`, log.UserID, log.LogDate, log.MealType).Scan(&exists)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if exists {
+			api.WriteError(w, http.StatusConflict, api.CodeDuplicateEntry,
+				"Customer already has an entry for this shift; send second_portion to record another")
+			return
+		}
+	}
+
 	// Insert Log
-	_, err = tx.Exec(r.Context(), `
+	var logID int
+	err = tx.QueryRow(r.Context(), `
 		INSERT INTO DAILY_LOGS (USER_ID, LOG_DATE, MEAL_TYPE, HAS_MAIN_MEAL, IS_SPECIAL, SPECIAL_DISH_NAME, EXTRA_RICE_QTY, EXTRA_ROTI_QTY, EXTRA_CHICKEN_QTY, EXTRA_FISH_QTY, EXTRA_EGG_QTY, EXTRA_VEGETABLE_QTY, TOTAL_COST) 
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9	, $10, $11, $12, $13)
-	`, log.UserID, log.LogDate, log.MealType, log.HasMainMeal, log.IsSpecial, log.SpecialDishName, log.ExtraRiceQty, log.ExtraRotiQty, log.ExtraChickenQty, log.ExtraFishQty, log.ExtraEggQty, log.ExtraVegetableQty, totalCost)
+		RETURNING LOG_ID
+	`, log.UserID, log.LogDate, log.MealType, log.HasMainMeal, log.IsSpecial, log.SpecialDishName, log.ExtraRiceQty, log.ExtraRotiQty, log.ExtraChickenQty, log.ExtraFishQty, log.ExtraEggQty, log.ExtraVegetableQty, totalCost).Scan(&logID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := saveItems(r.Context(), tx, logID, items); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "journal.create", logID, nil); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
@@ -55,37 +110,55 @@ func CreateDailyEntry(w http.ResponseWriter, r *http.Request) {
 		UPDATE WALLET SET BALANCE = BALANCE - $1 WHERE USER_ID = $2 RETURNING BALANCE
 	`, totalCost, log.UserID).Scan(&newBalance)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
-	yyyy, MM, dd := log.LogDate.Date()
-	utc_time := time.Now().UTC()
-	crt_dt := strconv.Itoa(yyyy) + "-" + strconv.Itoa(int(MM)) + "-" + strconv.Itoa(dd) + " " + strconv.Itoa(utc_time.Hour()) + ":" + strconv.Itoa(utc_time.Minute()) + ":" + strconv.Itoa(utc_time.Second()) + "." + strconv.Itoa(utc_time.Nanosecond()) + "+" + "00"
-
+	// The debit counts towards the day the meal was logged for
 	_, err = tx.Exec(r.Context(), `
-		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, CREATED_AT) 
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, VALUE_DATE) 
 		VALUES ($1, 'delivery', 'confirmed', $2, $3, $4)
-	`, log.UserID, totalCost, newBalance, crt_dt)
+	`, log.UserID, totalCost, newBalance, log.LogDate)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
-	tx.Commit(r.Context())
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	resp := map[string]interface{}{"new_balance": newBalance}
+	if standing == wallet.StandingWarn {
+		resp["warning"] = "Balance is below the customer's credit limit"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(map[string]interface{}{"new_balance": newBalance})
+	json.NewEncoder(w).Encode(resp)
 }
 
 func DeleteDailyEntry(w http.ResponseWriter, r *http.Request) {
-	logIDStr := chi.URLParam(r, "id")
-	logID, _ := strconv.Atoi(logIDStr)
+	params := api.Path(r)
+	logID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var req model.DeleteEntryRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	v.Check(strings.TrimSpace(req.DeletedBy) != "", "deleted_by", "is required")
+	v.Check(strings.TrimSpace(req.Reason) != "", "reason", "is required")
+	if v.Failed(w) {
+		return
+	}
 
 	dbPool := database.GetDbConn()
 	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer tx.Rollback(r.Context())
@@ -93,16 +166,47 @@ func DeleteDailyEntry(w http.ResponseWriter, r *http.Request) {
 	// Get info to refund
 	var userID int
 	var totalCost float64
-	err = tx.QueryRow(r.Context(), `SELECT USER_ID, TOTAL_COST FROM DAILY_LOGS WHERE LOG_ID = $1`, logID).Scan(&userID, &totalCost)
+	var logDate time.Time
+	err = tx.QueryRow(r.Context(), `SELECT USER_ID, TOTAL_COST, LOG_DATE FROM DAILY_LOGS WHERE LOG_ID = $1 AND DELETED_AT IS NULL FOR UPDATE`, logID).Scan(&userID, &totalCost, &logDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Entry not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "Entry not found", http.StatusNotFound)
+		api.InternalError(w, err)
+		return
+	}
+	// A refund would reopen a settled or merged account
+	if err := users.CheckActive(r.Context(), &v, "user_id", userID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	locked, err := statements.PeriodLocked(r.Context(), tx, logDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
 		return
 	}
 
-	// Delete
-	_, err = tx.Exec(r.Context(), `DELETE FROM DAILY_LOGS WHERE LOG_ID = $1`, logID)
+	before, err := audit.Snapshot(r.Context(), tx, "DAILY_LOGS", "LOG_ID", logID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+
+	// Keep the entry so it can be restored
+	_, err = tx.Exec(r.Context(), `
+		UPDATE DAILY_LOGS SET DELETED_AT = NOW(), DELETED_BY = $2, DELETE_REASON = $3 WHERE LOG_ID = $1
+	`, logID, req.DeletedBy, req.Reason)
+	if err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
@@ -112,53 +216,59 @@ func DeleteDailyEntry(w http.ResponseWriter, r *http.Request) {
 		UPDATE WALLET SET BALANCE = BALANCE + $1 WHERE USER_ID = $2 RETURNING BALANCE
 	`, totalCost, userID).Scan(&newBalance)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
 	// Log Transaction
 	_, err = tx.Exec(r.Context(), `
-		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER) 
-		VALUES ($1, 'refund', 'confirmed', $2, $3)
-	`, userID, totalCost, newBalance)
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, VALUE_DATE) 
+		VALUES ($1, 'refund', 'confirmed', $2, $3, $4)
+	`, userID, totalCost, newBalance, logDate)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+
+	// Audit last, after any wallet lock; see audit.Record
+	if err := recordChange(r, tx, "journal.delete", logID, before); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
-	tx.Commit(r.Context())
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"new_balance": newBalance})
 }
 
 func UpdateDailyEntry(w http.ResponseWriter, r *http.Request) {
-	logIDStr := chi.URLParam(r, "id")
-	logID, _ := strconv.Atoi(logIDStr)
+	params := api.Path(r)
+	logID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
 
 	var req model.EntryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &req) {
 		return
 	}
-	// Get current meal prices
-	prices := meals.GetMealPricesInternal(r.Context())
-
-	// Calculate new cost
-	mealPrice := 0.0
-	if req.HasMainMeal {
-		mealPrice = prices["standard"]
-		if req.IsSpecial {
-			mealPrice = prices["special"]
-		}
+	var v api.Validator
+	validateEntry(&v, req)
+	if v.Rejected(w) {
+		return
 	}
-	// Note: We only calculate basic extras as supported in the Edit feature
-	newTotalCost := mealPrice + (float64(req.ExtraRiceQty) * prices["rice"]) + (float64(req.ExtraRotiQty) * prices["roti"]) + (float64(req.ExtraChickenQty) * prices["chicken"]) + (float64(req.ExtraFishQty) * prices["fish"]) + (float64(req.ExtraEggQty) * prices["egg"]) + (float64(req.ExtraVegetableQty) * prices["vegetable"])
+	// Re-price the entry at current meal prices
+	items := meals.ItemLines(req, meals.GetMealCatalogInternal(r.Context()))
+	newTotalCost := meals.LinesTotal(items)
 
 	dbPool := database.GetDbConn()
 	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer tx.Rollback(r.Context())
@@ -166,25 +276,92 @@ func UpdateDailyEntry(w http.ResponseWriter, r *http.Request) {
 	// Get old info
 	var userID int
 	var oldTotalCost float64
-	err = tx.QueryRow(r.Context(), `SELECT USER_ID, TOTAL_COST FROM DAILY_LOGS WHERE LOG_ID = $1`, logID).Scan(&userID, &oldTotalCost)
+	var logDate time.Time
+	var oldMealType string
+	err = tx.QueryRow(r.Context(), `SELECT USER_ID, TOTAL_COST, LOG_DATE, MEAL_TYPE FROM DAILY_LOGS WHERE LOG_ID = $1 AND DELETED_AT IS NULL FOR UPDATE`, logID).Scan(&userID, &oldTotalCost, &logDate, &oldMealType)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Entry not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := users.CheckActive(r.Context(), &v, "user_id", userID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	locked, err := statements.PeriodLocked(r.Context(), tx, logDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	// Apply the wallet's negative-balance policy to any increase
+	costDiff := newTotalCost - oldTotalCost
+	standing, err := wallet.CheckDebit(r.Context(), tx, userID, costDiff)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if costDiff <= 0 {
+		standing = wallet.StandingOK
+	}
+	if standing == wallet.StandingBlock {
+		api.InsufficientBalance(w, "Insufficient balance: entry exceeds the customer's credit limit")
+		return
+	}
+
+	// Moving the entry to another shift must not double it up. The wallet
+	// row lock above serialises this check per customer.
+	if req.MealType != oldMealType && onePerShift() && !req.SecondPortion {
+		var exists bool
+		err = tx.QueryRow(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM DAILY_LOGS WHERE USER_ID = $1 AND LOG_DATE = $2 AND MEAL_TYPE = $3 AND LOG_ID <> $4 AND DELETED_AT IS NULL)
+		`, userID, logDate, req.MealType, logID).Scan(&exists)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if exists {
+			api.WriteError(w, http.StatusConflict, api.CodeDuplicateEntry,
+				"Customer already has an entry for this shift; send second_portion to record another")
+			return
+		}
+	}
+
+	before, err := audit.Snapshot(r.Context(), tx, "DAILY_LOGS", "LOG_ID", logID)
 	if err != nil {
-		http.Error(w, "Entry not found", http.StatusNotFound)
+		api.InternalError(w, err)
 		return
 	}
 
 	// Update Log
 	_, err = tx.Exec(r.Context(), `
 		UPDATE DAILY_LOGS 
-		SET MEAL_TYPE = $1, HAS_MAIN_MEAL = $2, IS_SPECIAL = $3, SPECIAL_DISH_NAME = $4, EXTRA_RICE_QTY = $5, EXTRA_ROTI_QTY = $6, TOTAL_COST = $7
-		WHERE LOG_ID = $8
-	`, req.MealType, req.HasMainMeal, req.IsSpecial, req.SpecialDishName, req.ExtraRiceQty, req.ExtraRotiQty, newTotalCost, logID)
+		SET MEAL_TYPE = $1, HAS_MAIN_MEAL = $2, IS_SPECIAL = $3, SPECIAL_DISH_NAME = $4, EXTRA_RICE_QTY = $5, EXTRA_ROTI_QTY = $6,
+			EXTRA_CHICKEN_QTY = $7, EXTRA_FISH_QTY = $8, EXTRA_EGG_QTY = $9, EXTRA_VEGETABLE_QTY = $10, TOTAL_COST = $11
+		WHERE LOG_ID = $12
+	`, req.MealType, req.HasMainMeal, req.IsSpecial, req.SpecialDishName, req.ExtraRiceQty, req.ExtraRotiQty,
+		req.ExtraChickenQty, req.ExtraFishQty, req.ExtraEggQty, req.ExtraVegetableQty, newTotalCost, logID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := saveItems(r.Context(), tx, logID, items); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
 	// Adjust Wallet
-	costDiff := newTotalCost - oldTotalCost
 	var newBalance float64
 	if costDiff != 0 {
 		// If diff is positive (cost increased), we subtract more from balance.
@@ -193,7 +370,7 @@ func UpdateDailyEntry(w http.ResponseWriter, r *http.Request) {
 			UPDATE WALLET SET BALANCE = BALANCE - $1 WHERE USER_ID = $2 RETURNING BALANCE
 		`, costDiff, userID).Scan(&newBalance)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 
@@ -206,15 +383,21 @@ func UpdateDailyEntry(w http.ResponseWriter, r *http.Request) {
 		}
 
 		_, err = tx.Exec(r.Context(), `
-			INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER) 
-			VALUES ($1, $2, 'confirmed', $3, $4)
-		`, userID, txnType, txnAmount, newBalance)
+			INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, VALUE_DATE) 
+			VALUES ($1, $2, 'confirmed', $3, $4, $5)
+		`, userID, txnType, txnAmount, newBalance, logDate)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 	}
 
+	// Audit last, after any wallet lock; see audit.Record
+	if err := recordChange(r, tx, "journal.update", logID, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
 	// If cost didn't change, we still need to return the old balance (which didn't change)
 	// We can query the balance or just use the oldBalance value, but oldBalance isn't fetched, oldTotalCost is.
 	// Oh wait, if costDiff == 0, newBalance isn't calculated above! 
@@ -225,29 +408,33 @@ func UpdateDailyEntry(w http.ResponseWriter, r *http.Request) {
 	} else {
 		err = tx.QueryRow(r.Context(), `SELECT BALANCE FROM WALLET WHERE USER_ID = $1`, userID).Scan(&finalBalance)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 	}
 
-	tx.Commit(r.Context())
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	resp := map[string]interface{}{"new_balance": finalBalance}
+	if standing == wallet.StandingWarn {
+		resp["warning"] = "Balance is below the customer's credit limit"
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{"new_balance": finalBalance})
+	json.NewEncoder(w).Encode(resp)
 }
 
 func GetDailyEntries(w http.ResponseWriter, r *http.Request) {
-	dateStr := r.URL.Query().Get("date")
-	userIDStr := r.URL.Query().Get("user_id")
-
-	if dateStr == "" {
-		http.Error(w, "Date is required", http.StatusBadRequest)
-		return
+	params := api.Query(r)
+	date := params.Date("date", true)
+	// The UI sends user_id=0 for all users
+	userID := 0
+	if r.URL.Query().Get("user_id") != "0" {
+		userID = params.Int("user_id", false)
 	}
-
-	date, err := time.Parse("2006-01-02", dateStr)
-	if err != nil {
-		http.Error(w, "Invalid date format", http.StatusBadRequest)
+	if params.Failed(w) {
 		return
 	}
 
@@ -257,12 +444,11 @@ func GetDailyEntries(w http.ResponseWriter, r *http.Request) {
 		       l.EXTRA_RICE_QTY, l.EXTRA_ROTI_QTY, l.EXTRA_CHICKEN_QTY, l.EXTRA_FISH_QTY, l.EXTRA_EGG_QTY, l.EXTRA_VEGETABLE_QTY, l.TOTAL_COST 
 		FROM DAILY_LOGS l
 		JOIN USERS u ON l.USER_ID = u.USER_ID
-		WHERE l.LOG_DATE = $1
+		WHERE l.LOG_DATE = $1 AND l.DELETED_AT IS NULL
 	`
 	args := []interface{}{date}
 
-	if userIDStr != "" && userIDStr != "0" {
-		userID, _ := strconv.Atoi(userIDStr)
+	if userID != 0 {
 		query += " AND l.USER_ID = $2"
 		args = append(args, userID)
 	}
@@ -272,7 +458,7 @@ func GetDailyEntries(w http.ResponseWriter, r *http.Request) {
 	dbPool := database.GetDbConn()
 	rows, err := dbPool.Query(r.Context(), query, args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer rows.Close()
@@ -282,7 +468,7 @@ func GetDailyEntries(w http.ResponseWriter, r *http.Request) {
 		var l model.DailyLog
 		err := rows.Scan(&l.LogID, &l.UserID, &l.UserName, &l.LogDate, &l.MealType, &l.HasMainMeal, &l.IsSpecial, &l.SpecialDishName, &l.ExtraRiceQty, &l.ExtraRotiQty, &l.ExtraChickenQty, &l.ExtraFishQty, &l.ExtraEggQty, &l.ExtraVegetableQty, &l.TotalCost)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 		logs = append(logs, l)
@@ -290,3 +476,26 @@ func GetDailyEntries(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(logs)
 }
+
+// saveItems replaces the priced items stored for a log entry.
+func saveItems(ctx context.Context, tx pgx.Tx, logID int, items []model.BillLine) error {
+	_, err := tx.Exec(ctx, `DELETE FROM DAILY_LOG_ITEMS WHERE LOG_ID = $1`, logID)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO DAILY_LOG_ITEMS (LOG_ID, ITEM_ID, ITEM_NAME, QUANTITY, UNIT_PRICE, AMOUNT, HSN_SAC, TAX_RATE, TAXABLE_VALUE, TAX_AMOUNT)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, logID, item.ItemID, item.ItemName, item.Quantity, item.UnitPrice, item.Amount, item.HSNSAC, item.TaxRate, item.TaxableValue, item.TaxAmount)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange audits a change to a log entry.
+func recordChange(r *http.Request, tx pgx.Tx, action string, logID int, before json.RawMessage) error {
+	return audit.RecordRow(r.Context(), tx, audit.FromRequest(r), action, "daily_log", "DAILY_LOGS", "LOG_ID", logID, before)
+}
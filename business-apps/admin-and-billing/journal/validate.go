@@ -0,0 +1,62 @@
+package journal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+const (
+	// Most of any one extra a single delivery can carry
+	maxExtraQty = 20
+	// How far ahead an entry may be logged
+	maxDaysAhead = 7
+)
+
+// onePerShift reports whether a customer is limited to one entry per date and
+// shift unless the entry is marked as a second portion. Set
+// ONE_ENTRY_PER_SHIFT=false to turn the rule off.
+func onePerShift() bool {
+	return os.Getenv("ONE_ENTRY_PER_SHIFT") != "false"
+}
+
+// validateLogDate records a violation unless date is set and not too far
+// ahead. Updates keep the entry's original date so only creation checks it.
+func validateLogDate(v *api.Validator, date time.Time) {
+	if date.IsZero() {
+		v.Check(false, "log_date", "is required")
+		return
+	}
+	latest := database.BusinessDate(time.Now()).AddDate(0, 0, maxDaysAhead)
+	v.Check(!date.After(latest), "log_date", fmt.Sprintf("cannot be more than %d days ahead", maxDaysAhead))
+}
+
+// validateEntry records every rule the meal itself breaks.
+func validateEntry(v *api.Validator, e model.EntryRequest) {
+	v.Check(e.MealType == "lunch" || e.MealType == "dinner", "meal_type", "must be lunch or dinner")
+
+	extras := []struct {
+		field string
+		qty   int
+	}{
+		{"extra_rice_qty", e.ExtraRiceQty},
+		{"extra_roti_qty", e.ExtraRotiQty},
+		{"extra_chicken_qty", e.ExtraChickenQty},
+		{"extra_fish_qty", e.ExtraFishQty},
+		{"extra_egg_qty", e.ExtraEggQty},
+		{"extra_vegetable_qty", e.ExtraVegetableQty},
+	}
+	for _, x := range extras {
+		v.Check(x.qty >= 0 && x.qty <= maxExtraQty, x.field, fmt.Sprintf("must be between 0 and %d", maxExtraQty))
+	}
+
+	if e.IsSpecial {
+		v.Check(e.HasMainMeal, "is_special", "needs has_main_meal")
+		v.Check(strings.TrimSpace(e.SpecialDishName) != "", "special_dish_name", "is required for a special meal")
+	}
+}
@@ -1,21 +1,32 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	// Bundled so BUSINESS_TZ resolves on hosts without zoneinfo
+	_ "time/tzdata"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/billing"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/expenses"
+	"github.com/soumalya/food-delivery-admin/forecast"
+	"github.com/soumalya/food-delivery-admin/idempotency"
+	"github.com/soumalya/food-delivery-admin/invoices"
 	"github.com/soumalya/food-delivery-admin/journal"
 	"github.com/soumalya/food-delivery-admin/meals"
+	"github.com/soumalya/food-delivery-admin/notify"
+	"github.com/soumalya/food-delivery-admin/portal"
+	"github.com/soumalya/food-delivery-admin/statements"
 	"github.com/soumalya/food-delivery-admin/stats"
 	"github.com/soumalya/food-delivery-admin/users"
 	"github.com/soumalya/food-delivery-admin/wallet"
@@ -26,30 +37,99 @@ func main() {
 	dbPool := database.InitDB()
 	defer dbPool.Close()
 
+	notify.Use(notify.NewFromEnv())
+	portal.UseSMS(notify.NewSMSFromEnv())
+	notify.StartLowBalanceJob(context.Background(), notify.LowBalanceConfigFromEnv())
+	statements.StartBillingCycleJob(context.Background())
+	forecast.StartForecastJob(context.Background())
+
 	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(cors.AllowAll().Handler)
 
 	r.Route("/api", func(r chi.Router) {
+		r.Use(api.Recoverer)
+		r.NotFound(api.NotFoundHandler)
+		r.MethodNotAllowed(api.MethodNotAllowedHandler)
+
 		r.Get("/users", users.GetUsers)
 		r.Post("/users", users.CreateUser)
-		r.Post("/wallet/recharge", wallet.RechargeWallet)
+		r.Get("/users/typeahead", users.Typeahead)
+		r.Post("/users/import", users.ImportUsers)
+		r.Get("/users/duplicates", users.GetDuplicates)
+		r.Put("/users/{id}", users.UpdateUser)
+		r.Put("/users/{id}/notifications", users.UpdateNotificationPrefs)
+		r.Post("/users/{id}/deactivate", users.DeactivateUser)
+		r.Post("/users/{id}/reactivate", users.ReactivateUser)
+		r.Post("/users/{id}/settle", wallet.SettleAccount)
+		r.Post("/users/{id}/merge", users.MergeUsers)
+		r.Post("/users/{id}/plan", users.ChangePlan)
+		r.Get("/users/{id}/plan-history", users.GetPlanHistory)
+		r.Get("/users/{id}/profile", users.GetProfile)
+		r.Post("/wallet/recharge", idempotency.Handle("wallet.recharge", wallet.RechargeWallet))
+		r.Post("/wallet/adjustments", wallet.CreateAdjustment)
+		r.Post("/wallet/transfer", wallet.TransferBalance)
+		r.Put("/wallet/{user_id}/policy", wallet.UpdatePolicy)
+		r.Get("/wallet/claims", wallet.GetClaims)
+		r.Post("/wallet/claims/{id}/confirm", wallet.ConfirmClaim)
+		r.Post("/wallet/claims/{id}/reject", wallet.RejectClaim)
 		r.Get("/daily-entry", journal.GetDailyEntries)
-		r.Post("/daily-entry", journal.CreateDailyEntry)
+		r.Post("/daily-entry", idempotency.Handle("journal.create", journal.CreateDailyEntry))
 		r.Put("/daily-entry/{id}", journal.UpdateDailyEntry)
 		r.Delete("/daily-entry/{id}", journal.DeleteDailyEntry)
+		r.Get("/daily-entry/deleted", journal.GetDeletedEntries)
+		r.Post("/daily-entry/{id}/restore", journal.RestoreDailyEntry)
 		r.Get("/reports/bill", billing.GetBill)
+		r.Get("/reports/dues", billing.GetDues)
+		r.Post("/reports/bills/bulk", billing.StartBulkBills)
+		r.Get("/reports/bills/bulk/{job_id}", billing.GetBulkBillJob)
+		r.Get("/reports/bills/bulk/{job_id}/download", billing.DownloadBulkBills)
+		r.Post("/invoices", invoices.IssueInvoice)
+		r.Get("/invoices", invoices.GetInvoices)
+		r.Get("/invoices/gstr1", invoices.ExportGSTR1)
+		r.Get("/invoices/{id}", invoices.GetInvoice)
+		r.Get("/statements", statements.GetStatements)
+		r.Post("/statements/run", statements.RunBillingCycle)
 		r.Get("/expenses", expenses.GetExpenses)
 		r.Post("/expenses", expenses.CreateExpense)
 		r.Put("/expenses/{id}", expenses.UpdateExpense)
 		r.Delete("/expenses/{id}", expenses.DeleteExpense)
 		r.Get("/dashboard/stats", stats.GetDashboardStats)
 		r.Get("/analytics", stats.GetAnalyticsStats)
+		r.Get("/analytics/profitability", stats.GetProfitability)
+		r.Get("/analytics/cohorts", stats.GetCohorts)
+		r.Get("/analytics/churn", stats.GetChurn)
+		r.Get("/forecast", forecast.GetForecast)
+		r.Get("/forecast/accuracy", forecast.GetAccuracy)
 		r.Post("/meals", meals.CreateMeal)
 		r.Get("/meals", meals.GetMeals)
 		r.Put("/meals/{id}", meals.UpdateMeal)
+		r.Put("/meals/{id}/tax", meals.UpdateMealTax)
+		r.Put("/meals/{id}/cost", meals.UpdateMealCost)
 		r.Delete("/meals/{id}", meals.DeleteMeal)
+		r.Get("/notifications", notify.GetNotifications)
+		r.Get("/audit", audit.GetAuditLog)
+		r.Get("/audit/verify", audit.VerifyAuditLog)
+		r.Post("/notifications/low-balance/run", notify.RunLowBalanceCheck)
+
+		// Customer self-service, each customer sees only their own account
+		r.Route("/customer", func(r chi.Router) {
+			r.Post("/otp", portal.RequestOTP)
+			r.Post("/login", portal.Login)
+			r.Group(func(r chi.Router) {
+				r.Use(portal.Authenticate)
+				r.Post("/logout", portal.Logout)
+				r.Get("/account", portal.GetAccount)
+				r.Get("/transactions", portal.GetTransactions)
+				r.Get("/bill", portal.GetBill)
+				r.Post("/recharge-claims", portal.SubmitRechargeClaim)
+				r.Get("/skips", portal.GetSkips)
+				r.Post("/skips", portal.SkipMeal)
+				r.Delete("/skips/{date}/{shift}", portal.CancelSkip)
+			})
+		})
 	})
 
 	// Serve static files
@@ -1,61 +1,58 @@
 package meals
 
 import (
-	"context"
 	"encoding/json"
-	"log"
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/model"
 )
 
-func GetMealPricesInternal(ctx context.Context) map[string]float64 {
-	prices := make(map[string]float64)
-	dbPool := database.GetDbConn()
-	rows, err := dbPool.Query(ctx, "SELECT ITEM_ID, PRICE FROM MEAL_PRICES")
-	if err != nil {
-		log.Printf("Failed to get meal prices: %v\n", err)
-		return prices
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var id string
-		var price float64
-		if err := rows.Scan(&id, &price); err == nil {
-			prices[id] = price
-		}
-	}
-	return prices
-}
-
 func CreateMeal(w http.ResponseWriter, r *http.Request) {
 	var m model.MealPrice
-	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &m) {
 		return
 	}
+
 	dbPool := database.GetDbConn()
-	err := dbPool.QueryRow(r.Context(), `
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	err = tx.QueryRow(r.Context(), `
 		INSERT INTO MEAL_PRICES (ITEM_NAME, ITEM_PRICE) 
 		VALUES ($1, $2) 
 		RETURNING ITEM_ID
 	`, m.ItemName, m.Price).Scan(&m.ItemID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "meal.create", m.ItemID, nil); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	json.NewEncoder(w).Encode(m)
 }
 
 func GetMeals(w http.ResponseWriter, r *http.Request) {
 	dbPool := database.GetDbConn()
-	rows, err := dbPool.Query(r.Context(), "SELECT ITEM_ID, ITEM_NAME, PRICE, UPDATED_AT FROM MEAL_PRICES ORDER BY PRICE DESC")
+	rows, err := dbPool.Query(r.Context(), "SELECT ITEM_ID, ITEM_NAME, PRICE, UPDATED_AT, HSN_SAC, TAX_RATE, TAX_INCLUSIVE, UNIT_COST FROM MEAL_PRICES ORDER BY PRICE DESC")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer rows.Close()
@@ -63,9 +60,9 @@ func GetMeals(w http.ResponseWriter, r *http.Request) {
 	var prices []model.MealPrice
 	for rows.Next() {
 		var p model.MealPrice
-		err := rows.Scan(&p.ItemID, &p.ItemName, &p.Price, &p.UpdatedAt)
+		err := rows.Scan(&p.ItemID, &p.ItemName, &p.Price, &p.UpdatedAt, &p.HSNSAC, &p.TaxRate, &p.TaxInclusive, &p.UnitCost)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 		prices = append(prices, p)
@@ -76,43 +73,204 @@ func GetMeals(w http.ResponseWriter, r *http.Request) {
 
 func UpdateMeal(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-
 	var p model.MealPrice
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &p) {
+		return
+	}
+	p.ItemName = strings.TrimSpace(p.ItemName)
+	var v api.Validator
+	v.Check(p.ItemName != "", "item_name", "is required")
+	v.Check(p.Price > 0, "price", "must be positive")
+	if v.Failed(w) {
 		return
 	}
 
 	dbPool := database.GetDbConn()
-	_, err := dbPool.Exec(r.Context(), `
-		UPDATE MEAL_PRICES SET PRICE = $1, UPDATED_AT = CURRENT_TIMESTAMP
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "MEAL_PRICES", "ITEM_ID", id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Meal not found")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE MEAL_PRICES SET ITEM_NAME = $1, PRICE = $2, UPDATED_AT = CURRENT_TIMESTAMP
+		WHERE ITEM_ID = $3
+	`, p.ItemName, p.Price, id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "meal.update_price", id, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateMealTax sets the HSN/SAC code, GST rate and whether the price
+// includes tax. New entries are charged with the new treatment.
+func UpdateMealTax(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var t model.MealTax
+	if !api.DecodeJSON(w, r, &t) {
+		return
+	}
+	var v api.Validator
+	v.Check(t.TaxRate >= 0 && t.TaxRate <= 28, "tax_rate", "must be between 0 and 28")
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "MEAL_PRICES", "ITEM_ID", id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Meal not found")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE MEAL_PRICES SET HSN_SAC = $1, TAX_RATE = $2, TAX_INCLUSIVE = $3, UPDATED_AT = CURRENT_TIMESTAMP
+		WHERE ITEM_ID = $4
+	`, t.HSNSAC, t.TaxRate, t.TaxInclusive, id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "meal.update_tax", id, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateMealCost sets the standard cost of one unit of an item, which
+// profitability reports use for its margin.
+func UpdateMealCost(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var c model.MealCost
+	if !api.DecodeJSON(w, r, &c) {
+		return
+	}
+	var v api.Validator
+	v.Check(c.UnitCost >= 0, "unit_cost", "must not be negative")
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "MEAL_PRICES", "ITEM_ID", id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Meal not found")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE MEAL_PRICES SET UNIT_COST = $1, UPDATED_AT = CURRENT_TIMESTAMP
 		WHERE ITEM_ID = $2
-	`, p.Price, id)
+	`, c.UnitCost, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "meal.update_cost", id, before); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// DeleteMeal removes an item from the menu. Who deleted it is taken from
+// the audit log rather than the request body.
 func DeleteMeal(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	var p model.MealPrice
-	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
 		return
 	}
-	dbPool := database.GetDbConn()
-	_, err := dbPool.Exec(r.Context(), `
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "MEAL_PRICES", "ITEM_ID", id)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "Meal not found")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
 		DELETE FROM MEAL_PRICES
 		WHERE ITEM_ID = $1
 	`, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "meal.delete", id, before); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// recordChange audits a change to a meal price.
+func recordChange(r *http.Request, tx pgx.Tx, action, id string, before json.RawMessage) error {
+	return audit.RecordRow(r.Context(), tx, audit.FromRequest(r), action, "meal", "MEAL_PRICES", "ITEM_ID", id, before)
+}
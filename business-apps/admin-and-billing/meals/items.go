@@ -0,0 +1,102 @@
+package meals
+
+import (
+	"context"
+	"log"
+	"math"
+
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// GetMealCatalogInternal returns the price list keyed by ITEM_ID.
+func GetMealCatalogInternal(ctx context.Context) map[string]model.MealPrice {
+	catalog := make(map[string]model.MealPrice)
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, "SELECT ITEM_ID, ITEM_NAME, PRICE, UPDATED_AT, HSN_SAC, TAX_RATE, TAX_INCLUSIVE, UNIT_COST FROM MEAL_PRICES")
+	if err != nil {
+		log.Printf("Failed to get meal prices: %v\n", err)
+		return catalog
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p model.MealPrice
+		if err := rows.Scan(&p.ItemID, &p.ItemName, &p.Price, &p.UpdatedAt, &p.HSNSAC, &p.TaxRate, &p.TaxInclusive, &p.UnitCost); err == nil {
+			catalog[p.ItemID] = p
+		}
+	}
+	return catalog
+}
+
+// ItemLines breaks an entry into one line per chargeable item, priced from
+// the catalog. The main meal is either the standard or the special meal.
+func ItemLines(e model.EntryRequest, catalog map[string]model.MealPrice) []model.BillLine {
+	main := "standard"
+	if e.IsSpecial {
+		main = "special"
+	}
+	quantities := []struct {
+		itemID string
+		qty    int
+	}{
+		{main, 0},
+		{"rice", e.ExtraRiceQty},
+		{"roti", e.ExtraRotiQty},
+		{"chicken", e.ExtraChickenQty},
+		{"fish", e.ExtraFishQty},
+		{"egg", e.ExtraEggQty},
+		{"vegetable", e.ExtraVegetableQty},
+	}
+	if e.HasMainMeal {
+		quantities[0].qty = 1
+	}
+
+	var lines []model.BillLine
+	for _, q := range quantities {
+		if q.qty <= 0 {
+			continue
+		}
+		item, ok := catalog[q.itemID]
+		if !ok {
+			item = model.MealPrice{ItemID: q.itemID, ItemName: q.itemID}
+		}
+		taxable, tax := SplitTax(float64(q.qty)*item.Price, item.MealTax)
+		lines = append(lines, model.BillLine{
+			ItemID:       item.ItemID,
+			ItemName:     item.ItemName,
+			Quantity:     q.qty,
+			UnitPrice:    item.Price,
+			Amount:       round(taxable + tax),
+			HSNSAC:       item.HSNSAC,
+			TaxRate:      item.TaxRate,
+			TaxableValue: taxable,
+			TaxAmount:    tax,
+		})
+	}
+	return lines
+}
+
+// SplitTax returns the taxable value and GST of an amount charged at the
+// item's price.
+func SplitTax(amount float64, t model.MealTax) (taxable, tax float64) {
+	if t.TaxInclusive {
+		taxable = round(amount / (1 + t.TaxRate/100))
+		return taxable, round(amount - taxable)
+	}
+	taxable = round(amount)
+	return taxable, round(taxable * t.TaxRate / 100)
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// LinesTotal is the cost of an entry.
+func LinesTotal(lines []model.BillLine) float64 {
+	total := 0.0
+	for _, l := range lines {
+		total += l.Amount
+	}
+	return round(total)
+}
@@ -0,0 +1,33 @@
+package meals
+
+import (
+	"testing"
+
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+func TestSplitTax(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      float64
+		tax         model.MealTax
+		wantTaxable float64
+		wantTax     float64
+	}{
+		{"inclusive", 105, model.MealTax{TaxRate: 5, TaxInclusive: true}, 100, 5},
+		{"inclusive rounds taxable value", 100, model.MealTax{TaxRate: 5, TaxInclusive: true}, 95.24, 4.76},
+		{"inclusive at 18%", 59, model.MealTax{TaxRate: 18, TaxInclusive: true}, 50, 9},
+		{"exclusive", 100, model.MealTax{TaxRate: 5}, 100, 5},
+		{"exclusive rounds tax", 33.33, model.MealTax{TaxRate: 18}, 33.33, 6},
+		{"exempt", 80, model.MealTax{TaxInclusive: true}, 80, 0},
+		{"nothing charged", 0, model.MealTax{TaxRate: 5, TaxInclusive: true}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taxable, tax := SplitTax(tt.amount, tt.tax)
+			if taxable != tt.wantTaxable || tax != tt.wantTax {
+				t.Errorf("SplitTax(%v, %+v) = %v, %v, want %v, %v", tt.amount, tt.tax, taxable, tax, tt.wantTaxable, tt.wantTax)
+			}
+		})
+	}
+}
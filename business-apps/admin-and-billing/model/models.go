@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -13,6 +14,164 @@ type User struct {
 	Role       string  `json:"role"`
 	Plan       string  `json:"plan"`
 	Balance    float64 `json:"balance"`
+	Email      string  `json:"email"`
+	// Opted in to low balance warnings
+	LowBalanceAlerts bool `json:"low_balance_alerts"`
+	// Credit policy of the user's wallet
+	CreditLimit   float64 `json:"credit_limit"`
+	BalancePolicy string  `json:"balance_policy"`
+	// Inactive customers have left and get no new entries or recharges
+	IsActive      bool       `json:"is_active"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty"`
+}
+
+// Just enough of a customer to pick them from a list
+type UserSuggestion struct {
+	UserID     int     `json:"user_id"`
+	Name       string  `json:"name"`
+	MobileNo   string  `json:"mobile_no"`
+	BuildingNo string  `json:"building_no"`
+	RoomNo     string  `json:"room_no"`
+	Balance    float64 `json:"balance"`
+}
+
+// Editable contact and address details of a customer
+type UserUpdate struct {
+	Name       string `json:"name"`
+	MobileNo   string `json:"mobile_no"`
+	BuildingNo string `json:"building_no"`
+	RoomNo     string `json:"room_no"`
+	Email      string `json:"email"`
+}
+
+type PlanChange struct {
+	Plan          string    `json:"plan"`
+	EffectiveFrom time.Time `json:"effective_from"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+type PlanChangeRequest struct {
+	Plan string `json:"plan"`
+	// YYYY-MM-DD, defaults to today
+	EffectiveFrom string `json:"effective_from"`
+}
+
+type MergeRequest struct {
+	// Duplicate customer whose history moves into the one merged into
+	SourceUserID int `json:"source_user_id"`
+}
+
+type MergeResult struct {
+	UserID       int     `json:"user_id"`
+	SourceUserID int     `json:"source_user_id"`
+	LogsMoved    int     `json:"logs_moved"`
+	TxnsMoved    int     `json:"txns_moved"`
+	BalanceMoved float64 `json:"balance_moved"`
+	NewBalance   float64 `json:"new_balance"`
+	// Shifts both customers had an entry for, which now have two; worth a look
+	OverlappingEntries int `json:"overlapping_entries"`
+}
+
+// Customers whose mobile numbers end in the same ten digits
+type DuplicateMobile struct {
+	// The ten digits they share
+	MobileNo string           `json:"mobile_no"`
+	Users    []UserSuggestion `json:"users"`
+}
+
+// ImportRow is one customer read from an import file.
+type ImportRow struct {
+	// Line in the file, counting the header as line 1
+	Line       int    `json:"line"`
+	UserID     int    `json:"user_id,omitempty"`
+	Name       string `json:"name"`
+	MobileNo   string `json:"mobile_no"`
+	BuildingNo string `json:"building_no"`
+	RoomNo     string `json:"room_no"`
+	Plan       string `json:"plan"`
+	Email      string `json:"email"`
+	// Signed; negative for dues the customer already owes
+	OpeningBalance float64 `json:"opening_balance"`
+	CreditLimit    float64 `json:"credit_limit"`
+	BalancePolicy  string  `json:"balance_policy"`
+}
+
+type ImportResult struct {
+	// A dry run only previews the rows; nothing is created
+	DryRun              bool        `json:"dry_run"`
+	Rows                []ImportRow `json:"rows"`
+	TotalOpeningBalance float64     `json:"total_opening_balance"`
+}
+
+// UserProfile gathers a customer's details, habits and spending in one place.
+type UserProfile struct {
+	User User `json:"user"`
+	// Veg or non-veg choice per weekday
+	Preferences  []DayPreference `json:"preferences"`
+	LastRecharge *LastRecharge   `json:"last_recharge,omitempty"`
+	// Meals over the last 30 and 90 days, per shift
+	MealCounts []ShiftMealCount `json:"meal_counts"`
+	// Extras ordered over the last 90 days, most ordered first
+	FavouriteExtras []ExtraCount `json:"favourite_extras"`
+	// Skipped shifts from 90 days back, including upcoming ones, latest first
+	Skips []Skip `json:"skips"`
+	// Average spent on meals per month over the last six complete months,
+	// counting only months since the customer's first meal
+	AvgMonthlySpend float64 `json:"avg_monthly_spend"`
+}
+
+type DayPreference struct {
+	Weekday string `json:"weekday"`
+	Pref    string `json:"pref"`
+}
+
+type LastRecharge struct {
+	TxnID  int       `json:"txn_id"`
+	Amount float64   `json:"amount"`
+	Date   time.Time `json:"date"`
+	RefID  string    `json:"ref_id"`
+}
+
+type ShiftMealCount struct {
+	Shift      string `json:"shift"`
+	Last30Days int    `json:"last_30_days"`
+	Last90Days int    `json:"last_90_days"`
+}
+
+type ExtraCount struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+type Skip struct {
+	Date  time.Time `json:"date"`
+	Shift string    `json:"shift"`
+}
+
+// Pays out or collects a leaving customer's remaining balance
+type SettlementRequest struct {
+	RefID string `json:"ref_id"`
+	Note  string `json:"note"`
+}
+
+type Settlement struct {
+	UserID int `json:"user_id"`
+	TxnID  int `json:"txn_id,omitempty"`
+	// 'refund' paid the customer, 'collect' received their dues, 'none' when the balance was zero
+	Direction string  `json:"direction"`
+	Amount    float64 `json:"amount"`
+}
+
+type Notification struct {
+	NotificationID int       `json:"notification_id"`
+	UserID         int       `json:"user_id"`
+	Kind           string    `json:"kind"`
+	Channel        string    `json:"channel"`
+	Recipient      string    `json:"recipient"`
+	Message        string    `json:"message"`
+	Status         string    `json:"status"`
+	Error          string    `json:"error,omitempty"`
+	SentAt         time.Time `json:"sent_at"`
 }
 
 type EntryRequest struct {
@@ -28,6 +187,8 @@ type EntryRequest struct {
 	ExtraFishQty      int       `json:"extra_fish_qty"`
 	ExtraEggQty       int       `json:"extra_egg_qty"`
 	ExtraVegetableQty int       `json:"extra_vegetable_qty"`
+	// Allow another entry for the same customer, date and shift
+	SecondPortion bool `json:"second_portion"`
 }
 
 type DailyLog struct {
@@ -46,6 +207,41 @@ type DailyLog struct {
 	ExtraEggQty       int       `json:"extra_egg_qty"`
 	ExtraVegetableQty int       `json:"extra_vegetable_qty"`
 	TotalCost         float64   `json:"total_cost"`
+	// Chargeable items at the prices the entry was charged at
+	Items []BillLine `json:"items,omitempty"`
+	// Only set on deleted entries
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy    string     `json:"deleted_by,omitempty"`
+	DeleteReason string     `json:"delete_reason,omitempty"`
+}
+
+type DeleteEntryRequest struct {
+	DeletedBy string `json:"deleted_by"`
+	Reason    string `json:"reason"`
+}
+
+type BillLine struct {
+	ItemID    string  `json:"item_id"`
+	ItemName  string  `json:"item_name"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	// Amount charged including tax, split into taxable value and GST
+	Amount       float64 `json:"amount"`
+	HSNSAC       string  `json:"hsn_sac,omitempty"`
+	TaxRate      float64 `json:"tax_rate"`
+	TaxableValue float64 `json:"taxable_value"`
+	TaxAmount    float64 `json:"tax_amount"`
+	// Set on entries made before items were stored, whose split into items
+	// is estimated from the amount charged
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// ItemTotal sums one item over a bill's period.
+type ItemTotal struct {
+	ItemID   string  `json:"item_id"`
+	ItemName string  `json:"item_name"`
+	Quantity int     `json:"quantity"`
+	Amount   float64 `json:"amount"`
 }
 
 type Expense struct {
@@ -54,6 +250,9 @@ type Expense struct {
 	Reason      string    `json:"reason"`
 	Amount      float64   `json:"amount"`
 	CreatedAt   time.Time `json:"created_at"`
+	// food, packaging, staff, rent, utilities or other (the default); left
+	// unchanged on update when empty
+	Category string `json:"category"`
 }
 
 type RechargeRequest struct {
@@ -63,15 +262,173 @@ type RechargeRequest struct {
 	TxnDate time.Time `json:"txn_date"` // Will be stored in CREATED_AT
 }
 
+type OTPRequest struct {
+	MobileNo string `json:"mobile_no"`
+}
+
+type LoginRequest struct {
+	MobileNo string `json:"mobile_no"`
+	Code     string `json:"code"`
+}
+
+type CustomerSession struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+}
+
+// What customers see of their own account
+type CustomerAccount struct {
+	UserID     int     `json:"user_id"`
+	Name       string  `json:"name"`
+	MobileNo   string  `json:"mobile_no"`
+	BuildingNo string  `json:"building_no"`
+	RoomNo     string  `json:"room_no"`
+	Plan       string  `json:"plan"`
+	Balance    float64 `json:"balance"`
+	// Recharges submitted but not yet confirmed
+	PendingClaims float64 `json:"pending_claims"`
+}
+
+type SkipRequest struct {
+	// YYYY-MM-DD
+	Date  string `json:"date"`
+	Shift string `json:"shift"`
+}
+
+// A customer's own report of a payment, credited once an admin confirms it
+type RechargeClaim struct {
+	Amount float64 `json:"amount"`
+	// UPI or bank reference of the payment
+	RefID string `json:"ref_id"`
+	// YYYY-MM-DD the payment was made, defaults to today
+	PaidOn string `json:"paid_on"`
+}
+
+type ClaimRejection struct {
+	Reason string `json:"reason"`
+}
+
+type WalletTransaction struct {
+	TxnID   int     `json:"txn_id"`
+	UserID  int     `json:"user_id"`
+	Name    string  `json:"name,omitempty"`
+	TxnType string  `json:"txn_type"`
+	Status  string  `json:"status"`
+	Amount  float64 `json:"amount"`
+	// Not known until a recharge is confirmed
+	BalanceAfter *float64  `json:"balance_after,omitempty"`
+	RefID        string    `json:"ref_id"`
+	Reason       string    `json:"reason"`
+	ValueDate    time.Time `json:"value_date"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type AdjustmentRequest struct {
+	UserID     int       `json:"user_id"`
+	TxnType    string    `json:"txn_type"` // 'adjustment' or 'write_off'
+	Amount     float64   `json:"amount"`   // Signed for adjustments, positive credits the wallet
+	Reason     string    `json:"reason"`
+	ApprovedBy string    `json:"approved_by"`
+	TxnDate    time.Time `json:"txn_date"`
+}
+
+type TransferRequest struct {
+	FromUserID int       `json:"from_user_id"`
+	ToUserID   int       `json:"to_user_id"`
+	Amount     float64   `json:"amount"`
+	Note       string    `json:"note"`
+	TxnDate    time.Time `json:"txn_date"`
+}
+
+type WalletPolicy struct {
+	UserID        int     `json:"user_id"`
+	CreditLimit   float64 `json:"credit_limit"`
+	BalancePolicy string  `json:"balance_policy"` // 'block', 'warn' or 'allow'
+}
+
+type DuesEntry struct {
+	User            User       `json:"user"`
+	ArrearsSince    *time.Time `json:"arrears_since"`
+	DaysOutstanding int        `json:"days_outstanding"`
+	OverLimit       bool       `json:"over_limit"`
+}
+
 type BillReport struct {
-	User           User       `json:"user"`
-	StartDate      time.Time  `json:"start_date"`
-	EndDate        time.Time  `json:"end_date"`
-	Logs           []DailyLog `json:"logs"`
-	TotalSpent     float64    `json:"total_spent"`
-	TotalRecharges float64    `json:"total_recharges"`
-	OpeningBalance float64    `json:"opening_balance"`
-	ClosingBalance float64    `json:"closing_balance"`
+	User           User        `json:"user"`
+	StartDate      time.Time   `json:"start_date"`
+	EndDate        time.Time   `json:"end_date"`
+	Logs           []DailyLog  `json:"logs"`
+	ItemTotals     []ItemTotal `json:"item_totals"`
+	TotalSpent     float64     `json:"total_spent"`
+	TotalRecharges float64     `json:"total_recharges"`
+	// Net manual adjustments (positive credited the wallet) and write-offs
+	TotalAdjustments float64 `json:"total_adjustments"`
+	TotalWriteOffs   float64 `json:"total_write_offs"`
+	// Balance moved from/to other users' wallets
+	TotalTransfersIn  float64 `json:"total_transfers_in"`
+	TotalTransfersOut float64 `json:"total_transfers_out"`
+	// Net final settlement (negative paid the customer out)
+	TotalSettlements float64 `json:"total_settlements"`
+	// Balance brought over from before the customer was imported
+	TotalBroughtForward float64 `json:"total_brought_forward"`
+	OpeningBalance      float64 `json:"opening_balance"`
+	ClosingBalance      float64 `json:"closing_balance"`
+	// Zone whose calendar days the period is made of
+	Timezone string `json:"timezone"`
+	// GST included in TotalSpent
+	TaxableValue   float64        `json:"taxable_value"`
+	TotalTax       float64        `json:"total_tax"`
+	Reconciliation Reconciliation `json:"reconciliation"`
+}
+
+// Reconciliation checks that opening balance plus the movements in the period
+// add up to the closing balance replayed from the ledger.
+type Reconciliation struct {
+	Expected   float64 `json:"expected"`
+	Actual     float64 `json:"actual"`
+	Difference float64 `json:"difference"`
+	Balanced   bool    `json:"balanced"`
+}
+
+type Statement struct {
+	StatementID    int       `json:"statement_id"`
+	StatementNo    string    `json:"statement_no"`
+	UserID         int       `json:"user_id"`
+	PeriodStart    time.Time `json:"period_start"`
+	PeriodEnd      time.Time `json:"period_end"`
+	OpeningBalance float64   `json:"opening_balance"`
+	TotalSpent     float64   `json:"total_spent"`
+	TotalRecharges float64   `json:"total_recharges"`
+	// Net of adjustments, write-offs, transfers, settlement and balance brought forward
+	OtherCredits   float64   `json:"other_credits"`
+	ClosingBalance float64   `json:"closing_balance"`
+	MealCount      int       `json:"meal_count"`
+	GeneratedAt    time.Time `json:"generated_at"`
+}
+
+type BulkBillRequest struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// zip (one PDF per user), csv or xlsx
+	Format string `json:"format"`
+}
+
+// BulkBillJob reports the progress of a bulk bill export.
+type BulkBillJob struct {
+	JobID         string     `json:"job_id"`
+	Format        string     `json:"format"`
+	StartDate     time.Time  `json:"start_date"`
+	EndDate       time.Time  `json:"end_date"`
+	Status        string     `json:"status"` // running, done or failed
+	Total         int        `json:"total"`
+	Done          int        `json:"done"`
+	FailedUserIDs []int      `json:"failed_user_ids,omitempty"` // bills left out because they failed
+	Error         string     `json:"error,omitempty"`
+	DownloadURL   string     `json:"download_url,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
 }
 
 type DashboardStats struct {
@@ -80,18 +437,29 @@ type DashboardStats struct {
 	NetProfit       float64 `json:"net_profit"`
 	MonthlyRevenue  float64 `json:"monthly_revenue"`
 	MonthlyExpenses float64 `json:"monthly_expenses"`
-	ActiveCustomers int     `json:"active_customers"`
-	WalletPool      float64 `json:"wallet_pool"`
+	// Customers with a meal in the last 30 days
+	ActiveCustomers int `json:"active_customers"`
+	// Customers not marked as having left
+	RegisteredCustomers int     `json:"registered_customers"`
+	WalletPool          float64 `json:"wallet_pool"`
+	// Goodwill credits and corrections net of debits, and forgiven dues
+	TotalAdjustments float64 `json:"total_adjustments"`
+	TotalWriteOffs   float64 `json:"total_write_offs"`
 }
 
+// TrendPoint covers one day, week or month of an analytics range. Weeks
+// start on Monday; the first and last points are cut to the range.
 type TrendPoint struct {
 	Date     string  `json:"date"`
+	EndDate  string  `json:"end_date"`
 	Revenue  float64 `json:"revenue"`
 	Expenses float64 `json:"expenses"`
 }
 
-type AnalyticsStats struct {
-	Trends           []TrendPoint   `json:"trends"`
+// AnalyticsPeriod sums up the meals, revenue and expenses of a date range.
+type AnalyticsPeriod struct {
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
 	MealTypes        map[string]int `json:"meal_types"`
 	Shifts           map[string]int `json:"shifts"`
 	TotalRevenue     float64        `json:"total_revenue"`
@@ -99,9 +467,250 @@ type AnalyticsStats struct {
 	ProfitPercentage float64        `json:"profit_percentage"`
 }
 
+type AnalyticsStats struct {
+	AnalyticsPeriod
+	Granularity string       `json:"granularity"`
+	Trends      []TrendPoint `json:"trends"`
+	// The same number of days just before From
+	Previous AnalyticsPeriod `json:"previous"`
+	Change   AnalyticsChange `json:"change"`
+}
+
+// AnalyticsChange compares a range with the one before it. Percentages are
+// null when the previous value was zero.
+type AnalyticsChange struct {
+	RevenuePct  *float64 `json:"revenue_pct"`
+	ExpensesPct *float64 `json:"expenses_pct"`
+	MealsPct    *float64 `json:"meals_pct"`
+	// Difference in percentage points
+	ProfitPoints float64 `json:"profit_points"`
+}
+
+// CohortReport groups customers by the month of their first meal and follows
+// how many kept eating in each month after.
+type CohortReport struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Cohorts []Cohort  `json:"cohorts"`
+	// Retention n months after the first meal, over the cohorts that have
+	// reached that month and weighted by their size
+	Curve []float64 `json:"curve"`
+}
+
+// Cohort is the customers whose first meal was in Month. Retained counts
+// those with a meal 0, 1, 2... months later, up to the current month.
+type Cohort struct {
+	Month        time.Time `json:"month"`
+	Customers    int       `json:"customers"`
+	Retained     []int     `json:"retained"`
+	RetentionPct []float64 `json:"retention_pct"`
+}
+
+// ChurnReport lists customers who have had no meal for Days days and the
+// revenue lost if they don't come back. Active customers are those who have
+// had one since.
+type ChurnReport struct {
+	Days             int     `json:"days"`
+	ActiveCustomers  int     `json:"active_customers"`
+	ChurnedCustomers int     `json:"churned_customers"`
+	NeverOrdered     int     `json:"never_ordered"`
+	ChurnRatePct     float64 `json:"churn_rate_pct"`
+	// Monthly spend of the churned customers before they stopped
+	RevenueAtRisk float64 `json:"revenue_at_risk"`
+	// Wallet balance the churned customers still hold
+	BalanceHeld float64           `json:"balance_held"`
+	Customers   []ChurnedCustomer `json:"customers"`
+}
+
+type ChurnedCustomer struct {
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	MobileNo   string    `json:"mobile_no"`
+	BuildingNo string    `json:"building_no"`
+	RoomNo     string    `json:"room_no"`
+	LastMeal   time.Time `json:"last_meal"`
+	DaysSince  int       `json:"days_since"`
+	Balance    float64   `json:"balance"`
+	// Average over the 90 days up to the last meal, scaled to 30 days
+	MonthlySpend float64 `json:"monthly_spend"`
+}
+
+// ShiftForecast predicts what one shift of a day will need. Samples is how
+// many past same weekdays it is based on; with none it falls back to the
+// monthly customers scheduled for the weekday.
+type ShiftForecast struct {
+	Date    time.Time `json:"date"`
+	Weekday string    `json:"weekday"`
+	Shift   string    `json:"shift"`
+	Samples int       `json:"samples"`
+	// Monthly customers with a meal preference for the weekday
+	Scheduled    int            `json:"scheduled"`
+	Skips        int            `json:"skips"`
+	OneOffOrders int            `json:"one_off_orders"`
+	Items        []ItemForecast `json:"items"`
+}
+
+// ItemForecast is the predicted quantity of one item with an 80% band.
+type ItemForecast struct {
+	ItemID    string `json:"item_id"`
+	ItemName  string `json:"item_name"`
+	Predicted int    `json:"predicted"`
+	Low       int    `json:"low"`
+	High      int    `json:"high"`
+}
+
+// ForecastAccuracy compares recorded forecasts made Horizon days ahead with
+// what was served.
+type ForecastAccuracy struct {
+	From    time.Time      `json:"from"`
+	To      time.Time      `json:"to"`
+	Horizon int            `json:"horizon"`
+	Items   []ItemAccuracy `json:"items"`
+}
+
+type ItemAccuracy struct {
+	ItemID string `json:"item_id"`
+	// Shifts with both a forecast and a record of what was served
+	Shifts    int `json:"shifts"`
+	Predicted int `json:"predicted"`
+	Actual    int `json:"actual"`
+	// Mean absolute error per shift, and the total absolute error as a
+	// percentage of the actual quantity (nil when nothing was served)
+	MeanAbsError float64  `json:"mean_abs_error"`
+	ErrorPct     *float64 `json:"error_pct"`
+	// Mean of predicted minus actual; positive means over-preparing
+	Bias          float64 `json:"bias"`
+	WithinBandPct float64 `json:"within_band_pct"`
+}
+
+// Profitability breaks a date range's margin down by menu item. Revenue is
+// net of GST. Food cost is what was spent on food and packaging when such
+// expenses were recorded, otherwise the items' standard unit costs.
+type Profitability struct {
+	From             time.Time `json:"from"`
+	To               time.Time `json:"to"`
+	Days             int       `json:"days"`
+	MealsServed      int       `json:"meals_served"`
+	Revenue          float64   `json:"revenue"`
+	FoodCost         float64   `json:"food_cost"`
+	StandardFoodCost float64   `json:"standard_food_cost"`
+	// expenses or standard
+	CostBasis      string  `json:"cost_basis"`
+	CostPerMeal    float64 `json:"cost_per_meal"`
+	GrossMargin    float64 `json:"gross_margin"`
+	GrossMarginPct float64 `json:"gross_margin_pct"`
+	// Every other expense category
+	Overheads           float64 `json:"overheads"`
+	OverheadsPerDay     float64 `json:"overheads_per_day"`
+	ContributionPerMeal float64 `json:"contribution_per_meal"`
+	// Meals a day needed to cover overheads; nil when meals don't cover
+	// their own food cost
+	BreakEvenMealsPerDay *float64           `json:"break_even_meals_per_day"`
+	MainMeals            MarginContribution `json:"main_meals"`
+	Extras               MarginContribution `json:"extras"`
+	Items                []ItemMargin       `json:"items"`
+}
+
+// MarginContribution is one group of items' share of the margin at standard
+// cost.
+type MarginContribution struct {
+	Revenue  float64 `json:"revenue"`
+	Cost     float64 `json:"cost"`
+	Margin   float64 `json:"margin"`
+	SharePct float64 `json:"share_pct"`
+}
+
+// ItemMargin is the gross margin of one MEAL_PRICES item at standard cost.
+type ItemMargin struct {
+	ItemID    string  `json:"item_id"`
+	ItemName  string  `json:"item_name"`
+	Quantity  int     `json:"quantity"`
+	Revenue   float64 `json:"revenue"`
+	UnitCost  float64 `json:"unit_cost"`
+	Cost      float64 `json:"cost"`
+	Margin    float64 `json:"margin"`
+	MarginPct float64 `json:"margin_pct"`
+}
+
 type MealPrice struct {
 	ItemID    string    `json:"item_id"`
 	ItemName  string    `json:"item_name"`
 	Price     float64   `json:"price"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Standard cost of the ingredients and packaging of one unit
+	UnitCost float64 `json:"unit_cost"`
+	MealTax
+}
+
+type MealCost struct {
+	UnitCost float64 `json:"unit_cost"`
+}
+
+// MealTax is the GST treatment of a menu item. Inclusive prices already
+// contain the tax; exclusive prices have it added on top.
+type MealTax struct {
+	HSNSAC       string  `json:"hsn_sac"`
+	TaxRate      float64 `json:"tax_rate"`
+	TaxInclusive bool    `json:"tax_inclusive"`
+}
+
+type InvoiceRequest struct {
+	UserID    int    `json:"user_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// Invoice is a GST tax invoice for the meals supplied to a user in a period.
+// Supplies are intra-state, so tax is split equally into CGST and SGST.
+type Invoice struct {
+	InvoiceID     int           `json:"invoice_id"`
+	InvoiceNo     string        `json:"invoice_no"`
+	FinancialYear string        `json:"financial_year"`
+	InvoiceDate   time.Time     `json:"invoice_date"`
+	User          User          `json:"user"`
+	PeriodStart   time.Time     `json:"period_start"`
+	PeriodEnd     time.Time     `json:"period_end"`
+	GSTIN         string        `json:"gstin"`
+	PlaceOfSupply string        `json:"place_of_supply"`
+	TaxableValue  float64       `json:"taxable_value"`
+	CGST          float64       `json:"cgst"`
+	SGST          float64       `json:"sgst"`
+	Total         float64       `json:"total"`
+	Lines         []InvoiceLine `json:"lines,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+type InvoiceLine struct {
+	LineNo       int     `json:"line_no"`
+	ItemName     string  `json:"item_name"`
+	HSNSAC       string  `json:"hsn_sac"`
+	Quantity     int     `json:"quantity"`
+	TaxRate      float64 `json:"tax_rate"`
+	TaxableValue float64 `json:"taxable_value"`
+	CGST         float64 `json:"cgst"`
+	SGST         float64 `json:"sgst"`
+	Total        float64 `json:"total"`
+}
+
+type AuditEntry struct {
+	AuditID    int64           `json:"audit_id"`
+	Seq        int64           `json:"seq"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	Hash       string          `json:"hash"`
+}
+
+// Result of checking the audit log's hash chain
+type AuditVerification struct {
+	Valid       bool   `json:"valid"`
+	Checked     int    `json:"checked"`
+	BrokenAtSeq int64  `json:"broken_at_seq,omitempty"`
+	Problem     string `json:"problem,omitempty"`
 }
@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+var notifier Notifier = &LogNotifier{}
+
+// Use replaces the notifier used by jobs and handlers in this package.
+func Use(n Notifier) {
+	notifier = n
+}
+
+// Default returns the notifier configured with Use.
+func Default() Notifier {
+	return notifier
+}
+
+// LowBalanceConfig controls when a customer is warned about their balance.
+type LowBalanceConfig struct {
+	// Warn when the balance falls below this amount
+	Threshold float64
+	// ... or below this many days of the user's average consumption
+	Days float64
+	// How often the job runs, and the minimum gap between two warnings to
+	// the same user
+	Interval time.Duration
+	Cooldown time.Duration
+}
+
+// LowBalanceConfigFromEnv reads LOW_BALANCE_THRESHOLD, LOW_BALANCE_DAYS,
+// LOW_BALANCE_INTERVAL and LOW_BALANCE_COOLDOWN.
+func LowBalanceConfigFromEnv() LowBalanceConfig {
+	cfg := LowBalanceConfig{Threshold: 100, Days: 3, Interval: time.Hour, Cooldown: 24 * time.Hour}
+	if v, err := strconv.ParseFloat(os.Getenv("LOW_BALANCE_THRESHOLD"), 64); err == nil {
+		cfg.Threshold = v
+	}
+	if v, err := strconv.ParseFloat(os.Getenv("LOW_BALANCE_DAYS"), 64); err == nil {
+		cfg.Days = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOW_BALANCE_INTERVAL")); err == nil && v > 0 {
+		cfg.Interval = v
+	}
+	if v, err := time.ParseDuration(os.Getenv("LOW_BALANCE_COOLDOWN")); err == nil {
+		cfg.Cooldown = v
+	}
+	return cfg
+}
+
+// StartLowBalanceJob checks balances in the background every cfg.Interval
+// until ctx is cancelled.
+func StartLowBalanceJob(ctx context.Context, cfg LowBalanceConfig) {
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			sent, err := CheckLowBalances(ctx, cfg)
+			if err != nil {
+				log.Printf("Low balance check failed: %v\n", err)
+			} else if sent > 0 {
+				log.Printf("Sent %d low balance notifications\n", sent)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// CheckLowBalances warns every opted-in user whose balance is below the
+// threshold or below cfg.Days of their average daily spend over the last 30
+// days, skipping users warned within the cooldown. Failed sends count
+// towards the cooldown too, so a number that keeps failing is retried once
+// per cooldown rather than on every run. It returns the number of messages
+// sent.
+func CheckLowBalances(ctx context.Context, cfg LowBalanceConfig) (int, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		WITH consumption AS (
+			SELECT USER_ID, SUM(TOTAL_COST) / 30.0 AS DAILY_AVG
+			FROM DAILY_LOGS
+			WHERE LOG_DATE >= CURRENT_DATE - 30 AND DELETED_AT IS NULL
+			GROUP BY USER_ID
+		)
+		SELECT u.USER_ID, COALESCE(u.NAME, ''), COALESCE(u.MOBILE_NO, ''), COALESCE(u.EMAIL, ''), w.BALANCE, COALESCE(c.DAILY_AVG, 0)
+		FROM USERS u
+		JOIN WALLET w ON w.USER_ID = u.USER_ID
+		LEFT JOIN consumption c ON c.USER_ID = u.USER_ID
+		WHERE u.LOW_BALANCE_ALERTS AND u.IS_ACTIVE
+		  AND (w.BALANCE < $1 OR w.BALANCE < COALESCE(c.DAILY_AVG, 0) * $2)
+		  AND NOT EXISTS (
+			  SELECT 1 FROM NOTIFICATION_LOG n
+			  WHERE n.USER_ID = u.USER_ID
+			    AND n.KIND = 'low_balance'
+			    AND n.SENT_AT > NOW() - make_interval(secs => $3)
+		  )
+	`, cfg.Threshold, cfg.Days, cfg.Cooldown.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	type candidate struct {
+		msg      Message
+		balance  float64
+		dailyAvg float64
+	}
+	var candidates []candidate
+	for rows.Next() {
+		var c candidate
+		if err := rows.Scan(&c.msg.UserID, &c.msg.Name, &c.msg.MobileNo, &c.msg.Email, &c.balance, &c.dailyAvg); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	businessName := envOr("BUSINESS_NAME", "Ranjitar Rannaghor")
+	sent := 0
+	for _, c := range candidates {
+		msg := c.msg
+		msg.Subject = "Low wallet balance"
+		msg.Body = fmt.Sprintf("Hi %s, your %s wallet balance is Rs %.2f. Please recharge to keep your meals coming.", msg.Name, businessName, c.balance)
+		if c.dailyAvg > 0 {
+			msg.Body += fmt.Sprintf(" That covers about %.0f days at your usual spend.", max(c.balance/c.dailyAvg, 0))
+		}
+
+		recipient := notifier.Recipient(msg)
+		if recipient == "" {
+			continue
+		}
+		status, errText := "sent", ""
+		if err := notifier.Send(ctx, msg); err != nil {
+			status, errText = "failed", err.Error()
+		} else {
+			sent++
+		}
+
+		_, err := dbPool.Exec(ctx, `
+			INSERT INTO NOTIFICATION_LOG (USER_ID, KIND, CHANNEL, RECIPIENT, MESSAGE, STATUS, ERROR)
+			VALUES ($1, 'low_balance', $2, $3, $4, $5, NULLIF($6, ''))
+		`, msg.UserID, notifier.Channel(), recipient, msg.Body, status, errText)
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// RunLowBalanceCheck triggers the low balance job on demand.
+func RunLowBalanceCheck(w http.ResponseWriter, r *http.Request) {
+	sent, err := CheckLowBalances(r.Context(), LowBalanceConfigFromEnv())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"sent": sent})
+}
+
+func GetNotifications(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT NOTIFICATION_ID, USER_ID, KIND, CHANNEL, RECIPIENT, MESSAGE, STATUS, COALESCE(ERROR, ''), SENT_AT
+		FROM NOTIFICATION_LOG
+	`
+	params := api.Query(r)
+	userID := params.Int("user_id", false)
+	if params.Failed(w) {
+		return
+	}
+
+	var args []interface{}
+	if userID != 0 {
+		query += ` WHERE USER_ID = $1`
+		args = append(args, userID)
+	}
+	query += ` ORDER BY SENT_AT DESC LIMIT 200`
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), query, args...)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var notifications []model.Notification
+	for rows.Next() {
+		var n model.Notification
+		err := rows.Scan(&n.NotificationID, &n.UserID, &n.Kind, &n.Channel, &n.Recipient, &n.Message, &n.Status, &n.Error, &n.SentAt)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		notifications = append(notifications, n)
+	}
+
+	json.NewEncoder(w).Encode(notifications)
+}
@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is a single notification to a customer.
+type Message struct {
+	UserID   int
+	Name     string
+	MobileNo string
+	Email    string
+	Subject  string
+	Body     string
+}
+
+// Notifier delivers messages over one channel (SMS, WhatsApp, email, ...).
+type Notifier interface {
+	Channel() string
+	// Recipient is the address the message will be delivered to, empty when
+	// the user cannot be reached on this channel.
+	Recipient(msg Message) string
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv builds the notifier selected by NOTIFIER_CHANNEL: "sms",
+// "whatsapp", "email" or "log" (default).
+func NewFromEnv() Notifier {
+	switch channel := os.Getenv("NOTIFIER_CHANNEL"); channel {
+	case "sms", "whatsapp":
+		return &WebhookNotifier{
+			ChannelName: channel,
+			URL:         os.Getenv("NOTIFIER_WEBHOOK_URL"),
+			Token:       os.Getenv("NOTIFIER_WEBHOOK_TOKEN"),
+		}
+	case "email":
+		return &EmailNotifier{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     envOr("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	default:
+		return &LogNotifier{Path: os.Getenv("NOTIFIER_LOG_FILE")}
+	}
+}
+
+// NewSMSFromEnv builds the SMS sender for one-time login codes. Codes go to
+// the gateway at SMS_WEBHOOK_URL, or without one to the stand-in log at
+// SMS_LOG_FILE (or the server log).
+func NewSMSFromEnv() Notifier {
+	if url := os.Getenv("SMS_WEBHOOK_URL"); url != "" {
+		return &WebhookNotifier{ChannelName: "sms", URL: url, Token: os.Getenv("SMS_WEBHOOK_TOKEN")}
+	}
+	return &LogNotifier{Path: os.Getenv("SMS_LOG_FILE")}
+}
+
+// LogNotifier is a stand-in that appends messages to a file, or to the
+// server log when no file is configured.
+type LogNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *LogNotifier) Channel() string { return "log" }
+
+func (n *LogNotifier) Recipient(msg Message) string { return msg.MobileNo }
+
+func (n *LogNotifier) Send(ctx context.Context, msg Message) error {
+	line := fmt.Sprintf("%s to=%s user_id=%d subject=%q body=%q\n", time.Now().Format(time.RFC3339), msg.MobileNo, msg.UserID, msg.Subject, msg.Body)
+	if n.Path == "" {
+		log.Print("notify: " + line)
+		return nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(line)
+	return err
+}
+
+// WebhookNotifier posts messages to an SMS or WhatsApp gateway as JSON.
+type WebhookNotifier struct {
+	ChannelName string
+	URL         string
+	Token       string
+}
+
+func (n *WebhookNotifier) Channel() string { return n.ChannelName }
+
+func (n *WebhookNotifier) Recipient(msg Message) string { return msg.MobileNo }
+
+func (n *WebhookNotifier) Send(ctx context.Context, msg Message) error {
+	if n.URL == "" {
+		return fmt.Errorf("NOTIFIER_WEBHOOK_URL is not configured")
+	}
+	payload, err := json.Marshal(map[string]string{
+		"channel": n.ChannelName,
+		"to":      msg.MobileNo,
+		"message": msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s gateway returned %s", n.ChannelName, resp.Status)
+	}
+	return nil
+}
+
+// EmailNotifier sends plain-text mail through an SMTP relay.
+type EmailNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (n *EmailNotifier) Channel() string { return "email" }
+
+func (n *EmailNotifier) Recipient(msg Message) string { return msg.Email }
+
+func (n *EmailNotifier) Send(ctx context.Context, msg Message) error {
+	if n.Host == "" {
+		return fmt.Errorf("SMTP_HOST is not configured")
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", n.From, msg.Email, msg.Subject, msg.Body)
+	return smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{msg.Email}, []byte(body))
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
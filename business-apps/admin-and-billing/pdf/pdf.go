@@ -0,0 +1,134 @@
+// Package pdf writes simple A4 documents made of text, lines and filled
+// rectangles using the built-in Helvetica fonts, enough for bills and
+// invoices without an external dependency.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.0
+	PageHeight = 842.0
+)
+
+type Document struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+}
+
+func New() *Document {
+	d := &Document{}
+	d.AddPage()
+	return d
+}
+
+// AddPage starts a new page; drawing goes to the newest page.
+func (d *Document) AddPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+}
+
+// Text draws s with its baseline starting at x, y, measured from the bottom
+// left of the page.
+func (d *Document) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(s))
+}
+
+// TextRight draws s so that it ends at x.
+func (d *Document) TextRight(x, y, size float64, bold bool, s string) {
+	d.Text(x-TextWidth(s, size), y, size, bold, s)
+}
+
+func (d *Document) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page, "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// Rect fills a rectangle with the given grey level (0 black, 1 white).
+func (d *Document) Rect(x, y, w, h, grey float64) {
+	fmt.Fprintf(d.page, "%.2f g %.2f %.2f %.2f %.2f re f 0 g\n", grey, x, y, w, h)
+}
+
+// Bytes renders the document.
+func (d *Document) Bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes
+	// a page object followed by its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", PageWidth, PageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escape makes s safe inside a PDF string. The standard fonts only cover
+// Latin text, so anything else is replaced.
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '₹':
+			b.WriteString("Rs ")
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Helvetica advance widths for ASCII 32-126 in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// TextWidth approximates the width of s in points using Helvetica metrics.
+func TextWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range strings.ReplaceAll(s, "₹", "Rs ") {
+		if r >= 32 && r < 127 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
@@ -0,0 +1,347 @@
+// Package portal is the customer self-service API. Customers log in with a
+// one-time code sent to their mobile number and can then see and act on
+// their own account only.
+package portal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/notify"
+)
+
+const (
+	otpTTL = 5 * time.Minute
+	// Wrong guesses allowed before a code stops working
+	maxOTPAttempts = 5
+	// Codes can be resent once a minute, up to five an hour
+	otpResendAfter = time.Minute
+	maxOTPsPerHour = 5
+	sessionTTL     = 30 * 24 * time.Hour
+)
+
+var sms notify.Notifier = &notify.LogNotifier{}
+
+// UseSMS sets the sender of login codes.
+func UseSMS(n notify.Notifier) {
+	sms = n
+}
+
+type contextKey struct{}
+
+// CustomerID is the customer an authenticated request was made by.
+func CustomerID(r *http.Request) int {
+	userID, _ := r.Context().Value(contextKey{}).(int)
+	return userID
+}
+
+// source attributes changes to the logged-in customer rather than to the
+// X-Actor header, which customers control.
+func source(r *http.Request) audit.Source {
+	src := audit.FromRequest(r)
+	src.Actor = fmt.Sprintf("customer:%d", CustomerID(r))
+	return src
+}
+
+// RequestOTP texts a login code to a customer's mobile number. It answers
+// the same whether or not the number belongs to a customer, so it can't be
+// used to find out who is one.
+func RequestOTP(w http.ResponseWriter, r *http.Request) {
+	var req model.OTPRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	mobile, ok := database.NormalizeMobile(req.MobileNo)
+	var v api.Validator
+	v.Check(ok, "mobile_no", "is not a valid mobile number")
+	if v.Failed(w) {
+		return
+	}
+
+	accepted := func() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(map[string]interface{}{"expires_in": int(otpTTL.Seconds())})
+	}
+
+	// Limit by number before looking the customer up, so numbers that
+	// aren't customers' are limited the same way. The lock on the number
+	// stops concurrent requests all passing the count before any is recorded.
+	dbPool := database.GetDbConn()
+	if _, err := dbPool.Exec(r.Context(), `DELETE FROM OTP_REQUESTS WHERE REQUESTED_AT < NOW() - INTERVAL '1 hour'`); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+	if _, err := tx.Exec(r.Context(), `SELECT PG_ADVISORY_XACT_LOCK(HASHTEXT('OTP_REQUESTS:' || $1::TEXT))`, mobile); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	var lastHour int
+	var last *time.Time
+	err = tx.QueryRow(r.Context(), `
+		SELECT COUNT(*), MAX(REQUESTED_AT) FROM OTP_REQUESTS WHERE MOBILE_NO = $1
+	`, mobile).Scan(&lastHour, &last)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if lastHour >= maxOTPsPerHour || (last != nil && time.Since(*last) < otpResendAfter) {
+		api.TooManyRequests(w, "Too many codes requested, please wait before trying again")
+		return
+	}
+	if _, err := tx.Exec(r.Context(), `INSERT INTO OTP_REQUESTS (MOBILE_NO) VALUES ($1)`, mobile); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	userID, name, err := customerByMobile(r.Context(), mobile)
+	if errors.Is(err, pgx.ErrNoRows) {
+		accepted()
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	code, err := newCode()
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	_, err = dbPool.Exec(r.Context(), `
+		INSERT INTO CUSTOMER_OTPS (USER_ID, CODE_HASH, EXPIRES_AT) VALUES ($1, $2, $3)
+	`, userID, codeHash(userID, code), time.Now().Add(otpTTL))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	err = sms.Send(r.Context(), notify.Message{
+		UserID:   userID,
+		Name:     name,
+		MobileNo: mobile,
+		Subject:  "Login code",
+		Body:     fmt.Sprintf("%s is your login code. It expires in %d minutes.", code, int(otpTTL.Minutes())),
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	accepted()
+}
+
+// Login exchanges a mobile number and the code texted to it for a session
+// token, sent as "Authorization: Bearer <token>" on later requests.
+func Login(w http.ResponseWriter, r *http.Request) {
+	var req model.LoginRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	mobile, ok := database.NormalizeMobile(req.MobileNo)
+	var v api.Validator
+	v.Check(ok, "mobile_no", "is not a valid mobile number")
+	v.Check(strings.TrimSpace(req.Code) != "", "code", "is required")
+	if v.Failed(w) {
+		return
+	}
+
+	invalid := func() { api.Unauthorized(w, "Code is wrong or has expired") }
+	userID, name, err := customerByMobile(r.Context(), mobile)
+	if errors.Is(err, pgx.ErrNoRows) {
+		invalid()
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Only the latest code counts
+	var otpID, attempts int
+	var hash string
+	var expiresAt time.Time
+	var used *time.Time
+	err = tx.QueryRow(r.Context(), `
+		SELECT OTP_ID, CODE_HASH, ATTEMPTS, EXPIRES_AT, USED_AT FROM CUSTOMER_OTPS
+		WHERE USER_ID = $1
+		ORDER BY CREATED_AT DESC, OTP_ID DESC
+		LIMIT 1
+		FOR UPDATE
+	`, userID).Scan(&otpID, &hash, &attempts, &expiresAt, &used)
+	if errors.Is(err, pgx.ErrNoRows) {
+		invalid()
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if used != nil || attempts >= maxOTPAttempts || time.Now().After(expiresAt) {
+		invalid()
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(codeHash(userID, strings.TrimSpace(req.Code)))) != 1 {
+		if _, err := tx.Exec(r.Context(), `UPDATE CUSTOMER_OTPS SET ATTEMPTS = ATTEMPTS + 1 WHERE OTP_ID = $1`, otpID); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if err := tx.Commit(r.Context()); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		invalid()
+		return
+	}
+
+	if _, err := tx.Exec(r.Context(), `UPDATE CUSTOMER_OTPS SET USED_AT = NOW() WHERE OTP_ID = $1`, otpID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	token, err := newToken()
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	session := model.CustomerSession{Token: token, ExpiresAt: time.Now().Add(sessionTTL), UserID: userID, Name: name}
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO CUSTOMER_SESSIONS (TOKEN_HASH, USER_ID, EXPIRES_AT) VALUES ($1, $2, $3)
+	`, tokenHash(token), userID, session.ExpiresAt)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(session)
+}
+
+// Logout ends the session the request was made with.
+func Logout(w http.ResponseWriter, r *http.Request) {
+	dbPool := database.GetDbConn()
+	_, err := dbPool.Exec(r.Context(), `
+		UPDATE CUSTOMER_SESSIONS SET REVOKED_AT = NOW() WHERE TOKEN_HASH = $1 AND REVOKED_AT IS NULL
+	`, tokenHash(bearerToken(r)))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Authenticate lets through requests carrying a live session token of an
+// active customer, who CustomerID then returns.
+func Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" {
+			api.Unauthorized(w, "Log in to continue")
+			return
+		}
+
+		var userID int
+		dbPool := database.GetDbConn()
+		err := dbPool.QueryRow(r.Context(), `
+			SELECT s.USER_ID FROM CUSTOMER_SESSIONS s
+			JOIN USERS u ON u.USER_ID = s.USER_ID
+			WHERE s.TOKEN_HASH = $1 AND s.REVOKED_AT IS NULL AND s.EXPIRES_AT > NOW() AND u.IS_ACTIVE
+		`, tokenHash(token)).Scan(&userID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			api.Unauthorized(w, "Session has expired, log in again")
+			return
+		}
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, userID)))
+	})
+}
+
+// customerByMobile finds the active customer with a mobile number.
+func customerByMobile(ctx context.Context, mobile string) (int, string, error) {
+	var userID int
+	var name string
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		SELECT USER_ID, COALESCE(NAME, '') FROM USERS
+		WHERE MOBILE_NO = $1 AND IS_ACTIVE AND MERGED_INTO IS NULL
+	`, mobile).Scan(&userID, &name)
+	return userID, name, err
+}
+
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// newCode returns a random six digit code.
+func newCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// codeHash keeps codes out of the database in plain text. With only a
+// million codes the hash is easily reversed, so it is the short expiry and
+// the cap on attempts that stop codes being guessed.
+func codeHash(userID int, code string) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Tokens are stored hashed so a database dump can't be used to log in
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
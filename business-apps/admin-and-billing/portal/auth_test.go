@@ -0,0 +1,47 @@
+package portal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"testing"
+)
+
+func TestCodeHash(t *testing.T) {
+	sum := sha256.Sum256([]byte("12:004217"))
+	want := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		userID int
+		code   string
+		same   bool
+	}{
+		{"same user and code", 12, "004217", true},
+		{"other code", 12, "004218", false},
+		{"leading zeros matter", 12, "4217", false},
+		{"other user", 13, "004217", false},
+		{"user and code not run together", 1, "2:004217", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := codeHash(tt.userID, tt.code)
+			if (got == want) != tt.same {
+				t.Errorf("codeHash(%d, %q) matches user 12's code = %v, want %v", tt.userID, tt.code, got == want, tt.same)
+			}
+		})
+	}
+}
+
+func TestNewCode(t *testing.T) {
+	sixDigits := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 100; i++ {
+		code, err := newCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sixDigits.MatchString(code) {
+			t.Fatalf("newCode() = %q, want six digits", code)
+		}
+	}
+}
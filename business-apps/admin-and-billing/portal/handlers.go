@@ -0,0 +1,179 @@
+package portal
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/billing"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/wallet"
+)
+
+// How far back a payment can be claimed
+const maxClaimAge = 30
+
+// GetAccount returns the customer's details and wallet balance.
+func GetAccount(w http.ResponseWriter, r *http.Request) {
+	var a model.CustomerAccount
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(r.Context(), `
+		SELECT u.USER_ID, COALESCE(u.NAME, ''), COALESCE(u.MOBILE_NO, ''), COALESCE(u.BUILDING_NO, ''), COALESCE(u.ROOM_NO, ''),
+			USER_PLAN_ON(u.USER_ID, $2), COALESCE(w.BALANCE, 0),
+			COALESCE((SELECT SUM(AMOUNT) FROM WALLET_TRANSACTIONS t
+				WHERE t.USER_ID = u.USER_ID AND t.TXN_TYPE = 'recharge' AND t.STATUS = 'pending_acknowledgement'), 0)
+		FROM USERS u
+		LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
+		WHERE u.USER_ID = $1
+	`, CustomerID(r), database.BusinessDate(time.Now())).Scan(&a.UserID, &a.Name, &a.MobileNo, &a.BuildingNo, &a.RoomNo,
+		&a.Plan, &a.Balance, &a.PendingClaims)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(a)
+}
+
+// GetTransactions lists the customer's wallet transactions, latest first,
+// from ?start_date to ?end_date. The range defaults to the last 90 days.
+func GetTransactions(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	startDate := params.Date("start_date", false)
+	endDate := params.Date("end_date", false)
+	params.DateRange("start_date", startDate, "end_date", endDate)
+	if params.Failed(w) {
+		return
+	}
+	if endDate.IsZero() {
+		endDate = database.BusinessDate(time.Now())
+	}
+	if startDate.IsZero() {
+		startDate = endDate.AddDate(0, 0, -90)
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT TXN_ID, USER_ID, '', TXN_TYPE::TEXT, STATUS::TEXT, AMOUNT, BALANCE_AFTER,
+			COALESCE(REFERENCE_ID, ''), COALESCE(REASON, ''), COALESCE(VALUE_DATE, (CREATED_AT AT TIME ZONE $4)::DATE), CREATED_AT
+		FROM WALLET_TRANSACTIONS
+		WHERE USER_ID = $1 AND COALESCE(VALUE_DATE, (CREATED_AT AT TIME ZONE $4)::DATE) BETWEEN $2 AND $3
+		ORDER BY CREATED_AT DESC, TXN_ID DESC
+	`, CustomerID(r), startDate, endDate, database.BusinessTimezone())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	txns, err := pgx.CollectRows(rows, wallet.ScanTransaction)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(txns)
+}
+
+// GetBill returns the customer's bill for ?start_date to ?end_date, as JSON
+// or with ?format=pdf or html as a document to download.
+func GetBill(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	startDate := params.Date("start_date", true)
+	endDate := params.Date("end_date", true)
+	params.DateRange("start_date", startDate, "end_date", endDate)
+	format := params.String("format", false)
+	params.Check(format == "" || format == "json" || format == "pdf" || format == "html", "format", "must be json, pdf or html")
+	if params.Failed(w) {
+		return
+	}
+
+	billing.ServeBill(w, r, CustomerID(r), startDate, endDate, format)
+}
+
+// SubmitRechargeClaim records a payment the customer says they made. It is
+// credited once an admin has matched it to the money received.
+func SubmitRechargeClaim(w http.ResponseWriter, r *http.Request) {
+	var req model.RechargeClaim
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	req.RefID = strings.TrimSpace(req.RefID)
+	today := database.BusinessDate(time.Now())
+	paidOn := today
+	var v api.Validator
+	if req.PaidOn != "" {
+		paidOn = v.ParseDate("paid_on", req.PaidOn)
+	}
+	v.Check(req.Amount > 0, "amount", "must be positive")
+	v.Check(req.RefID != "", "ref_id", "is required")
+	if v.Failed(w) {
+		return
+	}
+	v.Check(req.Amount <= wallet.MaxRecharge, "amount", "is more than can be paid in one go")
+	v.Check(!paidOn.After(today), "paid_on", "cannot be in the future")
+	v.Check(!paidOn.Before(today.AddDate(0, 0, -maxClaimAge)), "paid_on", "is too long ago, please contact us")
+	if v.Rejected(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// The same payment can't be claimed twice
+	var claimed bool
+	err = tx.QueryRow(r.Context(), `
+		SELECT EXISTS (
+			SELECT 1 FROM WALLET_TRANSACTIONS
+			WHERE USER_ID = $1 AND TXN_TYPE = 'recharge' AND REFERENCE_ID = $2 AND STATUS <> 'rejected'
+		)
+	`, CustomerID(r), req.RefID).Scan(&claimed)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if claimed {
+		api.WriteError(w, http.StatusConflict, api.CodeDuplicateEntry, "This payment has already been submitted")
+		return
+	}
+
+	var txnID int
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, REFERENCE_ID, VALUE_DATE)
+		VALUES ($1, 'recharge', 'pending_acknowledgement', $2, $3, $4)
+		RETURNING TXN_ID
+	`, CustomerID(r), req.Amount, req.RefID, paidOn).Scan(&txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	after, err := audit.Snapshot(r.Context(), tx, "WALLET_TRANSACTIONS", "TXN_ID", txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = audit.Record(r.Context(), tx, source(r), audit.Change{
+		Action: "wallet.claim", EntityType: "wallet_transaction", EntityID: strconv.Itoa(txnID), After: after,
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"txn_id": txnID, "status": "pending_acknowledgement"})
+}
@@ -0,0 +1,199 @@
+package portal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// How far ahead meals can be skipped
+const maxSkipAhead = 30
+
+// cutoff is when it becomes too late to skip or unskip a shift's meal: on
+// the day itself at SKIP_CUTOFF_LUNCH (default 09:00) or SKIP_CUTOFF_DINNER
+// (default 15:00), business time, once the kitchen has planned for it.
+func cutoff(date time.Time, shift string) time.Time {
+	env, fallback := "SKIP_CUTOFF_LUNCH", "09:00"
+	if shift == "dinner" {
+		env, fallback = "SKIP_CUTOFF_DINNER", "15:00"
+	}
+	at, err := time.Parse("15:04", os.Getenv(env))
+	if err != nil {
+		if os.Getenv(env) != "" {
+			log.Printf("Invalid %s, using %s: %v\n", env, fallback, err)
+		}
+		at, _ = time.Parse("15:04", fallback)
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), at.Hour(), at.Minute(), 0, 0, database.BusinessLocation())
+}
+
+// GetSkips lists the customer's skipped meals from today on.
+func GetSkips(w http.ResponseWriter, r *http.Request) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT SKIP_DATE, SHIFT::TEXT FROM USER_SKIP
+		WHERE USER_ID = $1 AND SKIP_DATE >= $2
+		ORDER BY SKIP_DATE, SHIFT
+	`, CustomerID(r), database.BusinessDate(time.Now()))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	skips, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.Skip, error) {
+		var s model.Skip
+		err := row.Scan(&s.Date, &s.Shift)
+		return s, err
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(skips)
+}
+
+// SkipMeal tells the kitchen not to prepare the customer's meal for a shift.
+// Skipping a meal that is already skipped changes nothing.
+func SkipMeal(w http.ResponseWriter, r *http.Request) {
+	var req model.SkipRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	skip, ok := checkSkip(w, req.Date, req.Shift)
+	if !ok {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var logged bool
+	err = tx.QueryRow(r.Context(), `
+		SELECT EXISTS (
+			SELECT 1 FROM DAILY_LOGS
+			WHERE USER_ID = $1 AND LOG_DATE = $2 AND MEAL_TYPE = $3 AND DELETED_AT IS NULL
+		)
+	`, CustomerID(r), skip.Date, skip.Shift).Scan(&logged)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if logged {
+		api.Conflict(w, "This meal has already been delivered")
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), `
+		INSERT INTO USER_SKIP (USER_ID, SKIP_DATE, SHIFT) VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, CustomerID(r), skip.Date, skip.Shift)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := recordSkip(r, tx, "skip.create", skip); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// CancelSkip brings back a skipped meal, up to the same cut-off.
+func CancelSkip(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	date := params.String("date", true)
+	shift := params.String("shift", true)
+	if params.Failed(w) {
+		return
+	}
+	skip, ok := checkSkip(w, date, shift)
+	if !ok {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	tag, err := tx.Exec(r.Context(), `
+		DELETE FROM USER_SKIP WHERE USER_ID = $1 AND SKIP_DATE = $2 AND SHIFT = $3
+	`, CustomerID(r), skip.Date, skip.Shift)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		api.NotFound(w, "This meal is not skipped")
+		return
+	}
+	if err := recordSkip(r, tx, "skip.cancel", skip); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkSkip parses a skip's date and shift and checks it is still open to
+// change. Otherwise it writes a 400 or 422 and returns false.
+func checkSkip(w http.ResponseWriter, date, shift string) (model.Skip, bool) {
+	var v api.Validator
+	skip := model.Skip{Date: v.ParseDate("date", date), Shift: shift}
+	v.Check(shift == "lunch" || shift == "dinner", "shift", "must be lunch or dinner")
+	if v.Failed(w) {
+		return skip, false
+	}
+
+	today := database.BusinessDate(time.Now())
+	v.Check(!skip.Date.After(today.AddDate(0, 0, maxSkipAhead)), "date", fmt.Sprintf("cannot be more than %d days ahead", maxSkipAhead))
+	v.Check(time.Now().Before(cutoff(skip.Date, shift)), "date", "is past the cut-off for changing this meal")
+	return skip, !v.Rejected(w)
+}
+
+func recordSkip(r *http.Request, tx pgx.Tx, action string, skip model.Skip) error {
+	data, err := json.Marshal(map[string]interface{}{"user_id": CustomerID(r), "date": skip.Date.Format("2006-01-02"), "shift": skip.Shift})
+	if err != nil {
+		return err
+	}
+	c := audit.Change{
+		Action: action, EntityType: "user_skip",
+		EntityID: fmt.Sprintf("%d/%s/%s", CustomerID(r), skip.Date.Format("2006-01-02"), skip.Shift),
+	}
+	if action == "skip.cancel" {
+		c.Before = data
+	} else {
+		c.After = data
+	}
+	return audit.Record(r.Context(), tx, source(r), c)
+}
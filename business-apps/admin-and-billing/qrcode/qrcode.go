@@ -0,0 +1,466 @@
+// Package qrcode encodes short text (such as UPI payment links) as a QR code
+// using byte mode and error correction level M, versions 1 to 10.
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrTooLong = errors.New("qrcode: text too long")
+
+// Code is an encoded QR symbol. Modules are indexed [row][column], true is dark.
+type Code struct {
+	Size    int
+	modules [][]bool
+}
+
+// Dark reports whether the module at column x, row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// SVG renders the code with a 4 module quiet zone, each module scale units wide.
+func (c *Code) SVG(scale int) string {
+	full := (c.Size + 8) * scale
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, full, full, full, full)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, full, full)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				fmt.Fprintf(&b, "M%d %dh%dv%dh-%dz", (x+4)*scale, (y+4)*scale, scale, scale, scale)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
+
+// Block structure for error correction level M
+type versionInfo struct {
+	ecPerBlock int
+	groups     [][2]int // {block count, data codewords per block}
+	alignment  []int
+}
+
+var versions = []versionInfo{
+	1:  {10, [][2]int{{1, 16}}, nil},
+	2:  {16, [][2]int{{1, 28}}, []int{6, 18}},
+	3:  {26, [][2]int{{1, 44}}, []int{6, 22}},
+	4:  {18, [][2]int{{2, 32}}, []int{6, 26}},
+	5:  {24, [][2]int{{2, 43}}, []int{6, 30}},
+	6:  {16, [][2]int{{4, 27}}, []int{6, 34}},
+	7:  {18, [][2]int{{4, 31}}, []int{6, 22, 38}},
+	8:  {22, [][2]int{{2, 38}, {2, 39}}, []int{6, 24, 42}},
+	9:  {22, [][2]int{{3, 36}, {2, 37}}, []int{6, 26, 46}},
+	10: {26, [][2]int{{4, 43}, {1, 44}}, []int{6, 28, 50}},
+}
+
+func (v versionInfo) dataCodewords() int {
+	n := 0
+	for _, g := range v.groups {
+		n += g[0] * g[1]
+	}
+	return n
+}
+
+// Encode builds the smallest QR code that holds text.
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(versions); v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*versions[v].dataCodewords() {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	codewords := interleave(versions[version], encodeData(data, version))
+
+	q := newMatrix(version)
+	q.drawFunctionPatterns(version)
+	q.drawCodewords(codewords)
+
+	best, bestPenalty := -1, 0
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if p := q.penalty(); best < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // XOR again to undo
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return &Code{Size: q.size, modules: q.modules}, nil
+}
+
+// encodeData produces the padded data codewords in byte mode.
+func encodeData(data []byte, version int) []byte {
+	var bits bitBuffer
+	bits.append(0b0100, 4)
+	if version >= 10 {
+		bits.append(len(data), 16)
+	} else {
+		bits.append(len(data), 8)
+	}
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+
+	capacity := versions[version].dataCodewords() * 8
+	bits.append(0, min(4, capacity-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	out := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			out[i>>3] |= 1 << (7 - uint(i&7))
+		}
+	}
+	return out
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 == 1)
+	}
+}
+
+// interleave splits data into blocks, appends error correction to each and
+// interleaves them as the symbol requires.
+func interleave(v versionInfo, data []byte) []byte {
+	var blocks, ecBlocks [][]byte
+	offset := 0
+	for _, g := range v.groups {
+		for i := 0; i < g[0]; i++ {
+			block := data[offset : offset+g[1]]
+			offset += g[1]
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, reedSolomon(block, v.ecPerBlock))
+		}
+	}
+
+	var out []byte
+	longest := v.groups[len(v.groups)-1][1]
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				out = append(out, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ecPerBlock; i++ {
+		for _, block := range ecBlocks {
+			out = append(out, block[i])
+		}
+	}
+	return out
+}
+
+// Galois field arithmetic over GF(256) with polynomial 0x11D
+var gfExp, gfLog [512]int
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b int) int {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func reedSolomon(data []byte, degree int) []byte {
+	// Generator polynomial (x - a^0)(x - a^1)...(x - a^(degree-1)),
+	// coefficients from highest to lowest power excluding the leading 1
+	gen := make([]int, degree)
+	gen[degree-1] = 1
+	root := 1
+	for i := 0; i < degree; i++ {
+		for j := 0; j < degree; j++ {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < degree {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+
+	rem := make([]int, degree)
+	for _, b := range data {
+		factor := int(b) ^ rem[0]
+		copy(rem, rem[1:])
+		rem[degree-1] = 0
+		for i := range rem {
+			rem[i] ^= gfMul(gen[i], factor)
+		}
+	}
+
+	out := make([]byte, degree)
+	for i, r := range rem {
+		out[i] = byte(r)
+	}
+	return out
+}
+
+type matrix struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+func newMatrix(version int) *matrix {
+	size := 17 + 4*version
+	q := &matrix{size: size}
+	q.modules = make([][]bool, size)
+	q.isFunction = make([][]bool, size)
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.isFunction[i] = make([]bool, size)
+	}
+	return q
+}
+
+func (q *matrix) set(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+func (q *matrix) drawFunctionPatterns(version int) {
+	// Timing patterns
+	for i := 0; i < q.size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	for _, c := range [][2]int{{3, 3}, {q.size - 4, 3}, {3, q.size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || x >= q.size || y < 0 || y >= q.size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				q.set(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Alignment patterns, except where they would overlap the finders
+	pos := versions[version].alignment
+	last := len(pos) - 1
+	for i, cy := range pos {
+		for j, cx := range pos {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// Reserve the format areas, drawn for real once the mask is chosen
+	q.drawFormatBits(0)
+
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 == 1
+			a, b := q.size-11+i%3, i/3
+			q.set(a, b, dark)
+			q.set(b, a, dark)
+		}
+	}
+}
+
+func (q *matrix) drawFormatBits(mask int) {
+	// Level M has format bits 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true) // dark module
+}
+
+func (q *matrix) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>(7-uint(i&7)))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *matrix) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert {
+				q.modules[y][x] = !q.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores a masked symbol; the mask with the lowest score is used.
+func (q *matrix) penalty() int {
+	n := q.size
+	at := func(x, y int, transpose bool) bool {
+		if transpose {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	score := 0
+	for _, transpose := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Runs of five or more modules of the same colour
+			run := 1
+			for x := 1; x < n; x++ {
+				if at(x, y, transpose) == at(x-1, y, transpose) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += 3 + run - 5
+			}
+
+			// Patterns that look like a finder
+			for x := 0; x+10 < n; x++ {
+				var line [11]bool
+				for k := range line {
+					line[k] = at(x+k, y, transpose)
+				}
+				if line == [11]bool{true, false, true, true, true, false, true, false, false, false, false} ||
+					line == [11]bool{false, false, false, false, true, false, true, true, true, false, true} {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			// 2x2 blocks of the same colour
+			if x+1 < n && y+1 < n {
+				c := q.modules[y][x]
+				if c == q.modules[y][x+1] && c == q.modules[y+1][x] && c == q.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// Deviation from a 50% dark ratio
+	percent := dark * 100 / (n * n)
+	score += abs(percent-50) / 5 * 10
+
+	return score
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
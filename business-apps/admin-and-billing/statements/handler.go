@@ -0,0 +1,248 @@
+package statements
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/billing"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+var ErrPeriodLocked = errors.New("billing period is locked")
+
+// PeriodLocked reports whether date falls in a billing period whose
+// statements have been generated. Entries and wallet movements dated in a
+// locked period must not change.
+//
+// It waits for any billing run in progress, and holds that run off until tx
+// ends, so nothing can land in a period while its statements are computed.
+func PeriodLocked(ctx context.Context, tx pgx.Tx, date time.Time) (bool, error) {
+	// In a statement of its own, so the check below sees a run that has just
+	// committed
+	if _, err := tx.Exec(ctx, `SELECT PG_ADVISORY_XACT_LOCK_SHARED(HASHTEXT('BILLING_PERIODS'))`); err != nil {
+		return false, err
+	}
+	var locked bool
+	err := tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM BILLING_PERIODS WHERE $1::DATE BETWEEN PERIOD_START AND PERIOD_END
+		)
+	`, date).Scan(&locked)
+	return locked, err
+}
+
+// StartBillingCycleJob generates last month's statements on the 1st of each
+// month, checking every hour until ctx is cancelled. Months missed while the
+// server was down are left to RunBillingCycle.
+func StartBillingCycleJob(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			now := time.Now().In(database.BusinessLocation())
+			if now.Day() == 1 {
+				periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+				count, failed, err := GenerateStatements(ctx, audit.System, periodStart)
+				if err != nil && !errors.Is(err, ErrPeriodLocked) {
+					log.Printf("Billing cycle for %s failed: %v\n", periodStart.Format("2006-01"), err)
+				} else if err == nil {
+					log.Printf("Generated %d statements for %s, %d customers failed\n", count, periodStart.Format("2006-01"), len(failed))
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// GenerateStatements stores a statement for every user for the month starting
+// at periodStart and locks the period, returning how many were stored and
+// the users whose bill could not be built. Those are logged and left without
+// a statement rather than holding up everyone else's. It returns
+// ErrPeriodLocked if the period has already been billed. src is recorded as
+// having locked it.
+func GenerateStatements(ctx context.Context, src audit.Source, periodStart time.Time) (int, []int, error) {
+	periodEnd := periodStart.AddDate(0, 1, -1)
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Wait for writers that have checked PeriodLocked to finish, and keep
+	// new ones waiting until the period is locked, so the bills below are
+	// final
+	if _, err := tx.Exec(ctx, `SELECT PG_ADVISORY_XACT_LOCK(HASHTEXT('BILLING_PERIODS'))`); err != nil {
+		return 0, nil, err
+	}
+
+	// Claim the period first so two runs cannot bill it twice
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO BILLING_PERIODS (PERIOD_START, PERIOD_END) VALUES ($1, $2)
+		ON CONFLICT (PERIOD_START) DO NOTHING
+	`, periodStart, periodEnd)
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return 0, nil, ErrPeriodLocked
+	}
+
+	rows, err := tx.Query(ctx, `SELECT USER_ID FROM USERS ORDER BY USER_ID`)
+	if err != nil {
+		return 0, nil, err
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var count int
+	var failed []int
+	for _, userID := range userIDs {
+		// Each bill is built under a savepoint, so one that fails doesn't
+		// abort the transaction for everyone after it
+		sp, err := tx.Begin(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		bill, err := billing.BuildBill(ctx, sp, userID, periodStart, periodEnd)
+		if err != nil {
+			log.Printf("Statement for user %d for %s failed: %v\n", userID, periodStart.Format("2006-01"), err)
+			if err := sp.Rollback(ctx); err != nil {
+				return 0, nil, err
+			}
+			failed = append(failed, userID)
+			continue
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return 0, nil, err
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO STATEMENTS (STATEMENT_NO, USER_ID, PERIOD_START, PERIOD_END, OPENING_BALANCE, TOTAL_SPENT, TOTAL_RECHARGES, OTHER_CREDITS, CLOSING_BALANCE, MEAL_COUNT)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		`, statementNo(periodStart, userID), userID, periodStart, periodEnd,
+			bill.OpeningBalance, bill.TotalSpent, bill.TotalRecharges,
+			bill.TotalAdjustments+bill.TotalWriteOffs+bill.TotalTransfersIn-bill.TotalTransfersOut+bill.TotalSettlements+bill.TotalBroughtForward,
+			bill.ClosingBalance, len(bill.Logs))
+		if err != nil {
+			return 0, nil, fmt.Errorf("user %d: %w", userID, err)
+		}
+		count++
+	}
+
+	after, err := audit.Snapshot(ctx, tx, "BILLING_PERIODS", "PERIOD_START", periodStart)
+	if err != nil {
+		return 0, nil, err
+	}
+	err = audit.Record(ctx, tx, src, audit.Change{
+		Action: "billing_period.lock", EntityType: "billing_period", EntityID: periodStart.Format("2006-01"), After: after,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	return count, failed, nil
+}
+
+// statementNo is unique per user and month, e.g. ST-202609-00042.
+func statementNo(periodStart time.Time, userID int) string {
+	return fmt.Sprintf("ST-%s-%05d", periodStart.Format("200601"), userID)
+}
+
+func GetStatements(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	userID := params.Int("user_id", false)
+	period := params.Month("period", false)
+	if params.Failed(w) {
+		return
+	}
+
+	query := `
+		SELECT STATEMENT_ID, STATEMENT_NO, USER_ID, PERIOD_START, PERIOD_END, OPENING_BALANCE, TOTAL_SPENT, TOTAL_RECHARGES, OTHER_CREDITS, CLOSING_BALANCE, MEAL_COUNT, GENERATED_AT
+		FROM STATEMENTS
+		WHERE TRUE
+	`
+	var args []interface{}
+	if userID != 0 {
+		args = append(args, userID)
+		query += fmt.Sprintf(" AND USER_ID = $%d", len(args))
+	}
+	if !period.IsZero() {
+		args = append(args, period)
+		query += fmt.Sprintf(" AND PERIOD_START = $%d", len(args))
+	}
+	query += " ORDER BY PERIOD_START DESC, USER_ID ASC"
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), query, args...)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	var statements []model.Statement
+	for rows.Next() {
+		var s model.Statement
+		err := rows.Scan(&s.StatementID, &s.StatementNo, &s.UserID, &s.PeriodStart, &s.PeriodEnd, &s.OpeningBalance, &s.TotalSpent, &s.TotalRecharges, &s.OtherCredits, &s.ClosingBalance, &s.MealCount, &s.GeneratedAt)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		statements = append(statements, s)
+	}
+
+	json.NewEncoder(w).Encode(statements)
+}
+
+// RunBillingCycle generates statements for ?period=YYYY-MM, defaulting to the
+// previous month.
+func RunBillingCycle(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().In(database.BusinessLocation())
+	periodStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	params := api.Query(r)
+	if params.Has("period") {
+		periodStart = params.Month("period", true)
+		params.Check(periodStart.IsZero() || periodStart.AddDate(0, 1, 0).Before(now), "period", "has not ended yet")
+	}
+	if params.Failed(w) {
+		return
+	}
+
+	count, failed, err := GenerateStatements(r.Context(), audit.FromRequest(r), periodStart)
+	if errors.Is(err, ErrPeriodLocked) {
+		api.Conflict(w, err.Error())
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if failed == nil {
+		failed = []int{}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"period": periodStart.Format("2006-01"), "statements": count, "failed_user_ids": failed})
+}
@@ -0,0 +1,222 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+const (
+	// A customer is active if they had a meal in this many days up to today
+	activeDays = 30
+	// Customers churn after this many days without a meal unless ?days says
+	// otherwise
+	defaultChurnDays = 14
+	maxChurnDays     = 365
+	maxCohortMonths  = 36
+)
+
+// GetCohorts groups customers by the month of their first meal, from ?from
+// to ?to (YYYY-MM, default the 12 months up to this one), with how many of
+// each cohort had a meal in every month since.
+func GetCohorts(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	from := params.Month("from", false)
+	to := params.Month("to", false)
+	params.DateRange("from", from, "to", to)
+	if params.Failed(w) {
+		return
+	}
+	today := database.BusinessDate(time.Now())
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if to.IsZero() || to.After(thisMonth) {
+		to = thisMonth
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, -11, 0)
+	}
+	params.Check(monthsBetween(from, to) < maxCohortMonths, "from", fmt.Sprintf("range cannot be longer than %d months", maxCohortMonths))
+	params.Check(!from.After(to), "from", "must not be after the current month")
+	if params.Failed(w) {
+		return
+	}
+
+	report, err := cohorts(r.Context(), from, to, thisMonth)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func cohorts(ctx context.Context, from, to, thisMonth time.Time) (model.CohortReport, error) {
+	report := model.CohortReport{From: from, To: to, Cohorts: []model.Cohort{}, Curve: []float64{}}
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		WITH FIRSTS AS (
+			SELECT USER_ID, DATE_TRUNC('month', MIN(LOG_DATE))::DATE AS COHORT
+			FROM DAILY_LOGS
+			WHERE DELETED_AT IS NULL
+			GROUP BY USER_ID
+		)
+		SELECT f.COHORT, DATE_TRUNC('month', l.LOG_DATE)::DATE, COUNT(DISTINCT f.USER_ID)
+		FROM FIRSTS f
+		JOIN DAILY_LOGS l ON l.USER_ID = f.USER_ID AND l.DELETED_AT IS NULL
+		WHERE f.COHORT BETWEEN $1 AND $2
+		GROUP BY 1, 2
+		ORDER BY 1, 2
+	`, from, to)
+	if err != nil {
+		return report, err
+	}
+	type cell struct {
+		cohort, month time.Time
+		customers     int
+	}
+	cells, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (cell, error) {
+		var c cell
+		err := row.Scan(&c.cohort, &c.month, &c.customers)
+		return c, err
+	})
+	if err != nil {
+		return report, err
+	}
+
+	index := make(map[string]int)
+	for month := from; !month.After(to); month = month.AddDate(0, 1, 0) {
+		index[month.Format("2006-01")] = len(report.Cohorts)
+		report.Cohorts = append(report.Cohorts, model.Cohort{
+			Month:    month,
+			Retained: make([]int, monthsBetween(month, thisMonth)+1),
+		})
+	}
+	for _, c := range cells {
+		cohort := &report.Cohorts[index[c.cohort.Format("2006-01")]]
+		if offset := monthsBetween(c.cohort, c.month); offset < len(cohort.Retained) {
+			cohort.Retained[offset] = c.customers
+		}
+	}
+
+	var retained, reached []int
+	for i := range report.Cohorts {
+		cohort := &report.Cohorts[i]
+		cohort.Customers = cohort.Retained[0]
+		cohort.RetentionPct = make([]float64, len(cohort.Retained))
+		if cohort.Customers == 0 {
+			continue
+		}
+		for offset, n := range cohort.Retained {
+			cohort.RetentionPct[offset] = round1(float64(n) / float64(cohort.Customers) * 100)
+			if offset == len(retained) {
+				retained = append(retained, 0)
+				reached = append(reached, 0)
+			}
+			retained[offset] += n
+			reached[offset] += cohort.Customers
+		}
+	}
+	for offset := range retained {
+		report.Curve = append(report.Curve, round1(float64(retained[offset])/float64(reached[offset])*100))
+	}
+	return report, nil
+}
+
+// monthsBetween counts whole calendar months from one month to another.
+func monthsBetween(from, to time.Time) int {
+	return (to.Year()-from.Year())*12 + int(to.Month()-from.Month())
+}
+
+// GetChurn lists signed-up customers whose last meal was at least ?days days
+// ago (default 14), most valuable first, with the monthly revenue they put
+// at risk and the balance they still hold.
+func GetChurn(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	days := params.Int("days", false)
+	if days == 0 {
+		days = defaultChurnDays
+	}
+	params.Check(days <= maxChurnDays, "days", fmt.Sprintf("must be at most %d", maxChurnDays))
+	if params.Failed(w) {
+		return
+	}
+
+	ctx := r.Context()
+	today := database.BusinessDate(time.Now())
+	report := model.ChurnReport{Days: days}
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		WITH LAST_MEALS AS (
+			SELECT USER_ID, MAX(LOG_DATE) AS LAST_MEAL
+			FROM DAILY_LOGS
+			WHERE DELETED_AT IS NULL
+			GROUP BY USER_ID
+		)
+		SELECT
+			COUNT(*) FILTER (WHERE l.LAST_MEAL > $1),
+			COUNT(*) FILTER (WHERE l.LAST_MEAL IS NULL)
+		FROM USERS u
+		LEFT JOIN LAST_MEALS l ON l.USER_ID = u.USER_ID
+		WHERE u.IS_ACTIVE AND u.MERGED_INTO IS NULL
+	`, today.AddDate(0, 0, -days)).Scan(&report.ActiveCustomers, &report.NeverOrdered)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		WITH MEALS AS (
+			SELECT USER_ID, MIN(LOG_DATE) AS FIRST_MEAL, MAX(LOG_DATE) AS LAST_MEAL
+			FROM DAILY_LOGS
+			WHERE DELETED_AT IS NULL
+			GROUP BY USER_ID
+		)
+		SELECT u.USER_ID, COALESCE(u.NAME, ''), COALESCE(u.MOBILE_NO, ''), COALESCE(u.BUILDING_NO, ''), COALESCE(u.ROOM_NO, ''),
+			m.LAST_MEAL, $2::DATE - m.LAST_MEAL, COALESCE(w.BALANCE, 0),
+			ROUND(COALESCE((
+				SELECT SUM(d.TOTAL_COST) FROM DAILY_LOGS d
+				WHERE d.USER_ID = u.USER_ID AND d.DELETED_AT IS NULL AND d.LOG_DATE > m.LAST_MEAL - 90
+			), 0) / LEAST(90, m.LAST_MEAL - m.FIRST_MEAL + 1) * 30, 2) AS MONTHLY_SPEND
+		FROM USERS u
+		JOIN MEALS m ON m.USER_ID = u.USER_ID
+		LEFT JOIN WALLET w ON w.USER_ID = u.USER_ID
+		WHERE u.IS_ACTIVE AND u.MERGED_INTO IS NULL AND m.LAST_MEAL <= $1
+		ORDER BY MONTHLY_SPEND DESC, m.LAST_MEAL, u.USER_ID
+	`, today.AddDate(0, 0, -days), today)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	report.Customers, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.ChurnedCustomer, error) {
+		var c model.ChurnedCustomer
+		err := row.Scan(&c.UserID, &c.Name, &c.MobileNo, &c.BuildingNo, &c.RoomNo,
+			&c.LastMeal, &c.DaysSince, &c.Balance, &c.MonthlySpend)
+		return c, err
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if report.Customers == nil {
+		report.Customers = []model.ChurnedCustomer{}
+	}
+
+	for _, c := range report.Customers {
+		report.RevenueAtRisk += c.MonthlySpend
+		report.BalanceHeld += c.Balance
+	}
+	report.ChurnedCustomers = len(report.Customers)
+	report.RevenueAtRisk = round2(report.RevenueAtRisk)
+	report.BalanceHeld = round2(report.BalanceHeld)
+	if ordered := report.ChurnedCustomers + report.ActiveCustomers; ordered > 0 {
+		report.ChurnRatePct = round1(float64(report.ChurnedCustomers) / float64(ordered) * 100)
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
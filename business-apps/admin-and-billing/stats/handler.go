@@ -1,10 +1,14 @@
 package stats
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"math"
 	"net/http"
 	"time"
 
+	"github.com/soumalya/food-delivery-admin/api"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/model"
 )
@@ -23,9 +27,10 @@ func GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 			COALESCE(SUM(TOTAL_COST), 0),
 			COALESCE(SUM(CASE WHEN LOG_DATE >= $1 THEN TOTAL_COST ELSE 0 END), 0)
 		FROM DAILY_LOGS
+		WHERE DELETED_AT IS NULL
 	`, firstOfMonth).Scan(&stats.TotalRevenue, &stats.MonthlyRevenue)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
@@ -37,129 +42,235 @@ func GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 		FROM EXPENSES
 	`, firstOfMonth).Scan(&stats.TotalExpenses, &stats.MonthlyExpenses)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
-	// 3. Profit
-	stats.NetProfit = stats.TotalRevenue - stats.TotalExpenses
+	// 3. Adjustments & Write-offs
+	err = dbPool.QueryRow(ctx, `
+		SELECT 
+			COALESCE(SUM(CASE WHEN TXN_TYPE = 'adjustment' THEN AMOUNT ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN TXN_TYPE = 'write_off' THEN AMOUNT ELSE 0 END), 0)
+		FROM WALLET_TRANSACTIONS
+		WHERE TXN_TYPE IN ('adjustment', 'write_off') AND STATUS = 'confirmed'
+	`).Scan(&stats.TotalAdjustments, &stats.TotalWriteOffs)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	// 4. Profit
+	// Credits given away and forgiven dues are revenue that was never collected
+	stats.NetProfit = stats.TotalRevenue - stats.TotalExpenses - stats.TotalAdjustments - stats.TotalWriteOffs
 
-	// 4. Active Customers Count
-	err = dbPool.QueryRow(ctx, `SELECT COUNT(*) FROM USERS`).Scan(&stats.ActiveCustomers)
+	// 5. Customers, and those who have eaten recently
+	err = dbPool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM USERS WHERE IS_ACTIVE AND MERGED_INTO IS NULL),
+			(SELECT COUNT(DISTINCT l.USER_ID) FROM DAILY_LOGS l
+			 JOIN USERS u ON u.USER_ID = l.USER_ID
+			 WHERE l.LOG_DATE > $1 AND l.DELETED_AT IS NULL AND u.IS_ACTIVE AND u.MERGED_INTO IS NULL)
+	`, database.BusinessDate(now).AddDate(0, 0, -activeDays)).Scan(&stats.RegisteredCustomers, &stats.ActiveCustomers)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
-	// 5. Wallet Pool
+	// 6. Wallet Pool
 	err = dbPool.QueryRow(ctx, `SELECT COALESCE(SUM(BALANCE), 0) FROM WALLET`).Scan(&stats.WalletPool)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(stats)
 }
 
+// Longest range analytics can be asked for, and the longest with daily points
+const (
+	maxAnalyticsDays = 3 * 366
+	maxDailyDays     = 366
+)
+
+// GetAnalyticsStats reports revenue, expenses and meals from ?from to ?to
+// (default the 30 days up to today) with trend points per ?granularity (day,
+// week or month; default day), compared with the same number of days
+// before.
 func GetAnalyticsStats(w http.ResponseWriter, r *http.Request) {
-	var stats model.AnalyticsStats
-	ctx := r.Context()
-	now := time.Now()
-	startDate := now.AddDate(0, 0, -30)
+	params := api.Query(r)
+	granularity := params.String("granularity", false)
+	if granularity == "" {
+		granularity = "day"
+	}
+	params.Check(granularity == "day" || granularity == "week" || granularity == "month", "granularity", "must be day, week or month")
+	from, to, days := dateRange(params)
+	params.Check(granularity != "day" || days <= maxDailyDays, "granularity", fmt.Sprintf("must be week or month for ranges longer than %d days", maxDailyDays))
+	if params.Failed(w) {
+		return
+	}
 
-	// 1. Revenue Trends (last 30 days)
-	dbPool := database.GetDbConn()
-	revenueRows, err := dbPool.Query(ctx, `
-		SELECT LOG_DATE, SUM(TOTAL_COST) 
-		FROM DAILY_LOGS 
-		WHERE LOG_DATE >= $1 
-		GROUP BY LOG_DATE 
-		ORDER BY LOG_DATE ASC
-	`, startDate)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	ctx := r.Context()
+	stats := model.AnalyticsStats{Granularity: granularity}
+	var err error
+	if stats.AnalyticsPeriod, err = periodTotals(ctx, from, to); err != nil {
+		api.InternalError(w, err)
 		return
 	}
-	defer revenueRows.Close()
-
-	revenueMap := make(map[string]float64)
-	for revenueRows.Next() {
-		var date time.Time
-		var amount float64
-		revenueRows.Scan(&date, &amount)
-		revenueMap[date.Format("2006-01-02")] = amount
-		stats.TotalRevenue += amount
-	}
-
-	// 2. Expense Trends (last 30 days)
-	expenseRows, err := dbPool.Query(ctx, `
-		SELECT EXPENSE_DATE, SUM(AMOUNT) 
-		FROM EXPENSES 
-		WHERE EXPENSE_DATE >= $1 
-		GROUP BY EXPENSE_DATE 
-		ORDER BY EXPENSE_DATE ASC
-	`, startDate)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if stats.Previous, err = periodTotals(ctx, from.AddDate(0, 0, -days), from.AddDate(0, 0, -1)); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if stats.Trends, err = trends(ctx, from, to, granularity); err != nil {
+		api.InternalError(w, err)
 		return
 	}
-	defer expenseRows.Close()
 
-	expenseMap := make(map[string]float64)
-	for expenseRows.Next() {
-		var date time.Time
-		var amount float64
-		expenseRows.Scan(&date, &amount)
-		expenseMap[date.Format("2006-01-02")] = amount
-		stats.TotalExpenses += amount
+	cur, prev := stats.AnalyticsPeriod, stats.Previous
+	stats.Change = model.AnalyticsChange{
+		RevenuePct:   pctChange(cur.TotalRevenue, prev.TotalRevenue),
+		ExpensesPct:  pctChange(cur.TotalExpenses, prev.TotalExpenses),
+		MealsPct:     pctChange(float64(cur.Shifts["Lunch"]+cur.Shifts["Dinner"]), float64(prev.Shifts["Lunch"]+prev.Shifts["Dinner"])),
+		ProfitPoints: math.Round((cur.ProfitPercentage-prev.ProfitPercentage)*10) / 10,
 	}
 
-	// Fill in Trends for each of the last 30 days
-	for i := 0; i <= 30; i++ {
-		d := startDate.AddDate(0, 0, i).Format("2006-01-02")
-		stats.Trends = append(stats.Trends, model.TrendPoint{
-			Date:     d,
-			Revenue:  revenueMap[d],
-			Expenses: expenseMap[d],
-		})
+	json.NewEncoder(w).Encode(stats)
+}
+
+// dateRange reads ?from and ?to, defaulting to the 30 days up to today, and
+// returns the number of days they span.
+func dateRange(params *api.Params) (from, to time.Time, days int) {
+	from = params.Date("from", false)
+	to = params.Date("to", false)
+	params.DateRange("from", from, "to", to)
+	if to.IsZero() {
+		to = database.BusinessDate(time.Now())
+	}
+	if from.IsZero() {
+		from = to.AddDate(0, 0, -29)
 	}
+	days = int(to.Sub(from).Hours()/24) + 1
+	params.Check(days <= maxAnalyticsDays, "from", fmt.Sprintf("range cannot be longer than %d days", maxAnalyticsDays))
+	return from, to, days
+}
 
-	// 3. Meal Type Distribution
-	stats.MealTypes = make(map[string]int)
-	var standardCount, specialCount int
-	err = dbPool.QueryRow(ctx, `
-		SELECT 
-			COUNT(CASE WHEN IS_SPECIAL = false THEN 1 END),
-			COUNT(CASE WHEN IS_SPECIAL = true THEN 1 END)
+// periodTotals sums up meals, revenue and expenses from one date to another.
+func periodTotals(ctx context.Context, from, to time.Time) (model.AnalyticsPeriod, error) {
+	p := model.AnalyticsPeriod{From: from, To: to}
+	var standard, special, lunch, dinner int
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(TOTAL_COST), 0),
+			COUNT(*) FILTER (WHERE NOT IS_SPECIAL),
+			COUNT(*) FILTER (WHERE IS_SPECIAL),
+			COUNT(*) FILTER (WHERE MEAL_TYPE = 'lunch'),
+			COUNT(*) FILTER (WHERE MEAL_TYPE = 'dinner')
 		FROM DAILY_LOGS
-	`).Scan(&standardCount, &specialCount)
+		WHERE LOG_DATE BETWEEN $1 AND $2 AND DELETED_AT IS NULL
+	`, from, to).Scan(&p.TotalRevenue, &standard, &special, &lunch, &dinner)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return p, err
 	}
-	stats.MealTypes["Standard"] = standardCount
-	stats.MealTypes["Special"] = specialCount
+	p.MealTypes = map[string]int{"Standard": standard, "Special": special}
+	p.Shifts = map[string]int{"Lunch": lunch, "Dinner": dinner}
 
-	// 4. Shift Distribution
-	stats.Shifts = make(map[string]int)
-	var lunchCount, dinnerCount int
 	err = dbPool.QueryRow(ctx, `
-		SELECT 
-			COUNT(CASE WHEN MEAL_TYPE = 'lunch' THEN 1 END),
-			COUNT(CASE WHEN MEAL_TYPE = 'dinner' THEN 1 END)
-		FROM DAILY_LOGS
-	`).Scan(&lunchCount, &dinnerCount)
+		SELECT COALESCE(SUM(AMOUNT), 0) FROM EXPENSES WHERE EXPENSE_DATE BETWEEN $1 AND $2
+	`, from, to).Scan(&p.TotalExpenses)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return p, err
 	}
-	stats.Shifts["Lunch"] = lunchCount
-	stats.Shifts["Dinner"] = dinnerCount
 
-	// 5. Profit Percentage
-	if stats.TotalRevenue > 0 {
-		stats.ProfitPercentage = ((stats.TotalRevenue - stats.TotalExpenses) / stats.TotalRevenue) * 100
+	if p.TotalRevenue > 0 {
+		p.ProfitPercentage = ((p.TotalRevenue - p.TotalExpenses) / p.TotalRevenue) * 100
 	}
+	return p, nil
+}
 
-	json.NewEncoder(w).Encode(stats)
+// trends returns a point for every day, week or month of the range, including
+// those without any meals or expenses.
+func trends(ctx context.Context, from, to time.Time, granularity string) ([]model.TrendPoint, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		WITH DAYS AS (
+			SELECT LOG_DATE AS DAY, TOTAL_COST AS REVENUE, 0 AS EXPENSES
+			FROM DAILY_LOGS
+			WHERE LOG_DATE BETWEEN $1 AND $2 AND DELETED_AT IS NULL
+			UNION ALL
+			SELECT EXPENSE_DATE, 0, AMOUNT
+			FROM EXPENSES
+			WHERE EXPENSE_DATE BETWEEN $1 AND $2
+		)
+		SELECT DATE_TRUNC($3, DAY::TIMESTAMP)::DATE, SUM(REVENUE), SUM(EXPENSES)
+		FROM DAYS
+		GROUP BY 1
+	`, from, to, granularity)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type sums struct{ revenue, expenses float64 }
+	buckets := make(map[string]sums)
+	for rows.Next() {
+		var start time.Time
+		var s sums
+		if err := rows.Scan(&start, &s.revenue, &s.expenses); err != nil {
+			return nil, err
+		}
+		buckets[start.Format("2006-01-02")] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	points := []model.TrendPoint{}
+	for start := bucketStart(from, granularity); !start.After(to); start = nextBucket(start, granularity) {
+		s := buckets[start.Format("2006-01-02")]
+		first, last := start, nextBucket(start, granularity).AddDate(0, 0, -1)
+		if first.Before(from) {
+			first = from
+		}
+		if last.After(to) {
+			last = to
+		}
+		points = append(points, model.TrendPoint{
+			Date:     first.Format("2006-01-02"),
+			EndDate:  last.Format("2006-01-02"),
+			Revenue:  s.revenue,
+			Expenses: s.expenses,
+		})
+	}
+	return points, nil
+}
+
+// bucketStart is the first day of the day, week (from Monday) or month d is in.
+func bucketStart(d time.Time, granularity string) time.Time {
+	switch granularity {
+	case "week":
+		return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+	case "month":
+		return time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, d.Location())
+	}
+	return d
+}
+
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case "week":
+		return start.AddDate(0, 0, 7)
+	case "month":
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// pctChange is the change from prev to cur in percent, or nil when prev is
+// zero.
+func pctChange(cur, prev float64) *float64 {
+	if prev == 0 {
+		return nil
+	}
+	pct := math.Round((cur-prev)/prev*1000) / 10
+	return &pct
 }
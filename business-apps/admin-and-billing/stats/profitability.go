@@ -0,0 +1,222 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/expenses"
+	"github.com/soumalya/food-delivery-admin/meals"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// GetProfitability reports food cost and margins of the meals served from
+// ?from to ?to (default the 30 days up to today): gross margin per menu item
+// at its standard unit cost, main meals against extras, and how many meals a
+// day cover the overheads.
+func GetProfitability(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	from, to, days := dateRange(params)
+	if params.Failed(w) {
+		return
+	}
+
+	ctx := r.Context()
+	p := model.Profitability{From: from, To: to, Days: days, Items: []model.ItemMargin{}}
+	items, err := itemsSold(ctx, from, to)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	byCategory, err := expensesByCategory(ctx, from, to)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	catalog := meals.GetMealCatalogInternal(ctx)
+	for _, item := range items {
+		item.UnitCost = catalog[item.ItemID].UnitCost
+		item.Cost = round2(float64(item.Quantity) * item.UnitCost)
+		item.Margin = round2(item.Revenue - item.Cost)
+		if item.Revenue > 0 {
+			item.MarginPct = round1(item.Margin / item.Revenue * 100)
+		}
+		p.Items = append(p.Items, item)
+
+		group := &p.Extras
+		if item.ItemID == "standard" || item.ItemID == "special" {
+			group = &p.MainMeals
+			p.MealsServed += item.Quantity
+		}
+		group.Revenue += item.Revenue
+		group.Cost += item.Cost
+		p.Revenue += item.Revenue
+		p.StandardFoodCost += item.Cost
+	}
+	sort.Slice(p.Items, func(i, j int) bool { return p.Items[i].Margin > p.Items[j].Margin })
+
+	var actualFoodCost bool
+	for category, amount := range byCategory {
+		if slices.Contains(expenses.CostOfGoods, category) {
+			p.FoodCost += amount
+			actualFoodCost = true
+		} else {
+			p.Overheads += amount
+		}
+	}
+	p.CostBasis = "expenses"
+	if !actualFoodCost {
+		p.FoodCost = p.StandardFoodCost
+		p.CostBasis = "standard"
+	}
+
+	totalMargin := p.Revenue - p.StandardFoodCost
+	for _, group := range []*model.MarginContribution{&p.MainMeals, &p.Extras} {
+		group.Revenue = round2(group.Revenue)
+		group.Cost = round2(group.Cost)
+		group.Margin = round2(group.Revenue - group.Cost)
+		if totalMargin != 0 {
+			group.SharePct = round1(group.Margin / totalMargin * 100)
+		}
+	}
+
+	p.GrossMargin = p.Revenue - p.FoodCost
+	if p.Revenue > 0 {
+		p.GrossMarginPct = round1(p.GrossMargin / p.Revenue * 100)
+	}
+	p.OverheadsPerDay = p.Overheads / float64(days)
+	if p.MealsServed > 0 {
+		p.CostPerMeal = p.FoodCost / float64(p.MealsServed)
+		p.ContributionPerMeal = p.GrossMargin / float64(p.MealsServed)
+	}
+	if p.ContributionPerMeal > 0 {
+		breakEven := math.Ceil(p.OverheadsPerDay/p.ContributionPerMeal*10) / 10
+		p.BreakEvenMealsPerDay = &breakEven
+	}
+
+	for _, f := range []*float64{&p.Revenue, &p.FoodCost, &p.StandardFoodCost, &p.GrossMargin,
+		&p.Overheads, &p.OverheadsPerDay, &p.CostPerMeal, &p.ContributionPerMeal} {
+		*f = round2(*f)
+	}
+
+	json.NewEncoder(w).Encode(p)
+}
+
+// itemsSold totals quantity and revenue net of GST per item over the range.
+// Entries made before items were stored are priced at the current meal
+// prices, as on bills.
+func itemsSold(ctx context.Context, from, to time.Time) ([]model.ItemMargin, error) {
+	var items []model.ItemMargin
+	index := make(map[string]int)
+	add := func(itemID, itemName string, qty int, revenue float64) {
+		i, ok := index[itemID]
+		if !ok {
+			i = len(items)
+			index[itemID] = i
+			items = append(items, model.ItemMargin{ItemID: itemID, ItemName: itemName})
+		}
+		items[i].Quantity += qty
+		items[i].Revenue += revenue
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT i.ITEM_ID, MAX(i.ITEM_NAME), SUM(i.QUANTITY), SUM(COALESCE(i.TAXABLE_VALUE, i.AMOUNT))
+		FROM DAILY_LOG_ITEMS i
+		JOIN DAILY_LOGS l ON l.LOG_ID = i.LOG_ID
+		WHERE l.LOG_DATE BETWEEN $1 AND $2 AND l.DELETED_AT IS NULL
+		GROUP BY i.ITEM_ID
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var itemID, itemName string
+		var qty int
+		var revenue float64
+		if err := rows.Scan(&itemID, &itemName, &qty, &revenue); err != nil {
+			return nil, err
+		}
+		add(itemID, itemName, qty, revenue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = dbPool.Query(ctx, `
+		SELECT HAS_MAIN_MEAL, IS_SPECIAL, EXTRA_RICE_QTY, EXTRA_ROTI_QTY, EXTRA_CHICKEN_QTY,
+			EXTRA_FISH_QTY, EXTRA_EGG_QTY, EXTRA_VEGETABLE_QTY, COUNT(*)
+		FROM DAILY_LOGS l
+		WHERE LOG_DATE BETWEEN $1 AND $2 AND DELETED_AT IS NULL
+			AND NOT EXISTS (SELECT 1 FROM DAILY_LOG_ITEMS i WHERE i.LOG_ID = l.LOG_ID)
+		GROUP BY 1, 2, 3, 4, 5, 6, 7, 8
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var catalog map[string]model.MealPrice
+	for rows.Next() {
+		var e model.EntryRequest
+		var count int
+		if err := rows.Scan(&e.HasMainMeal, &e.IsSpecial, &e.ExtraRiceQty, &e.ExtraRotiQty, &e.ExtraChickenQty,
+			&e.ExtraFishQty, &e.ExtraEggQty, &e.ExtraVegetableQty, &count); err != nil {
+			return nil, err
+		}
+		if catalog == nil {
+			catalog = meals.GetMealCatalogInternal(ctx)
+		}
+		for _, line := range meals.ItemLines(e, catalog) {
+			add(line.ItemID, line.ItemName, line.Quantity*count, line.TaxableValue*float64(count))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range items {
+		items[i].Revenue = round2(items[i].Revenue)
+	}
+	return items, nil
+}
+
+// expensesByCategory sums the expenses of the range per category.
+func expensesByCategory(ctx context.Context, from, to time.Time) (map[string]float64, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT CATEGORY, SUM(AMOUNT) FROM EXPENSES
+		WHERE EXPENSE_DATE BETWEEN $1 AND $2
+		GROUP BY CATEGORY
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make(map[string]float64)
+	for rows.Next() {
+		var category string
+		var amount float64
+		if err := rows.Scan(&category, &amount); err != nil {
+			return nil, err
+		}
+		totals[category] = amount
+	}
+	return totals, rows.Err()
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,30 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+)
+
+// CheckActive records a violation against field unless userID is an existing,
+// active customer.
+func CheckActive(ctx context.Context, v *api.Validator, field string, userID int) error {
+	if userID <= 0 {
+		v.Check(false, field, "is required")
+		return nil
+	}
+	var active bool
+	err := database.GetDbConn().QueryRow(ctx, `SELECT IS_ACTIVE FROM USERS WHERE USER_ID = $1`, userID).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		v.Check(false, field, "does not match any customer")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	v.Check(active, field, "belongs to an inactive customer")
+	return nil
+}
@@ -2,73 +2,310 @@ package users
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/model"
 )
 
+// GetUsers lists customers, optionally filtered by ?q (fuzzy name or mobile
+// number), ?building, ?plan, ?role, ?status (active or inactive) and
+// ?min_balance/?max_balance. ?sort is name (default), balance or user_id and
+// ?order asc or desc. Without ?limit every match is returned; with it, the
+// X-Next-Cursor header carries the ?cursor for the next page when there is one.
 func GetUsers(w http.ResponseWriter, r *http.Request) {
-	dbPool := database.GetDbConn()
-	rows, err := dbPool.Query(r.Context(), `
-		SELECT u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO, u.ROLE, u.PLAN, w.BALANCE 
+	params := api.Query(r)
+	text := params.String("q", false)
+	building := params.String("building", false)
+	plan := params.String("plan", false)
+	params.Check(plan == "" || plan == "monthly" || plan == "one_off", "plan", "must be monthly or one_off")
+	role := params.String("role", false)
+	params.Check(role == "" || role == "normal" || role == "admin", "role", "must be normal or admin")
+	status := params.String("status", false)
+	params.Check(status == "" || status == "active" || status == "inactive", "status", "must be active or inactive")
+	minBalance := params.Float("min_balance", false)
+	maxBalance := params.Float("max_balance", false)
+	if params.Has("min_balance") && params.Has("max_balance") {
+		params.Check(maxBalance >= minBalance, "max_balance", "must not be less than min_balance")
+	}
+	sortBy := params.String("sort", false)
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	sortCol, ok := sortColumns[sortBy]
+	params.Check(ok, "sort", "must be name, balance or user_id")
+	order := params.String("order", false)
+	params.Check(order == "" || order == "asc" || order == "desc", "order", "must be asc or desc")
+	limit := params.Int("limit", false)
+	params.Check(limit <= maxPageSize, "limit", fmt.Sprintf("cannot be more than %d", maxPageSize))
+	var after *cursor
+	if params.Has("cursor") {
+		after = decodeCursor(&params.Validator, params.String("cursor", false))
+	}
+	if params.Failed(w) {
+		return
+	}
+
+	q := &userQuery{}
+	today := q.arg(database.BusinessDate(time.Now()))
+	if text != "" {
+		q.matchText(text)
+	}
+	if building != "" {
+		q.and("LOWER(u.BUILDING_NO) = LOWER(" + q.arg(building) + ")")
+	}
+	if plan != "" {
+		q.and("USER_PLAN_ON(u.USER_ID, " + today + ")::TEXT = " + q.arg(plan))
+	}
+	if role != "" {
+		q.and("u.ROLE::TEXT = " + q.arg(role))
+	}
+	switch status {
+	case "active":
+		q.and("u.IS_ACTIVE")
+	case "inactive":
+		q.and("NOT u.IS_ACTIVE")
+	}
+	if params.Has("min_balance") {
+		q.and("COALESCE(w.BALANCE, 0) >= " + q.arg(minBalance))
+	}
+	if params.Has("max_balance") {
+		q.and("COALESCE(w.BALANCE, 0) <= " + q.arg(maxBalance))
+	}
+
+	cmp, dir := ">", "ASC"
+	if order == "desc" {
+		cmp, dir = "<", "DESC"
+	}
+	if after != nil {
+		q.and(fmt.Sprintf("(%s, u.USER_ID) %s (CAST(%s AS %s), %s)", sortCol.expr, cmp, q.arg(after.Value), sortCol.sqlType, q.arg(after.ID)))
+	}
+
+	query := `
+		SELECT u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO, u.ROLE, USER_PLAN_ON(u.USER_ID, ` + today + `), COALESCE(w.BALANCE, 0),
+		       COALESCE(u.EMAIL, ''), u.LOW_BALANCE_ALERTS,
+		       COALESCE(w.CREDIT_LIMIT, 0), COALESCE(w.BALANCE_POLICY::TEXT, 'warn'),
+		       u.IS_ACTIVE, u.DEACTIVATED_AT, (` + sortCol.expr + `)::TEXT
 		FROM USERS u 
 		LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
-	`)
+	` + q.whereClause() + `
+		ORDER BY ` + sortCol.expr + ` ` + dir + `, u.USER_ID ` + dir
+	if limit > 0 {
+		// One extra row tells whether there is another page
+		query += ` LIMIT ` + q.arg(limit+1)
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), query, q.args...)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer rows.Close()
 
-	var users []model.User
+	users := []model.User{}
+	var sortValues []string
 	for rows.Next() {
 		var u model.User
-		err := rows.Scan(&u.UserID, &u.Name, &u.MobileNo, &u.BuildingNo, &u.RoomNo, &u.Role, &u.Plan, &u.Balance)
+		var sortValue string
+		err := rows.Scan(&u.UserID, &u.Name, &u.MobileNo, &u.BuildingNo, &u.RoomNo, &u.Role, &u.Plan, &u.Balance, &u.Email, &u.LowBalanceAlerts, &u.CreditLimit, &u.BalancePolicy,
+			&u.IsActive, &u.DeactivatedAt, &sortValue)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			api.InternalError(w, err)
 			return
 		}
 		users = append(users, u)
+		sortValues = append(sortValues, sortValue)
+	}
+	if err := rows.Err(); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if limit > 0 && len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		w.Header().Set("X-Next-Cursor", encodeCursor(cursor{Value: sortValues[limit-1], ID: last.UserID}))
 	}
 	json.NewEncoder(w).Encode(users)
 }
 
+// Typeahead suggests active customers for ?q, best matches first, for
+// picking a customer while logging meals. ?limit defaults to 8.
+func Typeahead(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	text := strings.TrimSpace(params.String("q", true))
+	limit := params.Int("limit", false)
+	if limit == 0 {
+		limit = defaultTypeahead
+	}
+	params.Check(limit <= maxTypeahead, "limit", fmt.Sprintf("cannot be more than %d", maxTypeahead))
+	if params.Failed(w) {
+		return
+	}
+
+	q := &userQuery{}
+	q.and("u.IS_ACTIVE")
+	rank := "0"
+	if text != "" {
+		rank = q.matchText(text)
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT u.USER_ID, COALESCE(u.NAME, ''), COALESCE(u.MOBILE_NO, ''), COALESCE(u.BUILDING_NO, ''), COALESCE(u.ROOM_NO, ''), COALESCE(w.BALANCE, 0)
+		FROM USERS u
+		LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
+	`+q.whereClause()+`
+		ORDER BY `+rank+`, LOWER(u.NAME), u.USER_ID
+		LIMIT `+q.arg(limit), q.args...)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	suggestions, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.UserSuggestion, error) {
+		var s model.UserSuggestion
+		err := row.Scan(&s.UserID, &s.Name, &s.MobileNo, &s.BuildingNo, &s.RoomNo, &s.Balance)
+		return s, err
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(suggestions)
+}
+
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var u model.User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &u) {
 		return
 	}
 
 	if u.Role == "" {
 		u.Role = "normal"
 	}
+	if u.BalancePolicy == "" {
+		u.BalancePolicy = "warn"
+	}
+	var v api.Validator
+	u.MobileNo = checkMobile(&v, "mobile_no", u.MobileNo)
+	if v.Failed(w) {
+		return
+	}
 
 	dbPool := database.GetDbConn()
 	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer tx.Rollback(r.Context())
 
-	err = tx.QueryRow(r.Context(), `
-		INSERT INTO USERS (NAME, MOBILE_NO, BUILDING_NO, ROOM_NO, ROLE, PLAN) 
-		VALUES ($1, $2, $3, $4, $5, $6) 
-		RETURNING USER_ID
-	`, u.Name, u.MobileNo, u.BuildingNo, u.RoomNo, u.Role, u.Plan).Scan(&u.UserID)
+	if !checkMobileFree(w, r, tx, u.MobileNo, 0) {
+		return
+	}
+	err = insertUser(r, tx, &u)
+	if database.IsUniqueViolation(err) {
+		duplicateMobile(w, "Mobile number "+u.MobileNo+" already belongs to another customer")
+		return
+	}
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
 		return
 	}
+	json.NewEncoder(w).Encode(u)
+}
+
+// insertUser creates a customer with an empty wallet on their plan from today
+// and audits it.
+func insertUser(r *http.Request, tx pgx.Tx, u *model.User) error {
+	err := tx.QueryRow(r.Context(), `
+		INSERT INTO USERS (NAME, MOBILE_NO, BUILDING_NO, ROOM_NO, ROLE, PLAN, EMAIL) 
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')) 
+		RETURNING USER_ID, LOW_BALANCE_ALERTS, IS_ACTIVE
+	`, u.Name, u.MobileNo, u.BuildingNo, u.RoomNo, u.Role, u.Plan, u.Email).Scan(&u.UserID, &u.LowBalanceAlerts, &u.IsActive)
+	if err != nil {
+		return err
+	}
 
-	_, err = tx.Exec(r.Context(), `INSERT INTO WALLET (USER_ID, BALANCE) VALUES ($1, 0)`, u.UserID)
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO PLAN_HISTORY (USER_ID, PLAN, EFFECTIVE_FROM) VALUES ($1, $2, $3)
+	`, u.UserID, u.Plan, database.BusinessDate(time.Now()))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return err
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		INSERT INTO WALLET (USER_ID, BALANCE, CREDIT_LIMIT, BALANCE_POLICY) VALUES ($1, 0, $2, $3)
+	`, u.UserID, u.CreditLimit, u.BalancePolicy)
+	if err != nil {
+		return err
+	}
+	return recordChange(r, tx, "user.create", u.UserID, nil)
+}
+
+func UpdateNotificationPrefs(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
 		return
 	}
 
-	tx.Commit(r.Context())
-	json.NewEncoder(w).Encode(u)
+	var prefs struct {
+		LowBalanceAlerts bool `json:"low_balance_alerts"`
+	}
+	if !api.DecodeJSON(w, r, &prefs) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "USERS", "USER_ID", userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "User not found")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `UPDATE USERS SET LOW_BALANCE_ALERTS = $1 WHERE USER_ID = $2`, prefs.LowBalanceAlerts, userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "user.update_notifications", userID, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// recordChange audits a change to a customer.
+func recordChange(r *http.Request, tx pgx.Tx, action string, userID int, before json.RawMessage) error {
+	return audit.RecordRow(r.Context(), tx, audit.FromRequest(r), action, "user", "USERS", "USER_ID", userID, before)
 }
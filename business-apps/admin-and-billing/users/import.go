@@ -0,0 +1,295 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/xlsx"
+)
+
+const (
+	maxImportSize = 5 << 20
+	maxImportRows = 1000
+)
+
+// Columns an import file may have; name and mobile_no are required
+var importColumns = map[string]bool{
+	"name": true, "mobile_no": true, "building_no": true, "room_no": true, "plan": true,
+	"email": true, "opening_balance": true, "credit_limit": true, "balance_policy": true,
+}
+
+// ImportUsers creates customers in bulk from a CSV or XLSX file uploaded as
+// the "file" form field. The first row names the columns. Each customer's
+// wallet is opened with their opening_balance, recorded as an
+// opening_balance transaction. With ?dry_run=true the rows are checked and
+// previewed without creating anything. Either every row is imported or, if
+// any row is invalid, none is.
+func ImportUsers(w http.ResponseWriter, r *http.Request) {
+	params := api.Query(r)
+	dryRun := params.String("dry_run", false)
+	params.Check(dryRun == "" || dryRun == "true" || dryRun == "false", "dry_run", "must be true or false")
+	if params.Failed(w) {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		api.BadRequest(w, "Upload the file to import as the \"file\" form field, up to 5 MB")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		api.BadRequest(w, "Could not read the uploaded file")
+		return
+	}
+
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(header.Filename)) {
+	case ".csv":
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err = reader.ReadAll()
+	case ".xlsx":
+		records, err = xlsx.Read(bytes.NewReader(data), int64(len(data)), maxImportRows+1)
+	default:
+		api.BadRequest(w, "Only .csv and .xlsx files can be imported")
+		return
+	}
+	if err != nil {
+		api.BadRequest(w, "Could not read the uploaded file: "+err.Error())
+		return
+	}
+
+	var v api.Validator
+	rows := parseImport(&v, records)
+	if v.Failed(w) {
+		return
+	}
+	if err := checkImportMobiles(r.Context(), &v, rows); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	result := model.ImportResult{DryRun: dryRun == "true", Rows: rows}
+	for _, row := range rows {
+		result.TotalOpeningBalance += row.OpeningBalance
+	}
+	result.TotalOpeningBalance = math.Round(result.TotalOpeningBalance*100) / 100
+	if result.DryRun {
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	today := database.BusinessDate(time.Now())
+	locked, err := statements.PeriodLocked(r.Context(), tx, today)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for today is locked")
+		return
+	}
+
+	mobiles := make([]string, len(result.Rows))
+	for i, row := range result.Rows {
+		mobiles[i] = row.MobileNo
+	}
+	if err := lockMobiles(r.Context(), tx, mobiles); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	src := audit.FromRequest(r)
+	for i := range result.Rows {
+		row := &result.Rows[i]
+		u := model.User{
+			Name: row.Name, MobileNo: row.MobileNo, BuildingNo: row.BuildingNo, RoomNo: row.RoomNo, Role: "normal",
+			Plan: row.Plan, Email: row.Email, CreditLimit: row.CreditLimit, BalancePolicy: row.BalancePolicy,
+		}
+		if !checkMobileFree(w, r, tx, u.MobileNo, 0) {
+			return
+		}
+		err := insertUser(r, tx, &u)
+		if database.IsUniqueViolation(err) {
+			duplicateMobile(w, fmt.Sprintf("Mobile number on line %d was taken while importing", row.Line))
+			return
+		}
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		row.UserID = u.UserID
+		if row.OpeningBalance == 0 {
+			continue
+		}
+
+		var txnID int
+		err = tx.QueryRow(r.Context(), `
+			WITH W AS (
+				UPDATE WALLET SET BALANCE = BALANCE + $2 WHERE USER_ID = $1 RETURNING BALANCE
+			)
+			INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, REFERENCE_ID, REASON, APPROVED_BY, VALUE_DATE)
+			SELECT $1, 'opening_balance', 'confirmed', $2, W.BALANCE, $3, 'Opening balance on import', $4, $5
+			FROM W
+			RETURNING TXN_ID
+		`, u.UserID, row.OpeningBalance, header.Filename, src.Actor, today).Scan(&txnID)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		after, err := audit.Snapshot(r.Context(), tx, "WALLET_TRANSACTIONS", "TXN_ID", txnID)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		err = audit.Record(r.Context(), tx, src, audit.Change{
+			Action: "wallet.opening_balance", EntityType: "wallet_transaction", EntityID: strconv.Itoa(txnID), After: after,
+		})
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+// parseImport turns the file's records into rows, skipping blank lines. A
+// malformed header is recorded against "file"; problems with a row are
+// recorded against "line N: column".
+func parseImport(v *api.Validator, records [][]string) []model.ImportRow {
+	if len(records) == 0 {
+		v.Check(false, "file", "is empty")
+		return nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.Join(strings.Fields(name), "_"))
+		if name == "" {
+			continue
+		}
+		v.Check(importColumns[name], "file", fmt.Sprintf("has an unknown column %q", name))
+		_, seen := columns[name]
+		v.Check(!seen, "file", fmt.Sprintf("has the column %q twice", name))
+		columns[name] = i
+	}
+	_, hasName := columns["name"]
+	_, hasMobile := columns["mobile_no"]
+	v.Check(hasName && hasMobile, "file", "needs name and mobile_no columns")
+	if len(v.Errors) > 0 {
+		return nil
+	}
+
+	rows := []model.ImportRow{}
+	for i, record := range records[1:] {
+		get := func(column string) string {
+			if c, ok := columns[column]; ok && c < len(record) {
+				return strings.TrimSpace(record[c])
+			}
+			return ""
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+		line := i + 2
+		field := func(column string) string {
+			return fmt.Sprintf("line %d: %s", line, column)
+		}
+
+		row := model.ImportRow{
+			Line: line, Name: get("name"), MobileNo: get("mobile_no"), BuildingNo: get("building_no"), RoomNo: get("room_no"),
+			Plan: get("plan"), Email: get("email"), BalancePolicy: get("balance_policy"),
+		}
+		v.Check(row.Name != "", field("name"), "is required")
+		row.MobileNo = checkMobile(v, field("mobile_no"), row.MobileNo)
+		if row.Plan == "" {
+			row.Plan = "monthly"
+		}
+		v.Check(row.Plan == "monthly" || row.Plan == "one_off", field("plan"), "must be monthly or one_off")
+		v.Check(row.Email == "" || strings.Contains(row.Email, "@"), field("email"), "is not an email address")
+		if row.BalancePolicy == "" {
+			row.BalancePolicy = "warn"
+		}
+		v.Check(row.BalancePolicy == "block" || row.BalancePolicy == "warn" || row.BalancePolicy == "allow", field("balance_policy"), "must be block, warn or allow")
+		row.OpeningBalance = parseAmount(v, field("opening_balance"), get("opening_balance"))
+		row.CreditLimit = parseAmount(v, field("credit_limit"), get("credit_limit"))
+		v.Check(row.CreditLimit >= 0, field("credit_limit"), "cannot be negative")
+		rows = append(rows, row)
+	}
+	v.Check(len(rows) > 0, "file", "has no customers")
+	v.Check(len(rows) <= maxImportRows, "file", fmt.Sprintf("cannot have more than %d customers", maxImportRows))
+	return rows
+}
+
+// parseAmount reads an optional money amount, allowing thousands separators.
+func parseAmount(v *api.Validator, field, s string) float64 {
+	if s == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(s, ",", ""), 64)
+	v.Check(err == nil && !math.IsInf(f, 0) && !math.IsNaN(f), field, "must be a number")
+	return math.Round(f*100) / 100
+}
+
+// checkImportMobiles records mobile numbers that repeat within the file or
+// already belong to a customer.
+func checkImportMobiles(ctx context.Context, v *api.Validator, rows []model.ImportRow) error {
+	firstLine := make(map[string]int)
+	var mobiles []string
+	for _, row := range rows {
+		if first, ok := firstLine[row.MobileNo]; ok {
+			v.Check(false, fmt.Sprintf("line %d: mobile_no", row.Line), fmt.Sprintf("repeats line %d", first))
+			continue
+		}
+		firstLine[row.MobileNo] = row.Line
+		mobiles = append(mobiles, row.MobileNo)
+	}
+
+	owners, err := mobileOwners(ctx, mobiles)
+	if err != nil {
+		return err
+	}
+	for _, mobile := range mobiles {
+		if owner, ok := owners[mobile]; ok {
+			v.Check(false, fmt.Sprintf("line %d: mobile_no", firstLine[mobile]), "already belongs to "+owner)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+)
+
+// UpdateUser edits a customer's contact and address details.
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var u model.UserUpdate
+	if !api.DecodeJSON(w, r, &u) {
+		return
+	}
+	var v api.Validator
+	v.Check(strings.TrimSpace(u.Name) != "", "name", "is required")
+	u.MobileNo = checkMobile(&v, "mobile_no", strings.TrimSpace(u.MobileNo))
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "USERS", "USER_ID", userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if before == nil {
+		api.NotFound(w, "User not found")
+		return
+	}
+	if !checkMobileFree(w, r, tx, u.MobileNo, userID) {
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE USERS SET NAME = $1, MOBILE_NO = $2, BUILDING_NO = $3, ROOM_NO = $4, EMAIL = NULLIF($5, '')
+		WHERE USER_ID = $6
+	`, strings.TrimSpace(u.Name), u.MobileNo, u.BuildingNo, u.RoomNo, u.Email, userID)
+	if database.IsUniqueViolation(err) {
+		duplicateMobile(w, "Mobile number "+u.MobileNo+" already belongs to another customer")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordChange(r, tx, "user.update", userID, before); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeactivateUser marks a customer as having left. Their history is kept but
+// they get no new entries or recharges. Use the settlement endpoint to pay
+// out or collect their remaining balance at the same time.
+func DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	setActive(w, r, false)
+}
+
+func ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	setActive(w, r, true)
+}
+
+func setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	if active {
+		var mergedInto *int
+		err := tx.QueryRow(r.Context(), `SELECT MERGED_INTO FROM USERS WHERE USER_ID = $1`, userID).Scan(&mergedInto)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			api.InternalError(w, err)
+			return
+		}
+		if mergedInto != nil {
+			api.Conflict(w, fmt.Sprintf("Customer was merged into user %d", *mergedInto))
+			return
+		}
+	}
+
+	changed, err := SetActive(r, tx, userID, active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if !changed {
+		if active {
+			api.Conflict(w, "Customer is already active")
+		} else {
+			api.Conflict(w, "Customer is already inactive")
+		}
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// SetActive activates or deactivates a customer in tx and audits it. It
+// reports false if they already were, and pgx.ErrNoRows if there is no such
+// customer.
+func SetActive(r *http.Request, tx pgx.Tx, userID int, active bool) (bool, error) {
+	before, err := audit.Snapshot(r.Context(), tx, "USERS", "USER_ID", userID)
+	if err != nil {
+		return false, err
+	}
+	if before == nil {
+		return false, pgx.ErrNoRows
+	}
+
+	tag, err := tx.Exec(r.Context(), `
+		UPDATE USERS SET IS_ACTIVE = $2, DEACTIVATED_AT = CASE WHEN $2 THEN NULL ELSE NOW() END
+		WHERE USER_ID = $1 AND IS_ACTIVE <> $2
+	`, userID, active)
+	if err != nil || tag.RowsAffected() == 0 {
+		return false, err
+	}
+
+	action := "user.deactivate"
+	if active {
+		action = "user.reactivate"
+	}
+	return true, recordChange(r, tx, action, userID, before)
+}
+
+// ChangePlan switches a customer's plan from the given date onwards. Changes
+// may be dated ahead; a second change for the same date replaces the first.
+func ChangePlan(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var req model.PlanChangeRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	v.Check(req.Plan == "monthly" || req.Plan == "one_off", "plan", "must be monthly or one_off")
+	effectiveFrom := database.BusinessDate(time.Now())
+	if req.EffectiveFrom != "" {
+		effectiveFrom = v.ParseDate("effective_from", req.EffectiveFrom)
+	}
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var exists bool
+	err = tx.QueryRow(r.Context(), `SELECT EXISTS (SELECT 1 FROM USERS WHERE USER_ID = $1)`, userID).Scan(&exists)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if !exists {
+		api.NotFound(w, "User not found")
+		return
+	}
+
+	// Billed periods keep the plan they were billed on
+	locked, err := statements.PeriodLocked(r.Context(), tx, effectiveFrom)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	var before json.RawMessage
+	err = tx.QueryRow(r.Context(), `
+		SELECT TO_JSONB(h) FROM PLAN_HISTORY h WHERE USER_ID = $1 AND EFFECTIVE_FROM = $2
+	`, userID, effectiveFrom).Scan(&before)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		api.InternalError(w, err)
+		return
+	}
+
+	var after json.RawMessage
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO PLAN_HISTORY AS h (USER_ID, PLAN, EFFECTIVE_FROM) VALUES ($1, $2, $3)
+		ON CONFLICT (USER_ID, EFFECTIVE_FROM) DO UPDATE SET PLAN = EXCLUDED.PLAN, CHANGED_AT = NOW()
+		RETURNING TO_JSONB(h)
+	`, userID, req.Plan, effectiveFrom).Scan(&after)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = audit.Record(r.Context(), tx, audit.FromRequest(r), audit.Change{
+		Action: "user.change_plan", EntityType: "user", EntityID: strconv.Itoa(userID), Before: before, After: after,
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetPlanHistory lists a customer's plan changes, latest first.
+func GetPlanHistory(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT PLAN, EFFECTIVE_FROM, CHANGED_AT FROM PLAN_HISTORY
+		WHERE USER_ID = $1
+		ORDER BY EFFECTIVE_FROM DESC
+	`, userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	history, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.PlanChange, error) {
+		var p model.PlanChange
+		err := row.Scan(&p.Plan, &p.EffectiveFrom, &p.ChangedAt)
+		return p, err
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
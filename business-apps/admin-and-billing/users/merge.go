@@ -0,0 +1,259 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+)
+
+// GetDuplicates lists customers who look like the same person because their
+// mobile numbers end in the same ten digits. Merged customers are left out.
+func GetDuplicates(w http.ResponseWriter, r *http.Request) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		WITH U AS (
+			SELECT u.USER_ID, COALESCE(u.NAME, '') AS NAME, u.MOBILE_NO, COALESCE(u.BUILDING_NO, '') AS BUILDING_NO,
+				COALESCE(u.ROOM_NO, '') AS ROOM_NO, COALESCE(w.BALANCE, 0) AS BALANCE,
+				RIGHT(REGEXP_REPLACE(u.MOBILE_NO, '\D', '', 'g'), 10) AS MOBILE_KEY
+			FROM USERS u
+			LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
+			WHERE u.MERGED_INTO IS NULL AND u.MOBILE_NO IS NOT NULL
+		)
+		SELECT MOBILE_KEY, USER_ID, NAME, MOBILE_NO, BUILDING_NO, ROOM_NO, BALANCE
+		FROM U
+		WHERE MOBILE_KEY IN (SELECT MOBILE_KEY FROM U WHERE MOBILE_KEY <> '' GROUP BY MOBILE_KEY HAVING COUNT(*) > 1)
+		ORDER BY MOBILE_KEY, USER_ID
+	`)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer rows.Close()
+
+	duplicates := []model.DuplicateMobile{}
+	for rows.Next() {
+		var key string
+		var s model.UserSuggestion
+		if err := rows.Scan(&key, &s.UserID, &s.Name, &s.MobileNo, &s.BuildingNo, &s.RoomNo, &s.Balance); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if n := len(duplicates); n == 0 || duplicates[n-1].MobileNo != key {
+			duplicates = append(duplicates, model.DuplicateMobile{MobileNo: key})
+		}
+		group := &duplicates[len(duplicates)-1]
+		group.Users = append(group.Users, s)
+	}
+	if err := rows.Err(); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(duplicates)
+}
+
+// MergeUsers folds a duplicate customer into this one. Their meal entries,
+// wallet transactions and balance move over, and the duplicate is
+// deactivated and marked as merged. Statements already generated for
+// either customer are left as they were issued, so a duplicate with entries
+// or transactions in a locked billing period cannot be merged.
+func MergeUsers(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var req model.MergeRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	v.Check(req.SourceUserID > 0, "source_user_id", "is required")
+	v.Check(req.SourceUserID != userID, "source_user_id", "cannot be the customer being merged into")
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Lock both customers and wallets in id order so concurrent merges of
+	// the same pair can't deadlock
+	rows, err := tx.Query(r.Context(), `
+		SELECT u.USER_ID, u.MERGED_INTO, w.BALANCE
+		FROM USERS u
+		JOIN WALLET w ON u.USER_ID = w.USER_ID
+		WHERE u.USER_ID IN ($1, $2)
+		ORDER BY u.USER_ID
+		FOR UPDATE
+	`, userID, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	type party struct {
+		userID     int
+		mergedInto *int
+		balance    float64
+	}
+	parties, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (party, error) {
+		var p party
+		err := row.Scan(&p.userID, &p.mergedInto, &p.balance)
+		return p, err
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if len(parties) < 2 {
+		api.NotFound(w, "User not found")
+		return
+	}
+	var source party
+	for _, p := range parties {
+		if p.mergedInto != nil {
+			api.Conflict(w, fmt.Sprintf("User %d was already merged into user %d", p.userID, *p.mergedInto))
+			return
+		}
+		if p.userID == req.SourceUserID {
+			source = p
+		}
+	}
+
+	// Moving entries or transactions out of a locked period would change
+	// statements already issued for it
+	rows, err = tx.Query(r.Context(), `
+		SELECT LOG_DATE FROM DAILY_LOGS WHERE USER_ID = $1
+		UNION
+		SELECT VALUE_DATE FROM WALLET_TRANSACTIONS WHERE USER_ID = $1 AND VALUE_DATE IS NOT NULL
+	`, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	dates, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	for _, date := range dates {
+		locked, err := statements.PeriodLocked(r.Context(), tx, date)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if locked {
+			api.Conflict(w, fmt.Sprintf("User %d has entries in a locked billing period and cannot be merged", req.SourceUserID))
+			return
+		}
+	}
+
+	sourceBefore, err := audit.Snapshot(r.Context(), tx, "USERS", "USER_ID", req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	walletBefore, err := audit.Snapshot(r.Context(), tx, "WALLET", "USER_ID", userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	result := model.MergeResult{UserID: userID, SourceUserID: req.SourceUserID, BalanceMoved: source.balance}
+	err = tx.QueryRow(r.Context(), `
+		SELECT COUNT(*) FROM DAILY_LOGS s
+		JOIN DAILY_LOGS t ON t.LOG_DATE = s.LOG_DATE AND t.MEAL_TYPE = s.MEAL_TYPE
+		WHERE s.USER_ID = $1 AND t.USER_ID = $2 AND s.DELETED_AT IS NULL AND t.DELETED_AT IS NULL
+	`, req.SourceUserID, userID).Scan(&result.OverlappingEntries)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	tag, err := tx.Exec(r.Context(), `UPDATE DAILY_LOGS SET USER_ID = $1 WHERE USER_ID = $2`, userID, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	result.LogsMoved = int(tag.RowsAffected())
+
+	tag, err = tx.Exec(r.Context(), `UPDATE WALLET_TRANSACTIONS SET USER_ID = $1 WHERE USER_ID = $2`, userID, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	result.TxnsMoved = int(tag.RowsAffected())
+	_, err = tx.Exec(r.Context(), `
+		UPDATE WALLET_TRANSACTIONS SET COUNTERPARTY_USER_ID = $1 WHERE COUNTERPARTY_USER_ID = $2
+	`, userID, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	// The moved transactions account for the balance, so it moves as is
+	_, err = tx.Exec(r.Context(), `UPDATE WALLET SET BALANCE = 0 WHERE USER_ID = $1`, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET BALANCE = BALANCE + $1 WHERE USER_ID = $2 RETURNING BALANCE
+	`, source.balance, userID).Scan(&result.NewBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `
+		UPDATE USERS SET MERGED_INTO = $1, IS_ACTIVE = FALSE, DEACTIVATED_AT = COALESCE(DEACTIVATED_AT, NOW())
+		WHERE USER_ID = $2
+	`, userID, req.SourceUserID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := recordChange(r, tx, "user.merge", req.SourceUserID, sourceBefore); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	walletAfter, err := audit.Snapshot(r.Context(), tx, "WALLET", "USER_ID", userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = audit.Record(r.Context(), tx, audit.FromRequest(r), audit.Change{
+		Action: "wallet.merge", EntityType: "wallet", EntityID: strconv.Itoa(userID), Before: walletBefore, After: walletAfter,
+	})
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	// The merge may have resolved the last shared mobile number
+	if _, err := database.EnsureMobileUnique(r.Context()); err != nil {
+		log.Printf("Unable to make mobile numbers unique: %v\n", err)
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,89 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+)
+
+// checkMobile returns the mobile number in E.164 form, recording field if it
+// is missing or cannot be read.
+func checkMobile(v *api.Validator, field, mobile string) string {
+	if mobile == "" {
+		v.Check(false, field, "is required")
+		return ""
+	}
+	normalized, ok := database.NormalizeMobile(mobile)
+	v.Check(ok, field, "is not a valid mobile number")
+	return normalized
+}
+
+// checkMobileFree writes a 409 and returns false if a customer other than
+// exceptUserID already has the mobile number. It holds a lock on the number
+// until tx ends, as the unique index is missing while old duplicates remain.
+func checkMobileFree(w http.ResponseWriter, r *http.Request, tx pgx.Tx, mobile string, exceptUserID int) bool {
+	if err := lockMobiles(r.Context(), tx, []string{mobile}); err != nil {
+		api.InternalError(w, err)
+		return false
+	}
+
+	var userID int
+	var name string
+	err := tx.QueryRow(r.Context(), `
+		SELECT USER_ID, COALESCE(NAME, '') FROM USERS
+		WHERE MOBILE_NO = $1 AND USER_ID <> $2 AND MERGED_INTO IS NULL
+	`, mobile, exceptUserID).Scan(&userID, &name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return false
+	}
+	duplicateMobile(w, fmt.Sprintf("Mobile number %s already belongs to %s (user %d)", mobile, name, userID))
+	return false
+}
+
+// lockMobiles holds off other transactions claiming any of the mobile
+// numbers until tx ends. Numbers are locked in order so that two imports
+// sharing numbers can't deadlock.
+func lockMobiles(ctx context.Context, tx pgx.Tx, mobiles []string) error {
+	_, err := tx.Exec(ctx, `
+		SELECT PG_ADVISORY_XACT_LOCK(HASHTEXT('MOBILE_NO:' || m))
+		FROM (SELECT m FROM UNNEST($1::TEXT[]) m ORDER BY m) s
+	`, mobiles)
+	return err
+}
+
+func duplicateMobile(w http.ResponseWriter, message string) {
+	api.WriteError(w, http.StatusConflict, api.CodeDuplicateEntry, message)
+}
+
+// mobileOwners maps each of the mobile numbers already in use to its customer.
+func mobileOwners(ctx context.Context, mobiles []string) (map[string]string, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT MOBILE_NO, USER_ID, COALESCE(NAME, '') FROM USERS
+		WHERE MOBILE_NO = ANY($1) AND MERGED_INTO IS NULL
+	`, mobiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	owners := make(map[string]string)
+	for rows.Next() {
+		var mobile, name string
+		var userID int
+		if err := rows.Scan(&mobile, &userID, &name); err != nil {
+			return nil, err
+		}
+		owners[mobile] = fmt.Sprintf("%s (user %d)", name, userID)
+	}
+	return owners, rows.Err()
+}
@@ -0,0 +1,209 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// Complete months averaged over for the monthly spend
+const spendMonths = 6
+
+// GetProfile returns one customer's details along with their recent meals,
+// favourite extras, skipped shifts and spending.
+func GetProfile(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	profile, err := buildProfile(r.Context(), userID, database.BusinessDate(time.Now()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "User not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(profile)
+}
+
+func buildProfile(ctx context.Context, userID int, today time.Time) (model.UserProfile, error) {
+	var p model.UserProfile
+	u := &p.User
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		SELECT u.USER_ID, u.NAME, u.MOBILE_NO, u.BUILDING_NO, u.ROOM_NO, u.ROLE, USER_PLAN_ON(u.USER_ID, $2), COALESCE(w.BALANCE, 0),
+		       COALESCE(u.EMAIL, ''), u.LOW_BALANCE_ALERTS,
+		       COALESCE(w.CREDIT_LIMIT, 0), COALESCE(w.BALANCE_POLICY::TEXT, 'warn'),
+		       u.IS_ACTIVE, u.DEACTIVATED_AT
+		FROM USERS u
+		LEFT JOIN WALLET w ON u.USER_ID = w.USER_ID
+		WHERE u.USER_ID = $1
+	`, userID, today).Scan(&u.UserID, &u.Name, &u.MobileNo, &u.BuildingNo, &u.RoomNo, &u.Role, &u.Plan, &u.Balance, &u.Email, &u.LowBalanceAlerts,
+		&u.CreditLimit, &u.BalancePolicy, &u.IsActive, &u.DeactivatedAt)
+	if err != nil {
+		return p, err
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT WEEKDAY::TEXT, PREF::TEXT FROM USER_PREFERENCES
+		WHERE USER_ID = $1
+		ORDER BY WEEKDAY
+	`, userID)
+	if err != nil {
+		return p, err
+	}
+	p.Preferences, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.DayPreference, error) {
+		var d model.DayPreference
+		err := row.Scan(&d.Weekday, &d.Pref)
+		return d, err
+	})
+	if err != nil {
+		return p, err
+	}
+
+	var recharge model.LastRecharge
+	err = dbPool.QueryRow(ctx, `
+		SELECT TXN_ID, AMOUNT, COALESCE(VALUE_DATE, (CREATED_AT AT TIME ZONE $2)::DATE), COALESCE(REFERENCE_ID, '')
+		FROM WALLET_TRANSACTIONS
+		WHERE USER_ID = $1 AND TXN_TYPE = 'recharge' AND STATUS = 'confirmed'
+		ORDER BY CREATED_AT DESC, TXN_ID DESC
+		LIMIT 1
+	`, userID, database.BusinessTimezone()).Scan(&recharge.TxnID, &recharge.Amount, &recharge.Date, &recharge.RefID)
+	if err == nil {
+		p.LastRecharge = &recharge
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return p, err
+	}
+
+	if p.MealCounts, err = mealCounts(ctx, userID, today); err != nil {
+		return p, err
+	}
+	if p.FavouriteExtras, err = favouriteExtras(ctx, userID, today); err != nil {
+		return p, err
+	}
+
+	rows, err = dbPool.Query(ctx, `
+		SELECT SKIP_DATE, SHIFT::TEXT FROM USER_SKIP
+		WHERE USER_ID = $1 AND SKIP_DATE >= $2
+		ORDER BY SKIP_DATE DESC, SHIFT DESC
+	`, userID, today.AddDate(0, 0, -90))
+	if err != nil {
+		return p, err
+	}
+	p.Skips, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.Skip, error) {
+		var s model.Skip
+		err := row.Scan(&s.Date, &s.Shift)
+		return s, err
+	})
+	if err != nil {
+		return p, err
+	}
+
+	p.AvgMonthlySpend, err = avgMonthlySpend(ctx, userID, today)
+	return p, err
+}
+
+// mealCounts counts main meals per shift in the 30 and 90 days up to today.
+func mealCounts(ctx context.Context, userID int, today time.Time) ([]model.ShiftMealCount, error) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(ctx, `
+		SELECT MEAL_TYPE,
+			COUNT(*) FILTER (WHERE HAS_MAIN_MEAL AND LOG_DATE > $2::DATE - 30),
+			COUNT(*) FILTER (WHERE HAS_MAIN_MEAL)
+		FROM DAILY_LOGS
+		WHERE USER_ID = $1 AND LOG_DATE > $2::DATE - 90 AND LOG_DATE <= $2 AND DELETED_AT IS NULL
+		GROUP BY MEAL_TYPE
+	`, userID, today)
+	if err != nil {
+		return nil, err
+	}
+	found, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (model.ShiftMealCount, error) {
+		var c model.ShiftMealCount
+		err := row.Scan(&c.Shift, &c.Last30Days, &c.Last90Days)
+		return c, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Both shifts are always listed, even without meals
+	counts := []model.ShiftMealCount{{Shift: "lunch"}, {Shift: "dinner"}}
+	for _, f := range found {
+		for i := range counts {
+			if counts[i].Shift == f.Shift {
+				counts[i] = f
+			}
+		}
+	}
+	return counts, nil
+}
+
+// favouriteExtras totals each extra over the 90 days up to today.
+func favouriteExtras(ctx context.Context, userID int, today time.Time) ([]model.ExtraCount, error) {
+	extras := []model.ExtraCount{{Item: "rice"}, {Item: "roti"}, {Item: "chicken"}, {Item: "fish"}, {Item: "egg"}, {Item: "vegetable"}}
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(EXTRA_RICE_QTY), 0), COALESCE(SUM(EXTRA_ROTI_QTY), 0), COALESCE(SUM(EXTRA_CHICKEN_QTY), 0),
+			COALESCE(SUM(EXTRA_FISH_QTY), 0), COALESCE(SUM(EXTRA_EGG_QTY), 0), COALESCE(SUM(EXTRA_VEGETABLE_QTY), 0)
+		FROM DAILY_LOGS
+		WHERE USER_ID = $1 AND LOG_DATE > $2::DATE - 90 AND LOG_DATE <= $2 AND DELETED_AT IS NULL
+	`, userID, today).Scan(&extras[0].Quantity, &extras[1].Quantity, &extras[2].Quantity,
+		&extras[3].Quantity, &extras[4].Quantity, &extras[5].Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	favourites := []model.ExtraCount{}
+	for _, e := range extras {
+		if e.Quantity > 0 {
+			favourites = append(favourites, e)
+		}
+	}
+	sort.SliceStable(favourites, func(i, j int) bool {
+		return favourites[i].Quantity > favourites[j].Quantity
+	})
+	return favourites, nil
+}
+
+// avgMonthlySpend averages meal spending over the last complete months. A
+// customer who joined within that window is averaged over the months since
+// their first meal, so a recent joiner isn't diluted by months before they
+// started.
+func avgMonthlySpend(ctx context.Context, userID int, today time.Time) (float64, error) {
+	thisMonth := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := thisMonth.AddDate(0, -spendMonths, 0)
+
+	var total float64
+	var first *time.Time
+	dbPool := database.GetDbConn()
+	err := dbPool.QueryRow(ctx, `
+		SELECT
+			COALESCE(SUM(TOTAL_COST) FILTER (WHERE LOG_DATE >= $2), 0),
+			MIN(LOG_DATE)
+		FROM DAILY_LOGS
+		WHERE USER_ID = $1 AND LOG_DATE < $3 AND DELETED_AT IS NULL
+	`, userID, from, thisMonth).Scan(&total, &first)
+	if err != nil || first == nil {
+		return 0, err
+	}
+
+	months := spendMonths
+	if first.After(from) {
+		months = (thisMonth.Year()-first.Year())*12 + int(thisMonth.Month()-first.Month())
+	}
+	return math.Round(total/float64(months)*100) / 100, nil
+}
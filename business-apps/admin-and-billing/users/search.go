@@ -0,0 +1,109 @@
+package users
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/soumalya/food-delivery-admin/api"
+)
+
+const (
+	maxPageSize      = 200
+	defaultTypeahead = 8
+	maxTypeahead     = 20
+)
+
+var nonDigits = regexp.MustCompile(`\D`)
+
+// sortColumn is an expression users can be sorted by and its SQL type, so a
+// cursor's value can be cast back for comparison.
+type sortColumn struct {
+	expr    string
+	sqlType string
+}
+
+var sortColumns = map[string]sortColumn{
+	"name":    {"LOWER(COALESCE(u.NAME, ''))", "TEXT"},
+	"balance": {"COALESCE(w.BALANCE, 0)", "NUMERIC"},
+	"user_id": {"u.USER_ID", "INT"},
+}
+
+// userQuery builds the WHERE clause of a user search with numbered
+// arguments.
+type userQuery struct {
+	where []string
+	args  []interface{}
+}
+
+// arg adds v as the next argument and returns its placeholder.
+func (q *userQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *userQuery) and(cond string) {
+	q.where = append(q.where, cond)
+}
+
+func (q *userQuery) whereClause() string {
+	if len(q.where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.where, " AND ")
+}
+
+// likeEscape makes s match literally inside a LIKE pattern.
+func likeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// matchText adds a fuzzy match of text against name and mobile number. Names
+// match if they contain text or its letters in order ("rht" finds "Rohit"),
+// mobile numbers if their digits contain its digits. It returns the
+// expression ranking matches: prefix, then substring, then the rest.
+func (q *userQuery) matchText(text string) string {
+	text = strings.ToLower(strings.TrimSpace(text))
+	contains := q.arg("%" + likeEscape(text) + "%")
+	prefix := q.arg(likeEscape(text) + "%")
+
+	var letters []string
+	for _, c := range strings.ReplaceAll(text, " ", "") {
+		letters = append(letters, likeEscape(string(c)))
+	}
+	subsequence := q.arg("%" + strings.Join(letters, "%") + "%")
+
+	conds := []string{"LOWER(u.NAME) LIKE " + subsequence}
+	if digits := nonDigits.ReplaceAllString(text, ""); len(digits) >= 3 {
+		conds = append(conds, "REGEXP_REPLACE(COALESCE(u.MOBILE_NO, ''), '\\D', '', 'g') LIKE "+q.arg("%"+digits+"%"))
+	}
+	q.and("(" + strings.Join(conds, " OR ") + ")")
+
+	return fmt.Sprintf("CASE WHEN LOWER(u.NAME) LIKE %s THEN 0 WHEN LOWER(u.NAME) LIKE %s THEN 1 ELSE 2 END", prefix, contains)
+}
+
+// cursor marks where a page ended: the sort value and user id of its last row.
+type cursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(v *api.Validator, s string) *cursor {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	var c cursor
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	v.Check(err == nil && c.ID > 0, "cursor", "is not a cursor returned by this endpoint")
+	if err != nil {
+		return nil
+	}
+	return &c
+}
@@ -0,0 +1,54 @@
+package users
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/soumalya/food-delivery-admin/api"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []cursor{
+		{Value: "Rohit", ID: 12},
+		{Value: "", ID: 1},
+		{Value: "-250.50", ID: 7},
+		{Value: "2026-10-19T05:19:06Z", ID: 99999},
+		{Value: "Ananya \"Anu\" Sen / 4B", ID: 3},
+	}
+	for _, c := range tests {
+		t.Run(c.Value, func(t *testing.T) {
+			var v api.Validator
+			got := decodeCursor(&v, encodeCursor(c))
+			if len(v.Errors) != 0 {
+				t.Fatalf("decodeCursor(encodeCursor(%+v)) errors = %v", c, v.Errors)
+			}
+			if got == nil || *got != c {
+				t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", c, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorRejects(t *testing.T) {
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"not base64", "!!!"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"v":"a","id":1}`))},
+		{"not JSON", encode("hello")},
+		{"no id", encode(`{"v":"a"}`)},
+		{"negative id", encode(`{"v":"a","id":-1}`)},
+		{"wrong type", encode(`{"v":"a","id":"1"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v api.Validator
+			decodeCursor(&v, tt.in)
+			if len(v.Errors) != 1 || v.Errors[0].Field != "cursor" {
+				t.Errorf("decodeCursor(%q) errors = %v, want one on cursor", tt.in, v.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+)
+
+// GetClaims lists recharges waiting to be confirmed, oldest first. Customers
+// submit these themselves from the self-service API.
+func GetClaims(w http.ResponseWriter, r *http.Request) {
+	dbPool := database.GetDbConn()
+	rows, err := dbPool.Query(r.Context(), `
+		SELECT t.TXN_ID, t.USER_ID, COALESCE(u.NAME, ''), t.TXN_TYPE::TEXT, t.STATUS::TEXT, t.AMOUNT, t.BALANCE_AFTER,
+			COALESCE(t.REFERENCE_ID, ''), COALESCE(t.REASON, ''), COALESCE(t.VALUE_DATE, (t.CREATED_AT AT TIME ZONE $1)::DATE), t.CREATED_AT
+		FROM WALLET_TRANSACTIONS t
+		JOIN USERS u ON u.USER_ID = t.USER_ID
+		WHERE t.TXN_TYPE = 'recharge' AND t.STATUS = 'pending_acknowledgement'
+		ORDER BY t.CREATED_AT, t.TXN_ID
+	`, database.BusinessTimezone())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	claims, err := pgx.CollectRows(rows, ScanTransaction)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(claims)
+}
+
+// ScanTransaction reads a row of the columns GetClaims selects.
+func ScanTransaction(row pgx.CollectableRow) (model.WalletTransaction, error) {
+	var t model.WalletTransaction
+	err := row.Scan(&t.TxnID, &t.UserID, &t.Name, &t.TxnType, &t.Status, &t.Amount, &t.BalanceAfter,
+		&t.RefID, &t.Reason, &t.ValueDate, &t.CreatedAt)
+	return t, err
+}
+
+// ConfirmClaim credits a pending recharge to the customer's wallet.
+func ConfirmClaim(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	txnID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	userID, valueDate, ok := lockClaim(w, r, tx, txnID)
+	if !ok {
+		return
+	}
+	locked, err := statements.PeriodLocked(r.Context(), tx, valueDate)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for the payment date is locked")
+		return
+	}
+
+	_, err = tx.Exec(r.Context(), `SELECT CONFIRM_WALLET_RECHARGE($1)`, txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `UPDATE WALLET_TRANSACTIONS SET APPROVED_BY = $1 WHERE TXN_ID = $2`, audit.FromRequest(r).Actor, txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	var newBalance float64
+	err = tx.QueryRow(r.Context(), `SELECT BALANCE FROM WALLET WHERE USER_ID = $1`, userID).Scan(&newBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordTxn(r, tx, "wallet.claim_confirm", txnID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"txn_id": txnID, "new_balance": newBalance})
+}
+
+// RejectClaim turns down a pending recharge whose payment could not be found.
+func RejectClaim(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	txnID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var req model.ClaimRejection
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	v.Check(strings.TrimSpace(req.Reason) != "", "reason", "is required")
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	if _, _, ok := lockClaim(w, r, tx, txnID); !ok {
+		return
+	}
+	_, err = tx.Exec(r.Context(), `SELECT REJECT_WALLET_RECHARGE($1)`, txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `
+		UPDATE WALLET_TRANSACTIONS SET REASON = $1, APPROVED_BY = $2 WHERE TXN_ID = $3
+	`, strings.TrimSpace(req.Reason), audit.FromRequest(r).Actor, txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordTxn(r, tx, "wallet.claim_reject", txnID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lockClaim locks a pending recharge and returns its customer and payment
+// date. Otherwise it writes a 404 or 409 and returns false.
+func lockClaim(w http.ResponseWriter, r *http.Request, tx pgx.Tx, txnID int) (int, time.Time, bool) {
+	var userID int
+	var status string
+	var valueDate time.Time
+	err := tx.QueryRow(r.Context(), `
+		SELECT USER_ID, STATUS::TEXT, COALESCE(VALUE_DATE, (CREATED_AT AT TIME ZONE $2)::DATE)
+		FROM WALLET_TRANSACTIONS
+		WHERE TXN_ID = $1 AND TXN_TYPE = 'recharge'
+		FOR UPDATE
+	`, txnID, database.BusinessTimezone()).Scan(&userID, &status, &valueDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Recharge not found")
+		return 0, valueDate, false
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return 0, valueDate, false
+	}
+	if status != "pending_acknowledgement" {
+		api.Conflict(w, "Recharge is already "+status)
+		return 0, valueDate, false
+	}
+	return userID, valueDate, true
+}
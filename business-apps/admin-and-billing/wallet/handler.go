@@ -2,24 +2,44 @@ package wallet
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
 	"github.com/soumalya/food-delivery-admin/database"
 	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/users"
 )
 
+// MaxRecharge is the largest single recharge, to catch mistyped amounts.
+const MaxRecharge = 100000.0
+
 func RechargeWallet(w http.ResponseWriter, r *http.Request) {
 	var req model.RechargeRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+	var v api.Validator
+	if err := users.CheckActive(r.Context(), &v, "user_id", req.UserID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	v.Check(req.Amount > 0, "amount", "must be positive")
+	v.Check(req.Amount <= MaxRecharge, "amount", fmt.Sprintf("cannot exceed %.0f in one recharge", MaxRecharge))
+	if v.Rejected(w) {
 		return
 	}
 
 	dbPool := database.GetDbConn()
 	tx, err := dbPool.Begin(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 	defer tx.Rollback(r.Context())
@@ -30,14 +50,24 @@ func RechargeWallet(w http.ResponseWriter, r *http.Request) {
 		txnDate = time.Now()
 	}
 
+	locked, err := statements.PeriodLocked(r.Context(), tx, database.BusinessDate(txnDate))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
 	var txnID int
 	err = tx.QueryRow(r.Context(), `
-		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, REFERENCE_ID, CREATED_AT) 
-		VALUES ($1, 'recharge', 'pending_acknowledgement', $2, $3, $4) 
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, REFERENCE_ID, CREATED_AT, VALUE_DATE) 
+		VALUES ($1, 'recharge', 'pending_acknowledgement', $2, $3, $4, $5) 
 		RETURNING TXN_ID
-	`, req.UserID, req.Amount, req.RefID, txnDate).Scan(&txnID)
+	`, req.UserID, req.Amount, req.RefID, txnDate, database.BusinessDate(txnDate)).Scan(&txnID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
@@ -46,19 +76,133 @@ func RechargeWallet(w http.ResponseWriter, r *http.Request) {
 	// Let's use the confirmed function directly for recharges in admin app.
 	_, err = tx.Exec(r.Context(), `SELECT CONFIRM_WALLET_RECHARGE($1)`, txnID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
 		return
 	}
 
 	var newBalance float64
 	err = tx.QueryRow(r.Context(), `SELECT BALANCE FROM WALLET WHERE USER_ID = $1`, req.UserID).Scan(&newBalance)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordTxn(r, tx, "wallet.recharge", txnID); err != nil {
+		api.InternalError(w, err)
 		return
 	}
 
-	tx.Commit(r.Context())
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]interface{}{"new_balance": newBalance})
 }
+
+func CreateAdjustment(w http.ResponseWriter, r *http.Request) {
+	var req model.AdjustmentRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var v api.Validator
+	v.Check(strings.TrimSpace(req.Reason) != "", "reason", "is required")
+	v.Check(strings.TrimSpace(req.ApprovedBy) != "", "approved_by", "is required")
+	switch req.TxnType {
+	case "adjustment":
+		v.Check(req.Amount != 0, "amount", "cannot be zero")
+	case "write_off":
+		v.Check(req.Amount > 0, "amount", "must be positive for a write-off")
+	default:
+		v.Check(false, "txn_type", "must be adjustment or write_off")
+	}
+	if v.Failed(w) {
+		return
+	}
+	if err := users.CheckActive(r.Context(), &v, "user_id", req.UserID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var balance float64
+	err = tx.QueryRow(r.Context(), `SELECT BALANCE FROM WALLET WHERE USER_ID = $1 FOR UPDATE`, req.UserID).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Wallet not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	// Only outstanding dues can be written off
+	if req.TxnType == "write_off" && req.Amount > -balance {
+		api.WriteError(w, http.StatusBadRequest, api.CodeValidationFailed, "Some fields are invalid",
+			api.FieldError{Field: "amount", Message: "exceeds the outstanding dues"})
+		return
+	}
+
+	txnDate := req.TxnDate
+	if txnDate.IsZero() {
+		txnDate = time.Now()
+	}
+
+	locked, err := statements.PeriodLocked(r.Context(), tx, database.BusinessDate(txnDate))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	var newBalance float64
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET BALANCE = BALANCE + $1 WHERE USER_ID = $2 RETURNING BALANCE
+	`, req.Amount, req.UserID).Scan(&newBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	var txnID int
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, REASON, APPROVED_BY, CREATED_AT, VALUE_DATE) 
+		VALUES ($1, $2, 'confirmed', $3, $4, $5, $6, $7, $8) 
+		RETURNING TXN_ID
+	`, req.UserID, req.TxnType, req.Amount, newBalance, req.Reason, req.ApprovedBy, txnDate, database.BusinessDate(txnDate)).Scan(&txnID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := recordTxn(r, tx, "wallet."+req.TxnType, txnID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"txn_id": txnID, "new_balance": newBalance})
+}
+
+// recordTxn audits a wallet transaction created in tx.
+func recordTxn(r *http.Request, tx pgx.Tx, action string, txnID int) error {
+	return audit.RecordRow(r.Context(), tx, audit.FromRequest(r), action, "wallet_transaction", "WALLET_TRANSACTIONS", "TXN_ID", txnID, nil)
+}
@@ -0,0 +1,102 @@
+package wallet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/audit"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+)
+
+// Debit standings as returned by WALLET_DEBIT_STANDING
+const (
+	StandingOK    = "ok"
+	StandingWarn  = "warn"
+	StandingBlock = "block"
+)
+
+// CheckDebit locks the user's wallet row for the rest of the transaction and
+// reports how the wallet's balance policy treats a debit of amount.
+func CheckDebit(ctx context.Context, tx pgx.Tx, userID int, amount float64) (string, error) {
+	var standing string
+	err := tx.QueryRow(ctx, `
+		SELECT WALLET_DEBIT_STANDING(BALANCE, CREDIT_LIMIT, BALANCE_POLICY, $2)
+		FROM WALLET
+		WHERE USER_ID = $1
+		FOR UPDATE
+	`, userID, amount).Scan(&standing)
+	return standing, err
+}
+
+func UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("user_id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var p model.WalletPolicy
+	if !api.DecodeJSON(w, r, &p) {
+		return
+	}
+	if p.BalancePolicy == "" {
+		p.BalancePolicy = StandingWarn
+	}
+	var v api.Validator
+	v.Check(p.BalancePolicy == StandingBlock || p.BalancePolicy == StandingWarn || p.BalancePolicy == "allow", "balance_policy", "must be one of block, warn or allow")
+	v.Check(p.CreditLimit >= 0, "credit_limit", "cannot be negative")
+	if v.Failed(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	before, err := audit.Snapshot(r.Context(), tx, "WALLET", "USER_ID", userID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET CREDIT_LIMIT = $1, BALANCE_POLICY = $2
+		WHERE USER_ID = $3
+		RETURNING USER_ID
+	`, p.CreditLimit, p.BalancePolicy, userID).Scan(&p.UserID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Wallet not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	after, err := audit.Snapshot(r.Context(), tx, "WALLET", "USER_ID", userID)
+	if err == nil {
+		err = audit.Record(r.Context(), tx, audit.FromRequest(r), audit.Change{
+			Action: "wallet.update_policy", EntityType: "wallet", EntityID: strconv.Itoa(userID), Before: before, After: after,
+		})
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(p)
+}
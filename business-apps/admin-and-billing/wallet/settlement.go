@@ -0,0 +1,129 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/users"
+)
+
+// SettleAccount closes a leaving customer's wallet: a credit balance is paid
+// out to them, dues are collected, and the customer is deactivated. The
+// settlement is recorded as a signed 'settlement' transaction that brings
+// the balance to zero.
+func SettleAccount(w http.ResponseWriter, r *http.Request) {
+	params := api.Path(r)
+	userID := params.Int("id", true)
+	if params.Failed(w) {
+		return
+	}
+
+	var req model.SettlementRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	var balance float64
+	var active bool
+	err = tx.QueryRow(r.Context(), `
+		SELECT w.BALANCE, u.IS_ACTIVE FROM WALLET w
+		JOIN USERS u ON u.USER_ID = w.USER_ID
+		WHERE w.USER_ID = $1
+		FOR UPDATE
+	`, userID).Scan(&balance, &active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		api.NotFound(w, "Wallet not found")
+		return
+	}
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	// Settled and merged accounts are already closed
+	if !active {
+		api.Conflict(w, "Customer is not active; their account is already closed")
+		return
+	}
+
+	// Unacknowledged recharges would change what is owed
+	var pending bool
+	err = tx.QueryRow(r.Context(), `
+		SELECT EXISTS (SELECT 1 FROM WALLET_TRANSACTIONS WHERE USER_ID = $1 AND STATUS = 'pending_acknowledgement')
+	`, userID).Scan(&pending)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if pending {
+		api.Conflict(w, "Confirm or reject the customer's pending recharges before settling")
+		return
+	}
+
+	today := database.BusinessDate(time.Now())
+	locked, err := statements.PeriodLocked(r.Context(), tx, today)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	settlement := model.Settlement{UserID: userID, Direction: "none"}
+	if balance != 0 {
+		settlement.Amount = math.Abs(balance)
+		settlement.Direction = "refund"
+		if balance < 0 {
+			settlement.Direction = "collect"
+		}
+
+		_, err = tx.Exec(r.Context(), `UPDATE WALLET SET BALANCE = 0 WHERE USER_ID = $1`, userID)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		err = tx.QueryRow(r.Context(), `
+			INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, REFERENCE_ID, REASON, VALUE_DATE)
+			VALUES ($1, 'settlement', 'confirmed', $2, 0, NULLIF($3, ''), NULLIF($4, ''), $5)
+			RETURNING TXN_ID
+		`, userID, -balance, req.RefID, req.Note, today).Scan(&settlement.TxnID)
+		if err != nil {
+			api.InternalError(w, err)
+			return
+		}
+		if err := recordTxn(r, tx, "wallet.settlement", settlement.TxnID); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+	}
+
+	if _, err := users.SetActive(r, tx, userID, false); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(settlement)
+}
@@ -0,0 +1,155 @@
+package wallet
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/soumalya/food-delivery-admin/api"
+	"github.com/soumalya/food-delivery-admin/database"
+	"github.com/soumalya/food-delivery-admin/model"
+	"github.com/soumalya/food-delivery-admin/statements"
+	"github.com/soumalya/food-delivery-admin/users"
+)
+
+// TransferBalance moves balance from one user's wallet to another's, e.g.
+// when one payer recharges on behalf of several rooms. Both legs are recorded
+// as paired transactions.
+func TransferBalance(w http.ResponseWriter, r *http.Request) {
+	var req model.TransferRequest
+	if !api.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	var v api.Validator
+	v.Check(req.Amount > 0, "amount", "must be positive")
+	v.Check(req.FromUserID != req.ToUserID, "to_user_id", "cannot be the same wallet as from_user_id")
+	if v.Failed(w) {
+		return
+	}
+	if err := users.CheckActive(r.Context(), &v, "from_user_id", req.FromUserID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if err := users.CheckActive(r.Context(), &v, "to_user_id", req.ToUserID); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if v.Rejected(w) {
+		return
+	}
+
+	dbPool := database.GetDbConn()
+	tx, err := dbPool.Begin(r.Context())
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	defer tx.Rollback(r.Context())
+
+	// Lock both wallets in a fixed order so concurrent transfers between the
+	// same pair cannot deadlock
+	var walletCount int
+	err = tx.QueryRow(r.Context(), `
+		SELECT COUNT(*) FROM (
+			SELECT USER_ID FROM WALLET WHERE USER_ID IN ($1, $2) ORDER BY USER_ID FOR UPDATE
+		) w
+	`, req.FromUserID, req.ToUserID).Scan(&walletCount)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if walletCount != 2 {
+		api.NotFound(w, "Wallet not found")
+		return
+	}
+
+	standing, err := CheckDebit(r.Context(), tx, req.FromUserID, req.Amount)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if standing == StandingBlock {
+		api.InsufficientBalance(w, "Insufficient balance: transfer exceeds the payer's credit limit")
+		return
+	}
+
+	txnDate := req.TxnDate
+	if txnDate.IsZero() {
+		txnDate = time.Now()
+	}
+
+	locked, err := statements.PeriodLocked(r.Context(), tx, database.BusinessDate(txnDate))
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	if locked {
+		api.Conflict(w, "Billing period for this date is locked")
+		return
+	}
+
+	var fromBalance, toBalance float64
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET BALANCE = BALANCE - $1 WHERE USER_ID = $2 RETURNING BALANCE
+	`, req.Amount, req.FromUserID).Scan(&fromBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = tx.QueryRow(r.Context(), `
+		UPDATE WALLET SET BALANCE = BALANCE + $1 WHERE USER_ID = $2 RETURNING BALANCE
+	`, req.Amount, req.ToUserID).Scan(&toBalance)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+
+	var outID, inID int
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, REASON, COUNTERPARTY_USER_ID, CREATED_AT, VALUE_DATE)
+		VALUES ($1, 'transfer_out', 'confirmed', $2, $3, $4, $5, $6, $7)
+		RETURNING TXN_ID
+	`, req.FromUserID, req.Amount, fromBalance, req.Note, req.ToUserID, txnDate, database.BusinessDate(txnDate)).Scan(&outID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	err = tx.QueryRow(r.Context(), `
+		INSERT INTO WALLET_TRANSACTIONS (USER_ID, TXN_TYPE, STATUS, AMOUNT, BALANCE_AFTER, REASON, COUNTERPARTY_USER_ID, RELATED_TXN_ID, CREATED_AT, VALUE_DATE)
+		VALUES ($1, 'transfer_in', 'confirmed', $2, $3, $4, $5, $6, $7, $8)
+		RETURNING TXN_ID
+	`, req.ToUserID, req.Amount, toBalance, req.Note, req.FromUserID, outID, txnDate, database.BusinessDate(txnDate)).Scan(&inID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	_, err = tx.Exec(r.Context(), `UPDATE WALLET_TRANSACTIONS SET RELATED_TXN_ID = $1 WHERE TXN_ID = $2`, inID, outID)
+	if err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	for _, txnID := range []int{outID, inID} {
+		if err := recordTxn(r, tx, "wallet.transfer", txnID); err != nil {
+			api.InternalError(w, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(r.Context()); err != nil {
+		api.InternalError(w, err)
+		return
+	}
+	resp := map[string]interface{}{
+		"from_txn_id":  outID,
+		"to_txn_id":    inID,
+		"from_balance": fromBalance,
+		"to_balance":   toBalance,
+	}
+	if standing == StandingWarn {
+		resp["warning"] = "Payer's balance is below their credit limit"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,234 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Columns a sheet can have, as in Excel
+	maxColumns = 16384
+	// Largest part of a workbook read once decompressed
+	maxPartSize = 64 << 20
+)
+
+// Text of a shared or inline string, which may be split into formatted runs
+type richText struct {
+	T    string `xml:"t"`
+	Runs []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt richText) String() string {
+	if len(rt.Runs) == 0 {
+		return rt.T
+	}
+	var b strings.Builder
+	for _, run := range rt.Runs {
+		b.WriteString(run.T)
+	}
+	return b.String()
+}
+
+type worksheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R  string   `xml:"r,attr"`
+			T  string   `xml:"t,attr"`
+			V  string   `xml:"v"`
+			Is richText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// Read returns the cells of a workbook's first sheet as text, one slice per
+// row. Rows are placed by their row number, so blank rows in the sheet come
+// back empty and index i is always sheet row i+1. Sheets with cells below
+// row maxRows are refused.
+func Read(r io.ReaderAt, size int64, maxRows int) ([][]string, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheet(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var shared []string
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		var sst struct {
+			Items []richText `xml:"si"`
+		}
+		if err := decode(f, &sst); err != nil {
+			return nil, err
+		}
+		for _, si := range sst.Items {
+			shared = append(shared, si.String())
+		}
+	}
+
+	f, ok := files[sheetPath]
+	if !ok {
+		return nil, errors.New("xlsx: sheet " + sheetPath + " is missing")
+	}
+	var ws worksheet
+	if err := decode(f, &ws); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for i, row := range ws.Rows {
+		if len(row.Cells) == 0 {
+			continue
+		}
+		rowNo := row.R
+		if rowNo == 0 {
+			rowNo = i + 1
+		}
+		if rowNo < 0 || rowNo > maxRows {
+			return nil, fmt.Errorf("xlsx: sheet has more than %d rows", maxRows)
+		}
+		for len(rows) < rowNo {
+			rows = append(rows, nil)
+		}
+		var cells []string
+		for j, c := range row.Cells {
+			col := j
+			if c.R != "" {
+				col = columnIndex(c.R)
+			}
+			if col < 0 || col >= maxColumns {
+				return nil, errors.New("xlsx: cell " + c.R + " is outside the sheet")
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+			cells[col] = cellText(c.T, c.V, c.Is, shared)
+		}
+		rows[rowNo-1] = cells
+	}
+	return rows, nil
+}
+
+// firstSheet finds the part holding the workbook's first sheet.
+func firstSheet(files map[string]*zip.File) (string, error) {
+	f, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", errors.New("xlsx: not a workbook")
+	}
+	var wb struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decode(f, &wb); err != nil {
+		return "", err
+	}
+	if len(wb.Sheets) == 0 {
+		return "", errors.New("xlsx: workbook has no sheets")
+	}
+
+	// Without relationships, fall back to where workbooks usually keep it
+	f, ok = files["xl/_rels/workbook.xml.rels"]
+	if !ok {
+		return "xl/worksheets/sheet1.xml", nil
+	}
+	var rels struct {
+		Items []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decode(f, &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Items {
+		if rel.ID == wb.Sheets[0].ID {
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+	}
+	return "", errors.New("xlsx: first sheet not found")
+}
+
+func cellText(kind, value string, inline richText, shared []string) string {
+	switch kind {
+	case "s":
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= len(shared) {
+			return ""
+		}
+		return shared[i]
+	case "inlineStr":
+		return inline.String()
+	case "b":
+		if value == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "", "n":
+		// Spreadsheets store long numbers such as phone numbers in
+		// exponent form
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return value
+}
+
+// columnIndex turns a cell reference such as "AB12" into a zero-based
+// column, or -1 if the reference has no column or one past maxColumns.
+func columnIndex(ref string) int {
+	i := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		i = i*26 + int(ch-'A'+1)
+		if i > maxColumns {
+			return -1
+		}
+	}
+	return i - 1
+}
+
+func decode(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(&limitedReader{r: io.LimitReader(rc, maxPartSize+1)}).Decode(v)
+}
+
+// limitedReader fails once more than maxPartSize bytes have been read, so a
+// small upload cannot decompress into an unbounded amount of XML.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > maxPartSize {
+		return n, errors.New("xlsx: workbook is too large")
+	}
+	return n, err
+}
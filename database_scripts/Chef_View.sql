@@ -1,3 +1,4 @@
+-- Needs the functions in Functions.sql
 CREATE OR REPLACE VIEW CHEF_PREP_VIEW AS
 WITH today_day AS (
     SELECT (
@@ -30,10 +31,18 @@ monthly_orders AS (
         AND up.PREF = m.FOOD_CLASS
     JOIN USERS u
         ON u.USER_ID = up.USER_ID
-        AND u.plan = 'monthly'
+        AND USER_PLAN_ON(u.USER_ID, CURRENT_DATE) = 'monthly'
+        AND u.IS_ACTIVE
+        AND u.MERGED_INTO IS NULL
     JOIN WALLET w
         ON w.USER_ID = u.USER_ID
-        AND w.BALANCE > 0
+        -- same rule the journal applies when debiting a standard meal
+        AND WALLET_DEBIT_STANDING(
+            w.BALANCE,
+            w.CREDIT_LIMIT,
+            w.BALANCE_POLICY,
+            (SELECT PRICE FROM MEAL_PRICES WHERE ITEM_ID = 'standard')
+        ) <> 'block'
     LEFT JOIN USER_SKIP us
         ON us.USER_ID = u.USER_ID
         AND us.SKIP_DATE = CURRENT_DATE
@@ -53,6 +62,11 @@ oneoff_orders AS (
     FROM today_day td
     JOIN ONE_OFF_ORDERS o
         ON o.WEEKDAY = td.weekday_enum
+    JOIN USERS u
+        ON u.USER_ID = o.USER_ID
+        AND USER_PLAN_ON(u.USER_ID, CURRENT_DATE) = 'one_off'
+        AND u.IS_ACTIVE
+        AND u.MERGED_INTO IS NULL
     JOIN PRODUCTS p
         ON p.ITEM_ID = o.ITEM_ID
 )
@@ -3,13 +3,39 @@ CREATE TABLE DAILY_LOGS (
     USER_ID INT NOT NULL REFERENCES USERS (USER_ID),
     LOG_DATE DATE NOT NULL DEFAULT CURRENT_DATE,
     MEAL_TYPE SHIFT NOT NULL, -- 'lunch' or 'dinner'
+    HAS_MAIN_MEAL BOOLEAN NOT NULL DEFAULT TRUE,
     IS_SPECIAL BOOLEAN NOT NULL DEFAULT FALSE,
     SPECIAL_DISH_NAME TEXT,
     EXTRA_RICE_QTY INT NOT NULL DEFAULT 0,
     EXTRA_ROTI_QTY INT NOT NULL DEFAULT 0,
+    EXTRA_CHICKEN_QTY INT NOT NULL DEFAULT 0,
+    EXTRA_FISH_QTY INT NOT NULL DEFAULT 0,
+    EXTRA_EGG_QTY INT NOT NULL DEFAULT 0,
+    EXTRA_VEGETABLE_QTY INT NOT NULL DEFAULT 0,
     TOTAL_COST NUMERIC(10, 2) NOT NULL,
-    CREATED_AT TIMESTAMPTZ DEFAULT NOW()
+    CREATED_AT TIMESTAMPTZ DEFAULT NOW(),
+    -- Set when the entry is deleted; deleted entries are kept so they can be restored
+    DELETED_AT TIMESTAMPTZ,
+    DELETED_BY TEXT,
+    DELETE_REASON TEXT
 );
 
 -- Index for billing queries
 CREATE INDEX IDX_DAILY_LOGS_USER_DATE ON DAILY_LOGS (USER_ID, LOG_DATE);
+
+-- Chargeable items of each entry, priced when the entry was made so bills
+-- keep showing what was charged after MEAL_PRICES change
+CREATE TABLE DAILY_LOG_ITEMS (
+    LOG_ID INT NOT NULL REFERENCES DAILY_LOGS (LOG_ID) ON DELETE CASCADE,
+    ITEM_ID VARCHAR(50) NOT NULL,
+    ITEM_NAME VARCHAR(100) NOT NULL,
+    QUANTITY INT NOT NULL,
+    UNIT_PRICE NUMERIC(10, 2) NOT NULL,
+    AMOUNT NUMERIC(10, 2) NOT NULL,
+    -- GST treatment at the time of the entry; AMOUNT = TAXABLE_VALUE + TAX_AMOUNT
+    HSN_SAC VARCHAR(8) NOT NULL DEFAULT '',
+    TAX_RATE NUMERIC(5, 2) NOT NULL DEFAULT 0,
+    TAXABLE_VALUE NUMERIC(10, 2),
+    TAX_AMOUNT NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    PRIMARY KEY (LOG_ID, ITEM_ID)
+);
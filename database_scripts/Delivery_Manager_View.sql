@@ -1,3 +1,4 @@
+-- Needs the functions in Functions.sql
 CREATE OR REPLACE VIEW MANAGER_DELIVERY_VIEW AS
 WITH today_day AS (
     SELECT (
@@ -33,10 +34,18 @@ monthly_deliveries AS (
         ON up.WEEKDAY = td.weekday_enum
     JOIN USERS u
         ON u.USER_ID = up.USER_ID
-        AND u.plan = 'monthly'
+        AND USER_PLAN_ON(u.USER_ID, CURRENT_DATE) = 'monthly'
+        AND u.IS_ACTIVE
+        AND u.MERGED_INTO IS NULL
     JOIN WALLET w
         ON w.USER_ID = u.USER_ID
-        AND w.BALANCE > 0
+        -- same rule the journal applies when debiting a standard meal
+        AND WALLET_DEBIT_STANDING(
+            w.BALANCE,
+            w.CREDIT_LIMIT,
+            w.BALANCE_POLICY,
+            (SELECT PRICE FROM MEAL_PRICES WHERE ITEM_ID = 'standard')
+        ) <> 'block'
     JOIN MENU m
         ON m.WEEKDAY = td.weekday_enum
         AND m.FOOD_CLASS = up.PREF
@@ -69,7 +78,9 @@ oneoff_deliveries AS (
         ON o.WEEKDAY = td.weekday_enum
     JOIN USERS u
         ON u.USER_ID = o.USER_ID
-        AND u.plan = 'one_off'
+        AND USER_PLAN_ON(u.USER_ID, CURRENT_DATE) = 'one_off'
+        AND u.IS_ACTIVE
+        AND u.MERGED_INTO IS NULL
     JOIN PRODUCTS prod
         ON prod.ITEM_ID = o.ITEM_ID
 )
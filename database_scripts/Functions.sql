@@ -0,0 +1,27 @@
+-- Shared by the journal, the planning views and the admin app. Run after
+-- Users.sql and Wallet.sql and before Chef_View.sql and
+-- Delivery_Manager_View.sql.
+
+-- Single rule for whether a wallet may be debited. Debits that stay within
+-- the credit limit are 'ok', otherwise the wallet's policy decides.
+CREATE OR REPLACE FUNCTION WALLET_DEBIT_STANDING (
+	P_BALANCE NUMERIC,
+	P_CREDIT_LIMIT NUMERIC,
+	P_POLICY BALANCE_POLICY,
+	P_AMOUNT NUMERIC
+) RETURNS TEXT AS $fn$
+BEGIN
+	IF P_BALANCE - COALESCE(P_AMOUNT, 0) >= -P_CREDIT_LIMIT OR P_POLICY = 'allow' THEN
+		RETURN 'ok';
+	END IF;
+	RETURN P_POLICY::TEXT;
+END;
+$fn$ LANGUAGE PLPGSQL IMMUTABLE;
+
+-- Plan a customer was on for a given day
+CREATE OR REPLACE FUNCTION USER_PLAN_ON (P_USER_ID INT, P_DATE DATE) RETURNS SUBSCRIPTION_TYPE AS $fn$
+	SELECT COALESCE(
+		(SELECT PLAN FROM PLAN_HISTORY WHERE USER_ID = P_USER_ID AND EFFECTIVE_FROM <= P_DATE ORDER BY EFFECTIVE_FROM DESC LIMIT 1),
+		(SELECT PLAN FROM USERS WHERE USER_ID = P_USER_ID)
+	);
+$fn$ LANGUAGE SQL STABLE;
@@ -9,5 +9,26 @@ CREATE TABLE USERS (
 	BUILDING_NO TEXT,
 	ROOM_NO TEXT,
 	ROLE USER_TYPE DEFAULT 'normal',
-	PLAN SUBSCRIPTION_TYPE NOT NULL
+	PLAN SUBSCRIPTION_TYPE NOT NULL,
+	EMAIL TEXT,
+	-- Customer can opt out of low balance warnings
+	LOW_BALANCE_ALERTS BOOLEAN NOT NULL DEFAULT TRUE,
+	-- Inactive customers cannot be given new entries or recharges
+	IS_ACTIVE BOOLEAN NOT NULL DEFAULT TRUE,
+	DEACTIVATED_AT TIMESTAMPTZ,
+	-- Set when the customer turned out to be a duplicate and their history
+	-- was moved to this user
+	MERGED_INTO INT REFERENCES USERS (USER_ID)
+);
+
+-- Mobile numbers are stored in E.164 form, one customer per number
+CREATE UNIQUE INDEX USERS_MOBILE_NO_KEY ON USERS (MOBILE_NO) WHERE MERGED_INTO IS NULL;
+
+-- Effective-dated plan changes; USERS.PLAN is the plan the customer joined on
+CREATE TABLE PLAN_HISTORY (
+	USER_ID INT NOT NULL REFERENCES USERS (USER_ID),
+	PLAN SUBSCRIPTION_TYPE NOT NULL,
+	EFFECTIVE_FROM DATE NOT NULL,
+	CHANGED_AT TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (USER_ID, EFFECTIVE_FROM)
 );
\ No newline at end of file
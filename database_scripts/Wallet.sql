@@ -1,4 +1,9 @@
+CREATE TYPE BALANCE_POLICY AS ENUM('block', 'warn', 'allow');
+
 CREATE TABLE WALLET (
 	USER_ID INT PRIMARY KEY REFERENCES USERS (USER_ID) ON DELETE CASCADE,
-	BALANCE NUMERIC(10, 2) NOT NULL DEFAULT 0
-);
\ No newline at end of file
+	BALANCE NUMERIC(10, 2) NOT NULL DEFAULT 0,
+	-- How far below zero the balance may go before the policy applies
+	CREDIT_LIMIT NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (CREDIT_LIMIT >= 0),
+	BALANCE_POLICY BALANCE_POLICY NOT NULL DEFAULT 'warn'
+);
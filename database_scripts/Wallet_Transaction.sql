@@ -1,4 +1,21 @@
-CREATE TYPE TXN_TYPE AS ENUM('recharge', 'delivery', 'refund');
+CREATE TYPE TXN_TYPE AS ENUM(
+	'recharge',
+	'delivery',
+	'refund',
+	-- manual corrections by an admin, AMOUNT is signed (positive credits the wallet)
+	'adjustment',
+	-- bad debt forgiven by an admin, credits the wallet
+	'write_off',
+	-- paired legs of a wallet-to-wallet transfer
+	'transfer_in',
+	'transfer_out',
+	-- final payout or collection when a customer leaves, AMOUNT is signed and
+	-- brings the balance to zero
+	'settlement',
+	-- balance brought over when a customer is imported, AMOUNT is signed
+	-- (negative for dues owed)
+	'opening_balance'
+);
 
 CREATE TYPE TXN_STATUS AS ENUM('pending_acknowledgement', 'confirmed', 'rejected');
 
@@ -15,8 +32,17 @@ CREATE TABLE WALLET_TRANSACTIONS (
 	BALANCE_AFTER NUMERIC(10, 2),
 	-- UPI reference / UTR number for recharges
 	REFERENCE_ID TEXT,
+	-- Required for adjustments and write-offs
+	REASON TEXT,
+	APPROVED_BY TEXT,
+	-- Other side of a transfer, and the paired transaction
+	COUNTERPARTY_USER_ID INT REFERENCES USERS (USER_ID),
+	RELATED_TXN_ID INT REFERENCES WALLET_TRANSACTIONS (TXN_ID),
 	CREATED_AT TIMESTAMPTZ DEFAULT NOW(),
-	UPDATED_AT TIMESTAMPTZ DEFAULT NOW()
+	UPDATED_AT TIMESTAMPTZ DEFAULT NOW(),
+	-- Business day the transaction counts towards in bills: the log date for
+	-- deliveries and refunds, the payment date for recharges
+	VALUE_DATE DATE
 );
 
 CREATE OR REPLACE FUNCTION CONFIRM_WALLET_RECHARGE (P_TXN_ID INT) RETURNS VOID AS $$